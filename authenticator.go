@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"shunet/config"
+	"shunet/drcom"
+	"shunet/formportal"
+	"shunet/portal"
+	"shunet/shuclient"
+	"shunet/srun"
+)
+
+// newAuthenticator 创建配置的门户实现
+func newAuthenticator(cfg *config.Config) (portal.Authenticator, error) {
+	switch cfg.Portal {
+	case "", "ruijie":
+		c, err := shuclient.NewClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return c.Authenticator(), nil
+	case "srun":
+		c, err := srun.NewClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return c.Authenticator(), nil
+	case "drcom":
+		switch cfg.Drcom.Mode {
+		case "", drcom.ModeDrcom, drcom.ModeForm, drcom.ModeEportal:
+		default:
+			return nil, fmt.Errorf("unknown drcom mode %q", cfg.Drcom.Mode)
+		}
+		c, err := drcom.NewClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return c.Authenticator(), nil
+	case "form":
+		if err := formportal.Validate(&cfg.Form); err != nil {
+			return nil, err
+		}
+		c, err := formportal.NewClient(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return c.Authenticator(), nil
+	default:
+		return nil, fmt.Errorf("unknown portal %q", cfg.Portal)
+	}
+}
@@ -1,28 +1,201 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"gopkg.in/yaml.v3"
 	"io"
 	"os"
+	"path/filepath"
 	"shunet/utils"
+	"strings"
 	"sync"
 )
 
 var log = utils.Log
 
 type Config struct {
-	UserId            string `yaml:"userId"` // 学号
-	Password          string `yaml:"password"`
-	PublicKeyExponent string `yaml:"publicKeyExponent,omitempty"`
-	PublicKeyModulus  string `yaml:"publicKeyModulus,omitempty"`
-	PasswordEncrypt   string `yaml:"-"`
-	Mac               string `yaml:"mac,omitempty"`
-	Host              string `yaml:"host,omitempty"`
-	DelayTime         int    `yaml:"delayTime,omitempty"`
-	Pid               int    `yaml:"pid,omitempty"`
-	LogLevel          string `yaml:"logLevel,omitempty"`
-	Proxy             string `yaml:"proxy,omitempty"`
-	filePath          string `yaml:"-"`
+	Portal            string             `yaml:"portal,omitempty"` // 门户类型: ruijie(默认), srun, drcom, form
+	UserId            string             `yaml:"userId"`           // 学号
+	Password          string             `yaml:"password"`
+	PublicKeyExponent string             `yaml:"publicKeyExponent,omitempty"`
+	PublicKeyModulus  string             `yaml:"publicKeyModulus,omitempty"`
+	PasswordEncrypt   string             `yaml:"-"`
+	Mac               string             `yaml:"mac,omitempty"`
+	Host              string             `yaml:"host,omitempty"` // 可带协议与端口, 如 https://10.10.9.9:8443
+	TLS               TLSConfig          `yaml:"tls,omitempty"`
+	Discover          DiscoverConfig     `yaml:"discover,omitempty"`
+	DelayTime         int                `yaml:"delayTime,omitempty"`
+	LogLevel          string             `yaml:"logLevel,omitempty"`
+	Proxy             string             `yaml:"proxy,omitempty"`          // http(s)://, socks5:// 或 socks5h://, 可带用户名密码; 为空时使用 HTTP_PROXY 等环境变量
+	NoProxy           []string           `yaml:"noProxy,omitempty"`        // 不经过代理的主机、域名或网段, 如门户地址
+	Interface         string             `yaml:"interface,omitempty"`      // 认证使用的网卡, 如 eth0
+	SourceAddress     string             `yaml:"sourceAddress,omitempty"`  // 认证使用的源地址
+	Service           string             `yaml:"service,omitempty"`        // 登录的服务, 默认 shu, 可用 shunet services 查看
+	OperatorUserId    string             `yaml:"operatorUserId,omitempty"` // 运营商套餐账号
+	OperatorPwd       string             `yaml:"operatorPwd,omitempty"`    // 运营商套餐密码
+	Captcha           CaptchaConfig      `yaml:"captcha,omitempty"`
+	Srun              SrunConfig         `yaml:"srun,omitempty"`
+	Drcom             DrcomConfig        `yaml:"drcom,omitempty"`
+	Form              FormConfig         `yaml:"form,omitempty"`
+	Retry             RetryConfig        `yaml:"retry,omitempty"`
+	StateDir          string             `yaml:"stateDir,omitempty"`          // 保存会话等状态的目录, 默认为用户缓存目录下的 shunet
+	RuntimeDir        string             `yaml:"runtimeDir,omitempty"`        // 保存单实例锁与控制 socket 的目录, 默认 $XDG_RUNTIME_DIR/shunet
+	KeepSessionOnExit bool               `yaml:"keepSessionOnExit,omitempty"` // 退出时不下线, 下次启动时继续使用保存的会话
+	OnDeviceLimit     string             `yaml:"onDeviceLimit,omitempty"`     // 达到在线设备上限时: backoff(默认) 按退避重试, stop 停止重试, kick-oldest 下线最早登录的会话后重试
+	KeepAlive         KeepAliveConfig    `yaml:"keepalive,omitempty"`
+	Connectivity      ConnectivityConfig `yaml:"connectivity,omitempty"`
+	Timeout           TimeoutConfig      `yaml:"timeout,omitempty"`
+	Control           ControlConfig      `yaml:"control,omitempty"`
+	filePath          string             `yaml:"-"`
+}
+
+// CaptchaConfig 验证码识别方式
+type CaptchaConfig struct {
+	Solver  string `yaml:"solver,omitempty"`  // prompt, command, pause, 为空时不处理验证码
+	Command string `yaml:"command,omitempty"` // command 模式执行的命令, 图片从 stdin 传入, stdout 输出验证码
+	Notify  string `yaml:"notify,omitempty"`  // pause 模式执行的通知命令
+	Dir     string `yaml:"dir,omitempty"`     // pause 模式保存图片的目录
+}
+
+// TLSConfig 是访问 https 门户时校验证书的方式
+type TLSConfig struct {
+	CA                 string   `yaml:"ca,omitempty"`                 // PEM 格式的 CA 证书文件, 在系统 CA 之外额外信任
+	Fingerprints       []string `yaml:"fingerprints,omitempty"`       // 证书链中必须出现的证书 SHA-256 指纹, 配置后不再校验 CA 与域名
+	InsecureSkipVerify bool     `yaml:"insecureSkipVerify,omitempty"` // 不校验证书, 仅用于调试
+}
+
+// DiscoverConfig 从门户对明文 HTTP 请求的拦截中获取门户地址, 失败时使用上次发现的地址或 host
+type DiscoverConfig struct {
+	Enabled bool   `yaml:"enabled,omitempty"`
+	URL     string `yaml:"url,omitempty"` // 明文 HTTP 的探测地址, 默认 http://connect.rom.miui.com/generate_204
+}
+
+// SrunConfig 深澜门户的参数
+type SrunConfig struct {
+	AcId   string `yaml:"acId,omitempty"`   // 默认从首页跳转地址中获取, 获取不到时为 1
+	Ip     string `yaml:"ip,omitempty"`     // 认证的 IP, 默认使用 get_challenge 返回的 client_ip
+	Domain string `yaml:"domain,omitempty"` // 用户名后缀, 如 @cmcc
+}
+
+// DrcomConfig Dr.COM 门户的参数
+type DrcomConfig struct {
+	Mode   string `yaml:"mode,omitempty"`   // drcom(默认): /drcom/login; form: 0.htm/1.htm 表单; eportal: eportal/?c=Portal&a=login
+	Path   string `yaml:"path,omitempty"`   // form 模式提交的页面, 默认 0.htm
+	Port   int    `yaml:"port,omitempty"`   // eportal 模式的端口, 默认 801
+	Ip     string `yaml:"ip,omitempty"`     // eportal 模式上报的 IP, 默认从首页获取
+	Domain string `yaml:"domain,omitempty"` // 用户名后缀, 如 @cmcc
+}
+
+// FormConfig 通用表单门户, 登录流程完全由配置描述.
+// URL、表单等字段为 text/template 模板, 可使用 {{.UserId}}, {{.Password}}(变换后),
+// {{.RawPassword}}, {{.Timestamp}}(毫秒), vars 中的变量以及各步骤提取的变量
+type FormConfig struct {
+	Detect    FormDetect        `yaml:"detect"`
+	Login     []FormStep        `yaml:"login"`
+	KeepAlive []FormStep        `yaml:"keepalive,omitempty"` // 为空时访问 detect 确认仍然在线
+	Logout    []FormStep        `yaml:"logout,omitempty"`
+	Vars      map[string]string `yaml:"vars,omitempty"`
+	Password  []string          `yaml:"password,omitempty"` // 登录前依次对密码做的变换: md5, base64, rsa
+	RSA       FormRSA           `yaml:"rsa,omitempty"`
+}
+
+// FormDetect 访问 url, 满足 online 时视为已在线, 否则提取变量后执行 login
+type FormDetect struct {
+	URL     string        `yaml:"url"`
+	Online  FormPredicate `yaml:"online"`
+	Extract []FormExtract `yaml:"extract,omitempty"`
+	Status  []string      `yaml:"status,omitempty"` // shunet status 显示的变量
+}
+
+// FormStep 是一次 HTTP 请求
+type FormStep struct {
+	Name    string            `yaml:"name,omitempty"`
+	Method  string            `yaml:"method,omitempty"` // 默认有 form 或 json 时为 POST, 否则为 GET
+	URL     string            `yaml:"url"`
+	Query   map[string]string `yaml:"query,omitempty"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Form    map[string]string `yaml:"form,omitempty"` // application/x-www-form-urlencoded
+	JSON    map[string]string `yaml:"json,omitempty"` // application/json, 值均为字符串
+	Extract []FormExtract     `yaml:"extract,omitempty"`
+	Success *FormPredicate    `yaml:"success,omitempty"` // 为空时 HTTP 状态码为 2xx 即成功
+	Failure *FormPredicate    `yaml:"failure,omitempty"` // 先于 success 判断, 提取到的 message 变量作为错误信息
+}
+
+// FormExtract 从响应中提取变量, regex 取第一个分组, jsonPath 形如 $.data.token
+type FormExtract struct {
+	Name     string `yaml:"name"`
+	From     string `yaml:"from,omitempty"`   // body(默认), url(跳转后的地址), header
+	Header   string `yaml:"header,omitempty"` // from 为 header 时的响应头
+	Regex    string `yaml:"regex,omitempty"`
+	JSONPath string `yaml:"jsonPath,omitempty"`
+}
+
+// FormPredicate 判断响应是否满足条件, 设置的各项需同时满足
+type FormPredicate struct {
+	Status   int    `yaml:"status,omitempty"`
+	Contains string `yaml:"contains,omitempty"` // 响应体包含
+	Regex    string `yaml:"regex,omitempty"`    // 响应体匹配
+	URL      string `yaml:"url,omitempty"`      // 跳转后的地址匹配
+	JSONPath string `yaml:"jsonPath,omitempty"` // 与 equals 一起使用
+	Equals   string `yaml:"equals,omitempty"`
+}
+
+// FormRSA 是 rsa 密码变换使用的公钥, 可以是模板.
+// 设置 mac 时与锐捷 ePortal 一样加密 "password>mac"
+type FormRSA struct {
+	Exponent string `yaml:"exponent,omitempty"`
+	Modulus  string `yaml:"modulus,omitempty"`
+	Mac      string `yaml:"mac,omitempty"`
+}
+
+// RetryConfig 登录失败后的重试策略, 时间单位为秒
+type RetryConfig struct {
+	InitialDelay int     `yaml:"initialDelay,omitempty"` // 首次重试等待, 默认 5
+	Multiplier   float64 `yaml:"multiplier,omitempty"`   // 每次失败后等待时间的倍数, 默认 2
+	MaxDelay     int     `yaml:"maxDelay,omitempty"`     // 最长等待, 默认 600
+	Jitter       float64 `yaml:"jitter,omitempty"`       // 随机抖动比例, 默认 0.2
+	MaxFailures  int     `yaml:"maxFailures,omitempty"`  // 连续相同错误的次数上限, 超出后停止重试, 默认 5, 负数表示不限制
+}
+
+// KeepAliveConfig 限制门户下发的心跳间隔, 时间单位为秒
+type KeepAliveConfig struct {
+	MinInterval int `yaml:"minInterval,omitempty"` // 最短心跳间隔, 默认 10
+	MaxInterval int `yaml:"maxInterval,omitempty"` // 最长心跳间隔, 默认不限制
+}
+
+// TimeoutConfig 是访问门户的超时, 单位为秒
+type TimeoutConfig struct {
+	Connect int `yaml:"connect,omitempty"` // 建立 TCP 连接, 默认 10
+	TLS     int `yaml:"tls,omitempty"`     // TLS 握手, 默认 10
+	Request int `yaml:"request,omitempty"` // 单个请求从发出到读完响应, 默认 30
+	Logout  int `yaml:"logout,omitempty"`  // 退出时下线的最长等待, 默认 5
+}
+
+// ControlConfig 是运行中的守护进程的本地 HTTP 控制接口, 修改后需重启才能生效
+type ControlConfig struct {
+	Listen string `yaml:"listen,omitempty"` // 回环地址如 127.0.0.1:7788, 或 unix:/run/shunet.sock; 为空时不启用
+	Token  string `yaml:"token,omitempty"`  // 请求需携带 Authorization: Bearer <token>
+}
+
+// ConnectivityConfig 保活成功后再检查外网是否真的可用, 被重定向到门户时重新登录
+type ConnectivityConfig struct {
+	Enabled bool          `yaml:"enabled,omitempty"`
+	Timeout int           `yaml:"timeout,omitempty"` // 单个探测的超时, 单位秒, 默认 5
+	Probes  []ProbeConfig `yaml:"probes,omitempty"`  // 为空时使用内置的探测地址
+}
+
+// ProbeConfig 是一个连通性探测
+type ProbeConfig struct {
+	Name    string `yaml:"name,omitempty"`
+	Type    string `yaml:"type,omitempty"`    // http(默认), tcp, dns
+	URL     string `yaml:"url,omitempty"`     // http: 探测地址, 应为明文 HTTP 以便发现门户的重定向
+	Status  int    `yaml:"status,omitempty"`  // http: 期望的状态码, 设置 body 时默认 200, 否则默认 204
+	Body    string `yaml:"body,omitempty"`    // http: 期望响应体包含的内容
+	Address string `yaml:"address,omitempty"` // tcp: host:port
+	Host    string `yaml:"host,omitempty"`    // dns: 解析的域名
+	Server  string `yaml:"server,omitempty"`  // dns: 使用的 DNS 服务器 ip:port, 默认系统配置
+	Timeout int    `yaml:"timeout,omitempty"` // 单位秒, 默认使用 connectivity.timeout
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -48,10 +221,54 @@ func LoadConfig(path string) (*Config, error) {
 	if len(config.Host) == 0 {
 		config.Host = "10.10.9.9"
 	}
+	if len(config.Service) == 0 {
+		config.Service = "shu"
+	}
 	config.PasswordEncrypt = "true"
 	return &config, nil
 }
 
+// StateDirectory 返回保存会话等状态的目录
+func (c *Config) StateDirectory() (string, error) {
+	if len(c.StateDir) > 0 {
+		return c.StateDir, nil
+	}
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "shunet"), nil
+}
+
+// InstanceName 返回配置文件名加路径的摘要, 用于区分不同配置的运行时文件与状态文件
+func (c *Config) InstanceName() (string, error) {
+	path, err := filepath.Abs(c.filePath)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(path))
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return base + "-" + hex.EncodeToString(sum[:4]), nil
+}
+
+// StatePath 返回状态目录中属于该配置的文件, 如 config-1a2b3c4d.session.json
+func (c *Config) StatePath(file string) (string, error) {
+	dir, err := c.StateDirectory()
+	if err != nil {
+		return "", err
+	}
+	name, err := c.InstanceName()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name+"."+file), nil
+}
+
+// Path 返回配置文件的路径
+func (c *Config) Path() string {
+	return c.filePath
+}
+
 func (c *Config) Save() error {
 	bytes, err := yaml.Marshal(c)
 	if err != nil {
@@ -74,7 +291,7 @@ func (c *Config) Save() error {
 	}
 
 	if err = file.Sync(); err != nil {
-		log.Warning("config save Sync err: %+v", err)
+		log.Warningf("config save Sync err: %+v", err)
 	}
 	log.Info("Config.Save Save config")
 	return nil
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net/url"
+	"os"
+	"shunet/config"
+	"shunet/portal"
+	"shunet/shuclient"
+	"strings"
+)
+
+// configTemplate 是 config init 写入的配置, 完整的配置项见 README
+const configTemplate = `# shunet 配置文件, 完整的配置项见 README
+userId: "" # 学号
+password: "" # 密码
+# portal: "ruijie" # 门户类型: ruijie(默认), srun, drcom, form
+# host: "10.10.9.9" # 门户地址, 可带协议与端口
+# service: "shu" # 登录的服务, 可用 shunet services 查看
+# logLevel: "info" # debug, info, warning, error
+`
+
+const masked = "******"
+
+// runConfig 生成、查看或检查 --config 指定的配置文件
+func runConfig(args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("usage: shunet config init [-force] | shunet config show | shunet config validate")
+	}
+	switch args[0] {
+	case "init":
+		return configInit(args[1:])
+	case "show", "validate":
+		if len(args) > 1 {
+			return usageErrorf("unexpected arguments %v", args[1:])
+		}
+		cfg, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		if args[0] == "show" {
+			return configShow(cfg)
+		}
+		return configValidate(cfg)
+	default:
+		return usageErrorf("unknown config command %q", args[0])
+	}
+}
+
+func configInit(args []string) error {
+	fs := flag.NewFlagSet("config init", flag.ExitOnError)
+	force := fs.Bool("force", false, "overwrite the existing config")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !*force {
+		flags |= os.O_EXCL
+	}
+	// 配置中有密码, 只允许本用户读取
+	file, err := os.OpenFile(*configPath, flags, 0600)
+	if errors.Is(err, os.ErrExist) {
+		return fmt.Errorf("%s already exists, use -force to overwrite it", *configPath)
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	if _, err = file.WriteString(configTemplate); err != nil {
+		return err
+	}
+	return printResult(map[string]string{"config": *configPath}, "wrote "+*configPath)
+}
+
+// configShow 输出应用默认值后的配置, 隐藏密码等敏感信息
+func configShow(cfg *config.Config) error {
+	c := *cfg
+	for _, secret := range []*string{&c.Password, &c.OperatorPwd, &c.Control.Token} {
+		if len(*secret) > 0 {
+			*secret = masked
+		}
+	}
+	if u, err := url.Parse(c.Proxy); err == nil && u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			// 用户名中的 @ 会被转义, 第一个 @ 即为分隔符
+			u.User = url.User(u.User.Username())
+			c.Proxy = strings.Replace(u.String(), "@", ":"+masked+"@", 1)
+		}
+	}
+	out, err := yaml.Marshal(&c)
+	if err != nil {
+		return err
+	}
+	if !*jsonOutput {
+		fmt.Print(string(out))
+		return nil
+	}
+	// 经过 yaml 转换, 使 JSON 的字段名与配置文件一致
+	var m map[string]any
+	if err = yaml.Unmarshal(out, &m); err != nil {
+		return err
+	}
+	return json.NewEncoder(os.Stdout).Encode(m)
+}
+
+// configValidate 不访问门户, 只检查配置能否被各部分接受
+func configValidate(cfg *config.Config) error {
+	var problems []string
+	check := func(err error) {
+		if err != nil {
+			problems = append(problems, err.Error())
+		}
+	}
+	if len(cfg.UserId) == 0 {
+		check(errors.New("userId is empty"))
+	}
+	if len(cfg.Password) == 0 {
+		check(errors.New("password is empty"))
+	}
+	switch cfg.OnDeviceLimit {
+	case "", "backoff", "stop", "kick-oldest":
+	default:
+		check(fmt.Errorf("unknown onDeviceLimit %q", cfg.OnDeviceLimit))
+	}
+	if cfg.Portal == "" || cfg.Portal == "ruijie" {
+		_, err := shuclient.NewCaptchaSolver(cfg.Captcha)
+		check(err)
+	}
+	if cfg.Connectivity.Enabled {
+		_, err := portal.NewProber(cfg)
+		check(err)
+	}
+	if len(cfg.Control.Listen) > 0 {
+		check(portal.ValidateControl(cfg.Control))
+	}
+	// 门户类型、代理、证书等由各门户的 NewClient 检查
+	_, err := newAuthenticator(cfg)
+	check(err)
+
+	if len(problems) > 0 {
+		return &exitError{code: exitConfig, err: fmt.Errorf("invalid config %s: %s", cfg.Path(), strings.Join(problems, "; "))}
+	}
+	return printResult(map[string]any{"valid": true, "config": cfg.Path()}, cfg.Path()+" is valid")
+}
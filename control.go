@@ -0,0 +1,163 @@
+package main
+
+import (
+	"errors"
+	"shunet/config"
+	"shunet/instance"
+	"shunet/portal"
+)
+
+// runStop 通过控制 socket 让运行中的进程下线并退出
+func runStop(cfg *config.Config, args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("unexpected arguments %v", args)
+	}
+	client, err := controlClient(cfg)
+	if err != nil {
+		return err
+	}
+	status, err := client.Command(ctx, "stop")
+	if err != nil {
+		return err
+	}
+	return printResult(status, "stopped")
+}
+
+// runReload 通过控制 socket 让运行中的进程重新读取配置
+func runReload(cfg *config.Config, args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("unexpected arguments %v", args)
+	}
+	client, err := controlClient(cfg)
+	if err != nil {
+		return err
+	}
+	status, err := client.Command(ctx, "reload")
+	if err != nil {
+		return err
+	}
+	return printResult(status, "reloaded, state "+status.State)
+}
+
+// sessionResult 是 login 与 logout 的输出
+type sessionResult struct {
+	Online  bool                   `json:"online"`
+	Changed bool                   `json:"changed"`          // false 表示原本就是该状态
+	Daemon  *portal.StatusResponse `json:"daemon,omitempty"` // 交给运行中的进程执行时, 之后的状态
+}
+
+// runLogin 登录一次后退出. 有进程在运行时交给它登录, 以免两边的会话互相干扰
+func runLogin(cfg *config.Config, args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("unexpected arguments %v", args)
+	}
+	res, err := viaDaemon(cfg, "login")
+	if err != nil || res != nil {
+		return err
+	}
+
+	auth, err := newAuthenticator(cfg)
+	if err != nil {
+		return err
+	}
+	online, err := auth.Detect(ctx)
+	if err != nil {
+		return err
+	}
+	res = &sessionResult{Online: true, Changed: !online}
+	if !online {
+		if p, ok := auth.(portal.Preparer); ok {
+			if err = p.Prepare(ctx); err != nil {
+				return err
+			}
+		}
+		if err = auth.Login(ctx); err != nil {
+			return err
+		}
+		// 保存会话, 之后的 logout 或 run 可以直接使用
+		if store, ok := auth.(portal.SessionStore); ok {
+			if err = store.SaveSession(); err != nil {
+				log.Warningf("SaveSession err: %+v", err)
+			}
+		}
+	}
+	return printResult(res, sessionText(res))
+}
+
+// runLogout 下线. 有进程在运行时交给它下线, 它将停止自动登录直到 login
+func runLogout(cfg *config.Config, args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("unexpected arguments %v", args)
+	}
+	res, err := viaDaemon(cfg, "logout")
+	if err != nil || res != nil {
+		return err
+	}
+
+	auth, err := newAuthenticator(cfg)
+	if err != nil {
+		return err
+	}
+	online, err := auth.Detect(ctx)
+	if err != nil {
+		return err
+	}
+	res = &sessionResult{Online: false, Changed: online}
+	if online {
+		if err = auth.Logout(ctx); err != nil {
+			return err
+		}
+		if store, ok := auth.(portal.SessionStore); ok {
+			if err = store.ClearSession(); err != nil {
+				log.Warningf("ClearSession err: %+v", err)
+			}
+		}
+	}
+	return printResult(res, sessionText(res))
+}
+
+// viaDaemon 有进程在运行时交给它执行 login 或 logout 并输出结果, 没有时返回 nil
+func viaDaemon(cfg *config.Config, name string) (*sessionResult, error) {
+	client, err := controlClient(cfg)
+	if errors.Is(err, instance.ErrNotRunning) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	before, err := client.Status(ctx)
+	if err != nil {
+		return nil, err
+	}
+	after, err := client.Command(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	res := &sessionResult{
+		Online:  after.State == portal.StateOnline.String(),
+		Changed: before.State != after.State,
+		Daemon:  after,
+	}
+	return res, printResult(res, sessionText(res)+" (by the running shunet)")
+}
+
+func sessionText(res *sessionResult) string {
+	switch {
+	case res.Online && res.Changed:
+		return "logged in"
+	case res.Online:
+		return "already online"
+	case res.Changed:
+		return "logged out"
+	default:
+		return "already offline"
+	}
+}
+
+func controlClient(cfg *config.Config) (*portal.ControlClient, error) {
+	inst, err := instance.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return inst.Client()
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"shunet/config"
+	"shunet/portal"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// runDevices 列出账号的在线会话, 或让其中一个下线
+func runDevices(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("usage: shunet devices list [-json] | shunet devices kick <session|ip|mac> | shunet devices kick -oldest")
+	}
+	auth, err := newAuthenticator(cfg)
+	if err != nil {
+		return err
+	}
+	m, ok := auth.(portal.DeviceManager)
+	if !ok {
+		return fmt.Errorf("portal %q does not support device management", cfg.Portal)
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "list":
+		fs := flag.NewFlagSet("devices list", flag.ExitOnError)
+		fs.BoolVar(jsonOutput, "json", *jsonOutput, "print devices as JSON")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		devices, err := m.Devices(ctx)
+		if err != nil {
+			return err
+		}
+		var text strings.Builder
+		w := tabwriter.NewWriter(&text, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "IP\tMAC\tLOGIN TIME\tSESSION")
+		for _, d := range devices {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", d.IP, d.MAC, d.LoginTime.Format(time.DateTime), d.Session)
+		}
+		w.Flush()
+		return printResult(devices, strings.TrimSuffix(text.String(), "\n"))
+	case "kick":
+		fs := flag.NewFlagSet("devices kick", flag.ExitOnError)
+		oldest := fs.Bool("oldest", false, "kick the session that logged in first")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if !*oldest && fs.NArg() != 1 {
+			return usageErrorf("usage: shunet devices kick <session|ip|mac> | shunet devices kick -oldest")
+		}
+		devices, err := m.Devices(ctx)
+		if err != nil {
+			return err
+		}
+		target, err := pickDevice(devices, fs.Arg(0), *oldest)
+		if err != nil {
+			return err
+		}
+		if err = m.Kick(ctx, target); err != nil {
+			return err
+		}
+		return printResult(target, fmt.Sprintf("kicked %s (%s)", target.IP, target.MAC))
+	default:
+		return usageErrorf("unknown devices command %q", args[0])
+	}
+}
+
+// pickDevice 按会话标识、IP 或 MAC 选择会话, MAC 忽略大小写和分隔符
+func pickDevice(devices []portal.Device, key string, oldest bool) (portal.Device, error) {
+	if len(devices) == 0 {
+		return portal.Device{}, errors.New("no online device")
+	}
+	if oldest {
+		res := devices[0]
+		for _, d := range devices[1:] {
+			if d.LoginTime.Before(res.LoginTime) {
+				res = d
+			}
+		}
+		return res, nil
+	}
+	normalize := strings.NewReplacer(":", "", "-", "", ".", "")
+	mac := strings.ToLower(normalize.Replace(key))
+	var found []portal.Device
+	for _, d := range devices {
+		if d.Session == key || d.IP == key || (len(d.MAC) > 0 && strings.ToLower(normalize.Replace(d.MAC)) == mac) {
+			found = append(found, d)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return portal.Device{}, fmt.Errorf("no online device matches %q", key)
+	case 1:
+		return found[0], nil
+	default:
+		return portal.Device{}, fmt.Errorf("%d devices match %q, use the session instead", len(found), key)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"shunet/portal"
+	"shunet/shuclient/portaltest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPickDevice(t *testing.T) {
+	now := time.Now()
+	devices := []portal.Device{
+		{Session: "a", IP: "10.0.0.1", MAC: "aa:bb:cc:dd:ee:01", LoginTime: now},
+		{Session: "b", IP: "10.0.0.2", MAC: "AA-BB-CC-DD-EE-02", LoginTime: now.Add(-time.Hour)},
+		{Session: "c", IP: "10.0.0.2", LoginTime: now.Add(-time.Minute)},
+	}
+	tests := []struct {
+		key     string
+		oldest  bool
+		want    string
+		wantErr string
+	}{
+		{"a", false, "a", ""},
+		{"10.0.0.1", false, "a", ""},
+		{"AABB.CCDD.EE01", false, "a", ""},
+		{"aa:bb:cc:dd:ee:02", false, "b", ""},
+		{"", true, "b", ""},
+		{"10.0.0.2", false, "", "2 devices match"},
+		{"10.0.0.9", false, "", "no online device matches"},
+	}
+	for _, tt := range tests {
+		got, err := pickDevice(devices, tt.key, tt.oldest)
+		if len(tt.wantErr) > 0 {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("pickDevice(%q, %v) err = %v, want %q", tt.key, tt.oldest, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got.Session != tt.want {
+			t.Errorf("pickDevice(%q, %v) = %+v, %v, want %s", tt.key, tt.oldest, got, err, tt.want)
+		}
+	}
+	if _, err := pickDevice(nil, "", true); err == nil {
+		t.Error("pickDevice on no devices succeeded")
+	}
+}
+
+func TestDevices(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	path := testConfig(t, s, "secret")
+	old := s.AddSession("10.0.0.1", "aa:bb:cc:dd:ee:01", time.Now().Add(-2*time.Hour))
+	other := s.AddSession("10.0.0.2", "aa:bb:cc:dd:ee:02", time.Now().Add(-time.Hour))
+	last := s.AddSession("10.0.0.3", "aa:bb:cc:dd:ee:03", time.Now())
+
+	tests := []struct {
+		args []string
+		code int
+		want string
+	}{
+		{[]string{"devices", "list"}, exitOK, "10.0.0.3"},
+		{[]string{"--json", "devices", "list"}, exitOK, `"session": "` + other.UserIndex + `"`},
+		{[]string{"devices", "kick"}, exitUsage, ""},
+		{[]string{"devices", "kick", "-oldest"}, exitOK, "kicked 10.0.0.1"},
+		{[]string{"devices", "kick", "AA-BB-CC-DD-EE-02"}, exitOK, "kicked 10.0.0.2"},
+		{[]string{"devices", "kick", "10.0.0.9"}, exitFailure, ""},
+	}
+	for _, tt := range tests {
+		code, out := runCLI(t, path, tt.args...)
+		if code != tt.code || !strings.Contains(out, tt.want) {
+			t.Errorf("shunet %s = %d, %q, want %d with %q", strings.Join(tt.args, " "), code, out, tt.code, tt.want)
+		}
+	}
+	if sessions := s.Sessions(); len(sessions) != 1 || sessions[0].UserIndex != last.UserIndex {
+		t.Errorf("sessions = %+v, want only %s left after kicking %s", sessions, last.UserIndex, old.UserIndex)
+	}
+}
@@ -0,0 +1,71 @@
+package drcom
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"shunet/portal"
+	"strconv"
+)
+
+// drcom 把 Client 适配为 portal.Authenticator
+type drcom struct {
+	c *Client
+}
+
+// Authenticator 返回 Dr.COM 门户的 portal.Authenticator 实现
+func (c *Client) Authenticator() portal.Authenticator {
+	return &drcom{c: c}
+}
+
+func (d *drcom) Detect(ctx context.Context) (bool, error) {
+	info, err := d.c.CheckStatus(ctx)
+	if err != nil {
+		return false, err
+	}
+	log.Info("CheckStatus")
+	return info.Online(), nil
+}
+
+func (d *drcom) PortalHost() string {
+	return d.c.hostUrl
+}
+
+func (d *drcom) Login(ctx context.Context) error {
+	return d.c.Login(ctx)
+}
+
+// KeepAlive Dr.COM 没有心跳接口, 通过在线信息确认仍然在线
+func (d *drcom) KeepAlive(ctx context.Context) error {
+	info, err := d.c.CheckStatus(ctx)
+	if err != nil {
+		return err
+	}
+	if !info.Online() {
+		return fmt.Errorf("not online")
+	}
+	return nil
+}
+
+func (d *drcom) Logout(ctx context.Context) error {
+	return d.c.Logout(ctx)
+}
+
+func (d *drcom) Status(ctx context.Context) (*portal.Status, error) {
+	info, err := d.c.CheckStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Online() {
+		return &portal.Status{Online: false}, nil
+	}
+	return &portal.Status{
+		Online:  true,
+		UserId:  info.Uid,
+		IP:      info.IP,
+		Balance: strconv.FormatFloat(float64(info.Fee)/10000, 'f', 2, 64),
+		Items: []portal.Item{
+			{Name: "已用时长", Value: fmt.Sprintf("%d分钟", info.Time)},
+			{Name: "已用流量", Value: fmt.Sprintf("%.3fMB", float64(info.Flow)/1024)},
+		},
+	}, nil
+}
@@ -0,0 +1,479 @@
+// Package drcom 实现城市热点 Dr.COM 门户的认证, 支持三种常见的页面:
+// 新版的 /drcom/login JSONP 接口, 旧版的 0.htm/1.htm 表单, 以及 801 端口的
+// eportal/?c=Portal&a=login JSONP 接口. 在线信息取自首页脚本或 /drcom/chkstatus
+// 中的 uid, time(分钟), flow(KB).
+package drcom
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"shunet/config"
+	"shunet/portal"
+	"shunet/utils"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// 登录方式
+const (
+	ModeDrcom   = "drcom"
+	ModeForm    = "form"
+	ModeEportal = "eportal"
+)
+
+const (
+	defaultFormPath    = "0.htm"
+	defaultEportalPort = 801
+	formKey            = "123456" // 页面中固定的 0MKKey
+)
+
+var (
+	userAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"
+	log       = utils.Log
+)
+
+// Info 是首页脚本或 chkstatus 中的在线信息
+type Info struct {
+	Uid  string
+	Time int64 // 已用时长, 分钟
+	Flow int64 // 已用流量, KB
+	Fee  int64 // 余额, 单位 0.0001 元
+	IP   string
+}
+
+func (i *Info) Online() bool {
+	return len(i.Uid) > 0
+}
+
+// flexString 兼容不同版本中同一字段为数字或字符串的情况
+type flexString string
+
+func (f *flexString) UnmarshalJSON(b []byte) error {
+	s := string(b)
+	if strings.HasPrefix(s, `"`) {
+		if err := json.Unmarshal(b, &s); err != nil {
+			return err
+		}
+	} else if s == "null" {
+		s = ""
+	}
+	*f = flexString(strings.TrimSpace(s))
+	return nil
+}
+
+func (f flexString) int() int64 {
+	n, _ := strconv.ParseInt(string(f), 10, 64)
+	return n
+}
+
+// Response 是 /drcom/* 和 eportal 接口返回的 JSON
+type Response struct {
+	Result  flexString `json:"result"`
+	Msg     flexString `json:"msg"`
+	Msga    flexString `json:"msga"`
+	RetCode flexString `json:"ret_code"`
+	Uid     flexString `json:"uid"`
+	Time    flexString `json:"time"`
+	Flow    flexString `json:"flow"`
+	Fee     flexString `json:"fee"`
+	V4ip    flexString `json:"v4ip"`
+	V46ip   flexString `json:"v46ip"`
+}
+
+func (r *Response) ok() bool {
+	return r.Result == "1" || r.Result == "ok"
+}
+
+func (r *Response) info() *Info {
+	info := &Info{
+		Time: r.Time.int(),
+		Flow: r.Flow.int(),
+		Fee:  r.Fee.int(),
+		IP:   string(r.V46ip),
+	}
+	if r.ok() {
+		info.Uid = string(r.Uid)
+	}
+	if len(r.V4ip) > 0 {
+		info.IP = string(r.V4ip)
+	}
+	return info
+}
+
+// Err 在 result 不为 1 时返回 *portal.PortalError
+func (r *Response) Err() error {
+	if r.ok() {
+		return nil
+	}
+	// /drcom/login 的 msg 为页面中的 Msg 代码, eportal 的 msg 为提示文本
+	code, msga := "", string(r.Msga)
+	if _, err := strconv.Atoi(string(r.Msg)); err == nil && len(r.Msg) == 2 {
+		code = string(r.Msg)
+	} else if len(msga) == 0 {
+		msga = decodeMsg(string(r.Msg))
+	}
+	return newError(code, msga)
+}
+
+// decodeMsg eportal 的部分错误信息为 Base64 编码, 如 dXNlcmlkIGVycm9yMQ== (userid error1)
+func decodeMsg(msg string) string {
+	b, err := base64.StdEncoding.DecodeString(msg)
+	if err != nil || len(b) == 0 || !utf8.Valid(b) {
+		return msg
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) {
+			return msg
+		}
+	}
+	return string(b)
+}
+
+// codeMessages 是页面脚本中 Msg 对应的提示
+var codeMessages = map[string]string{
+	"01": "账号或密码不对，请重新输入",
+	"02": "该账号正在使用中",
+	"03": "本账号只能在指定地址使用",
+	"04": "本账号费用超支或时长流量超过限制",
+	"05": "本账号暂停使用",
+	"11": "本账号只能在指定MAC地址使用",
+}
+
+// codeKinds 是提示中没有关键字可供 portal.ClassifyMessage 分类的 Msg
+var codeKinds = map[string]error{
+	"02": portal.ErrDeviceLimit,
+	"04": portal.ErrAccountArrears,
+	"05": portal.ErrAccountLocked,
+}
+
+// msgaKinds 按顺序匹配认证服务器返回的 msga
+var msgaKinds = []struct {
+	keyword string
+	kind    error
+}{
+	{"userid error", portal.ErrWrongPassword},
+	{"ldap auth error", portal.ErrWrongPassword},
+	{"limit users err", portal.ErrDeviceLimit},
+	{"in use", portal.ErrDeviceLimit},
+	{"status_err", portal.ErrAccountLocked},
+}
+
+// newError 根据页面的 Msg 代码和 msga 生成 *portal.PortalError
+func newError(code, msga string) error {
+	msg, ok := codeMessages[code]
+	switch {
+	case !ok:
+		msg = msga
+	case code == "01" && len(msga) > 0:
+		msg = msga
+	}
+	if len(msg) == 0 {
+		msg = "Msg=" + code
+	}
+	if kind, ok := codeKinds[code]; ok {
+		return &portal.PortalError{Kind: kind, Message: msg}
+	}
+	lower := strings.ToLower(msga)
+	for _, k := range msgaKinds {
+		if len(lower) > 0 && strings.Contains(lower, k.keyword) {
+			return &portal.PortalError{Kind: k.kind, Message: msg}
+		}
+	}
+	if code == "01" && len(msga) == 0 {
+		return &portal.PortalError{Kind: portal.ErrWrongPassword, Message: msg}
+	}
+	return portal.NewError(msg)
+}
+
+var (
+	uidPattern  = regexp.MustCompile(`\buid='([^']*)'`)
+	timePattern = regexp.MustCompile(`\btime='\s*(\d+)`)
+	flowPattern = regexp.MustCompile(`\bflow='\s*(\d+)`)
+	feePattern  = regexp.MustCompile(`\bfee='\s*(\d+)`)
+	ipPattern   = regexp.MustCompile(`\bv4?6?ip='([0-9.]+)'`)
+	msgPattern  = regexp.MustCompile(`\bMsg=(\d+)\b`)
+	msgaPattern = regexp.MustCompile(`\bmsga='([^']*)'`)
+)
+
+func find(re *regexp.Regexp, page string) string {
+	if m := re.FindStringSubmatch(page); len(m) > 1 {
+		return strings.TrimSpace(m[1])
+	}
+	return ""
+}
+
+// ParsePage 从首页脚本中获取在线信息, 未登录时 Uid 为空
+func ParsePage(page string) *Info {
+	info := &Info{
+		Uid: find(uidPattern, page),
+		IP:  find(ipPattern, page),
+	}
+	info.Time, _ = strconv.ParseInt(find(timePattern, page), 10, 64)
+	info.Flow, _ = strconv.ParseInt(find(flowPattern, page), 10, 64)
+	info.Fee, _ = strconv.ParseInt(find(feePattern, page), 10, 64)
+	return info
+}
+
+// pageErr 检查表单提交后返回页面中的 Msg, success 为表示成功的代码, 没有 Msg 的页面无法确认结果
+func pageErr(page, success string) error {
+	code := find(msgPattern, page)
+	if code == success {
+		return nil
+	}
+	if len(code) == 0 {
+		return &portal.PortalError{Kind: portal.ErrUnknown, Message: "no Msg in page: " + snippet(page)}
+	}
+	return newError(code, find(msgaPattern, page))
+}
+
+// snippet 返回页面开头的一小段, 用于错误信息
+func snippet(page string) string {
+	page = strings.Join(strings.Fields(page), " ")
+	if r := []rune(page); len(r) > 80 {
+		return string(r[:80]) + "..."
+	}
+	return page
+}
+
+type Client struct {
+	cfg        *config.Config
+	httpClient *http.Client
+	hostUrl    string
+	ip         string
+}
+
+func NewClient(c *config.Config) (*Client, error) {
+	hc, err := portal.NewHTTPClient(c)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		cfg:        c,
+		httpClient: hc,
+		hostUrl:    portal.HostURL(c.Host),
+		ip:         c.Drcom.Ip,
+	}, nil
+}
+
+func (c *Client) mode() string {
+	if len(c.cfg.Drcom.Mode) == 0 {
+		return ModeDrcom
+	}
+	return c.cfg.Drcom.Mode
+}
+
+func (c *Client) username() string {
+	return c.cfg.UserId + c.cfg.Drcom.Domain
+}
+
+// eportalUrl 返回 eportal 接口的地址, 与首页同一主机和协议, 端口默认 801
+func (c *Client) eportalUrl() string {
+	scheme, host := "http", c.cfg.Host
+	if u, err := url.Parse(c.hostUrl); err == nil {
+		scheme, host = u.Scheme, u.Hostname()
+	}
+	port := c.cfg.Drcom.Port
+	if port == 0 {
+		port = defaultEportalPort
+	}
+	return scheme + "://" + net.JoinHostPort(host, strconv.Itoa(port)) + "/eportal/"
+}
+
+func (c *Client) do(req *http.Request) (string, error) {
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s: %s", req.URL.Path, resp.Status)
+	}
+	s, err := utils.DecodeContent(resp)
+	if err != nil {
+		return "", err
+	}
+	// Dr.COM 的页面为 GBK 编码
+	if !utf8.ValidString(s) {
+		return utils.ConvertGBKToUTF8([]byte(s))
+	}
+	return s, nil
+}
+
+func (c *Client) get(ctx context.Context, rawUrl string, params url.Values) (string, error) {
+	if params != nil {
+		rawUrl += "?" + params.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", rawUrl, nil)
+	if err != nil {
+		return "", err
+	}
+	return c.do(req)
+}
+
+// getJSONP 请求 JSONP 接口, 去掉回调函数后解析
+func (c *Client) getJSONP(ctx context.Context, rawUrl, callback string, params url.Values) (*Response, error) {
+	params.Set("callback", callback)
+	params.Set("v", strconv.FormatInt(time.Now().UnixMilli()%10000, 10))
+	body, err := c.get(ctx, rawUrl, params)
+	if err != nil {
+		return nil, err
+	}
+	body = strings.TrimSpace(body)
+	if i := strings.Index(body, "("); i >= 0 && strings.HasSuffix(body, ")") && !strings.HasPrefix(body, "{") {
+		body = body[i+1 : len(body)-1]
+	}
+	resp := &Response{}
+	if err = json.Unmarshal([]byte(body), resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// Page 访问首页, 从脚本中获取在线信息
+func (c *Client) Page(ctx context.Context) (*Info, error) {
+	page, err := c.get(ctx, c.hostUrl+"/", nil)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePage(page), nil
+}
+
+// CheckStatus 查询在线信息, drcom 模式使用 /drcom/chkstatus, 其他模式使用首页
+func (c *Client) CheckStatus(ctx context.Context) (*Info, error) {
+	var info *Info
+	if c.mode() == ModeDrcom {
+		resp, err := c.getJSONP(ctx, c.hostUrl+"/drcom/chkstatus", "dr1002", url.Values{"jsVersion": {"4.1"}, "lang": {"zh"}})
+		if err != nil {
+			return nil, err
+		}
+		info = resp.info()
+	} else {
+		var err error
+		if info, err = c.Page(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.cfg.Drcom.Ip) == 0 && len(info.IP) > 0 {
+		c.ip = info.IP
+	}
+	return info, nil
+}
+
+func (c *Client) Login(ctx context.Context) error {
+	switch c.mode() {
+	case ModeDrcom:
+		params := c.formParams()
+		params.Set("terminal_type", "1")
+		params.Set("lang", "zh-cn")
+		params.Set("jsVersion", "4.1")
+		resp, err := c.getJSONP(ctx, c.hostUrl+"/drcom/login", "dr1003", params)
+		if err != nil {
+			return err
+		}
+		return resp.Err()
+	case ModeForm:
+		path := c.cfg.Drcom.Path
+		if len(path) == 0 {
+			path = defaultFormPath
+		}
+		req, err := http.NewRequestWithContext(ctx, "POST", c.hostUrl+"/"+strings.TrimPrefix(path, "/"), strings.NewReader(c.formParams().Encode()))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.Header.Set("Referer", c.hostUrl+"/")
+		page, err := c.do(req)
+		if err != nil {
+			return err
+		}
+		return pageErr(page, "15")
+	case ModeEportal:
+		if len(c.ip) == 0 {
+			return fmt.Errorf("unknown wlan_user_ip, set drcom.ip in config")
+		}
+		params := c.eportalParams()
+		params.Set("a", "login")
+		params.Set("user_account", ",0,"+c.username())
+		params.Set("user_password", c.cfg.Password)
+		resp, err := c.getJSONP(ctx, c.eportalUrl(), "dr1003", params)
+		if err != nil {
+			return err
+		}
+		// ret_code 2 表示已经在线
+		if resp.RetCode == "2" {
+			return nil
+		}
+		return resp.Err()
+	default:
+		return fmt.Errorf("unknown drcom mode %q", c.mode())
+	}
+}
+
+func (c *Client) Logout(ctx context.Context) error {
+	switch c.mode() {
+	case ModeDrcom:
+		resp, err := c.getJSONP(ctx, c.hostUrl+"/drcom/logout", "dr1004", url.Values{"jsVersion": {"4.1"}})
+		if err != nil {
+			return err
+		}
+		return resp.Err()
+	case ModeForm:
+		page, err := c.get(ctx, c.hostUrl+"/F.htm", nil)
+		if err != nil {
+			return err
+		}
+		return pageErr(page, "14")
+	case ModeEportal:
+		params := c.eportalParams()
+		params.Set("a", "logout")
+		params.Set("ac_logout", "1")
+		params.Set("register_mode", "1")
+		params.Set("user_account", "drcom")
+		params.Set("user_password", formKey)
+		resp, err := c.getJSONP(ctx, c.eportalUrl(), "dr1004", params)
+		if err != nil {
+			return err
+		}
+		return resp.Err()
+	default:
+		return fmt.Errorf("unknown drcom mode %q", c.mode())
+	}
+}
+
+// formParams 是 0.htm 表单与 /drcom/login 共用的参数
+func (c *Client) formParams() url.Values {
+	return url.Values{
+		"DDDDD":  {c.username()},
+		"upass":  {c.cfg.Password},
+		"0MKKey": {formKey},
+		"R1":     {"0"},
+		"R2":     {""},
+		"R3":     {"0"},
+		"R6":     {"0"},
+		"para":   {"00"},
+		"v6ip":   {""},
+	}
+}
+
+func (c *Client) eportalParams() url.Values {
+	return url.Values{
+		"c":              {"Portal"},
+		"login_method":   {"1"},
+		"wlan_user_ip":   {c.ip},
+		"wlan_user_ipv6": {""},
+		"wlan_user_mac":  {"000000000000"},
+		"wlan_ac_ip":     {""},
+		"wlan_ac_name":   {""},
+		"jsVersion":      {"3.3.3"},
+	}
+}
@@ -0,0 +1,186 @@
+package drcom
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"shunet/drcom/drcomtest"
+	"shunet/portal"
+	"shunet/shuclient/portaltest"
+	"testing"
+)
+
+var modes = []string{ModeDrcom, ModeForm, ModeEportal}
+
+func newTestClient(t *testing.T, s *drcomtest.Server, mode, password string) portal.Authenticator {
+	t.Helper()
+	c, err := NewClient(portaltest.LoadConfig(t, fmt.Sprintf("portal: drcom\nuserId: %s\npassword: %s\nhost: %s\ndrcom: { mode: %s, port: %d }",
+		s.Uid, password, s.Host(), mode, s.EportalPort())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Authenticator()
+}
+
+func TestLoginLogout(t *testing.T) {
+	for _, mode := range modes {
+		t.Run(mode, func(t *testing.T) {
+			s := drcomtest.NewServer("20120001", "secret")
+			defer s.Close()
+			auth := newTestClient(t, s, mode, "secret")
+			ctx := context.Background()
+
+			online, err := auth.Detect(ctx)
+			if err != nil || online {
+				t.Fatalf("Detect = %v, %v, want offline", online, err)
+			}
+			if err = auth.Login(ctx); err != nil {
+				t.Fatalf("Login: %v", err)
+			}
+			sessions := s.Sessions()
+			if len(sessions) != 1 || sessions[0].Mode != mode {
+				t.Fatalf("sessions = %+v, want one session logged in by %s", sessions, mode)
+			}
+			if err = auth.KeepAlive(ctx); err != nil {
+				t.Fatalf("KeepAlive: %v", err)
+			}
+			status, err := auth.Status(ctx)
+			if err != nil {
+				t.Fatalf("Status: %v", err)
+			}
+			if !status.Online || status.UserId != s.Uid || status.Balance != "30.00" {
+				t.Errorf("Status = %+v", status)
+			}
+
+			if err = auth.Logout(ctx); err != nil {
+				t.Fatalf("Logout: %v", err)
+			}
+			if n := len(s.Sessions()); n != 0 {
+				t.Errorf("%d sessions after logout", n)
+			}
+			if err = auth.KeepAlive(ctx); err == nil {
+				t.Error("KeepAlive succeeded after logout")
+			}
+		})
+	}
+}
+
+func TestLoginError(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		failure  *drcomtest.Failure
+		want     error
+	}{
+		{name: "wrong password", password: "wrong", want: portal.ErrWrongPassword},
+		{name: "arrears", password: "secret", failure: &drcomtest.Failure{Code: "04"}, want: portal.ErrAccountArrears},
+		{name: "limit users", password: "secret", failure: &drcomtest.Failure{Code: "01", Message: drcomtest.MsgaLimitUsers}, want: portal.ErrDeviceLimit},
+	}
+	endpoints := map[string]string{
+		ModeDrcom:   drcomtest.EndpointLogin,
+		ModeForm:    drcomtest.EndpointForm,
+		ModeEportal: drcomtest.EndpointEportalLogin,
+	}
+	for _, mode := range modes {
+		for _, tt := range tests {
+			// eportal 接口只返回提示文本, 没有 Msg 代码
+			if mode == ModeEportal && tt.failure != nil && len(tt.failure.Message) == 0 {
+				continue
+			}
+			t.Run(mode+"/"+tt.name, func(t *testing.T) {
+				s := drcomtest.NewServer("20120001", "secret")
+				defer s.Close()
+				if tt.failure != nil {
+					s.Inject(endpoints[mode], *tt.failure)
+				}
+				auth := newTestClient(t, s, mode, tt.password)
+				ctx := context.Background()
+				if _, err := auth.Detect(ctx); err != nil {
+					t.Fatal(err)
+				}
+				if err := auth.Login(ctx); !errors.Is(err, tt.want) {
+					t.Errorf("Login err = %v, want %v", err, tt.want)
+				}
+			})
+		}
+	}
+}
+
+func TestNewError(t *testing.T) {
+	tests := []struct {
+		code, msga string
+		want       error
+		message    string
+	}{
+		{"01", "", portal.ErrWrongPassword, codeMessages["01"]},
+		{"01", "userid error1", portal.ErrWrongPassword, "userid error1"},
+		{"01", "userid error2", portal.ErrWrongPassword, "userid error2"},
+		{"01", "ldap auth error", portal.ErrWrongPassword, "ldap auth error"},
+		{"01", "Rad:Limit Users Err", portal.ErrDeviceLimit, "Rad:Limit Users Err"},
+		{"", "The account is in use", portal.ErrDeviceLimit, "The account is in use"},
+		{"", "Rad:Status_Err", portal.ErrAccountLocked, "Rad:Status_Err"},
+		{"02", "", portal.ErrDeviceLimit, codeMessages["02"]},
+		{"04", "", portal.ErrAccountArrears, codeMessages["04"]},
+		{"05", "", portal.ErrAccountLocked, codeMessages["05"]},
+		{"04", "userid error1", portal.ErrAccountArrears, codeMessages["04"]},
+		{"99", "", portal.ErrUnknown, "Msg=99"},
+	}
+	for _, tt := range tests {
+		err := newError(tt.code, tt.msga)
+		if !errors.Is(err, tt.want) {
+			t.Errorf("newError(%q, %q) = %v, want kind %v", tt.code, tt.msga, err, tt.want)
+		}
+		var pe *portal.PortalError
+		if !errors.As(err, &pe) || pe.Message != tt.message {
+			t.Errorf("newError(%q, %q) = %v, want message %q", tt.code, tt.msga, err, tt.message)
+		}
+	}
+}
+
+func TestPageErr(t *testing.T) {
+	tests := []struct {
+		page string
+		want error
+	}{
+		{"<script>Msg=15;msga='';</script>", nil},
+		{"<script>Msg=01;msga='';</script>", portal.ErrWrongPassword},
+		{"<script>Msg=14;msga='';</script>", portal.ErrUnknown},
+		{"<html><title>认证成功页</title></html>", portal.ErrUnknown},
+		{"", portal.ErrUnknown},
+	}
+	for _, tt := range tests {
+		if err := pageErr(tt.page, "15"); !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+			t.Errorf("pageErr(%q) = %v, want %v", tt.page, err, tt.want)
+		}
+	}
+
+	// 表单登录返回的页面没有 Msg 时不能当作成功
+	s := drcomtest.NewServer("20120001", "secret")
+	defer s.Close()
+	s.Inject(drcomtest.EndpointForm, drcomtest.Failure{Body: "<html><title>系统维护中</title></html>"})
+	auth := newTestClient(t, s, ModeForm, "secret")
+	if _, err := auth.Detect(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.Login(context.Background()); !errors.Is(err, portal.ErrUnknown) {
+		t.Errorf("Login err = %v, want ErrUnknown", err)
+	}
+}
+
+func TestDecodeMsg(t *testing.T) {
+	tests := []struct {
+		msg, want string
+	}{
+		{"dXNlcmlkIGVycm9yMQ==", "userid error1"},
+		{"UmFkOkxpbWl0IFVzZXJzIEVycg==", "Rad:Limit Users Err"},
+		{"6K6k6K+B5aSx6LSl", "认证失败"},
+		{"用户不在线", "用户不在线"},
+		{"AAEC", "AAEC"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := decodeMsg(tt.msg); got != tt.want {
+			t.Errorf("decodeMsg(%q) = %q, want %q", tt.msg, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+// Package drcomtest 提供一个进程内的 Dr.COM 门户模拟服务器, 用于离线测试 drcom.
+//
+// 同时模拟三种页面: /drcom/login, /drcom/chkstatus, /drcom/logout JSONP 接口,
+// 旧版的 0.htm/1.htm 表单与 F.htm 注销页, 以及另一个端口上的
+// eportal/?c=Portal&a=login|logout. 首页以 GBK 编码返回, 在线时脚本中带有 uid, time, flow.
+package drcomtest
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"shunet/shuclient/portaltest"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// 可注入故障的接口名
+const (
+	EndpointRoot          = "root"
+	EndpointLogin         = "login"          // /drcom/login
+	EndpointStatus        = "chkstatus"      // /drcom/chkstatus
+	EndpointLogout        = "logout"         // /drcom/logout
+	EndpointForm          = "form"           // 0.htm, 1.htm
+	EndpointFormLogout    = "F.htm"          // F.htm
+	EndpointEportalLogin  = "eportal_login"  // eportal/?a=login
+	EndpointEportalLogout = "eportal_logout" // eportal/?a=logout
+)
+
+// 认证服务器返回的 msga
+const (
+	MsgaUserNotFound = "userid error1"
+	MsgaWrongPass    = "userid error2"
+	MsgaLimitUsers   = "Rad:Limit Users Err"
+)
+
+// Failure 描述一次注入的故障. Code 为返回的 Msg 代码(如 04 欠费), JSONP 接口返回 result=0;
+// Message 为与 Code 一起返回的 msga
+type Failure = portaltest.Failure
+
+// Session 是服务器上的一个在线会话
+type Session struct {
+	Uid       string
+	IP        string
+	Mode      string // 登录使用的页面: drcom, form, eportal
+	LoginTime time.Time
+}
+
+func (s Session) LoginAt() time.Time {
+	return s.LoginTime
+}
+
+// Server 是模拟的 Dr.COM 服务器, 导出字段需在发起请求前设置
+type Server struct {
+	*httptest.Server
+	*portaltest.Fixture[Session] // 会话以 IP 为键
+
+	Eportal *httptest.Server // eportal 接口所在的服务器, 真实环境中为 801 端口
+
+	Uid      string // 完整的账号, 包含 domain
+	Password string
+	Flow     int64 // 已用流量, KB
+	Fee      int64 // 余额, 单位 0.0001 元
+}
+
+// NewServer 启动一个模拟服务器, 使用完毕后需调用 Close
+func NewServer(uid, password string) *Server {
+	s := &Server{
+		Uid:      uid,
+		Password: password,
+		Flow:     2048,
+		Fee:      300000,
+		// 注入的 Code 由各页面按自己的格式返回
+		Fixture: portaltest.NewFixture[Session](nil),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleRoot)
+	mux.HandleFunc("/0.htm", s.handleForm)
+	mux.HandleFunc("/1.htm", s.handleForm)
+	mux.HandleFunc("/F.htm", s.handleFormLogout)
+	mux.HandleFunc("/drcom/login", s.handleLogin)
+	mux.HandleFunc("/drcom/chkstatus", s.handleStatus)
+	mux.HandleFunc("/drcom/logout", s.handleLogout)
+	s.Server = httptest.NewServer(mux)
+
+	eportal := http.NewServeMux()
+	eportal.HandleFunc("/eportal/", s.handleEportal)
+	s.Eportal = httptest.NewServer(eportal)
+	return s
+}
+
+// Close 关闭两个服务器
+func (s *Server) Close() {
+	s.Server.Close()
+	s.Eportal.Close()
+}
+
+// Host 返回可直接填入 config.Config.Host 的地址
+func (s *Server) Host() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// EportalPort 返回可直接填入 config.DrcomConfig.Port 的端口
+func (s *Server) EportalPort() int {
+	_, port, _ := net.SplitHostPort(strings.TrimPrefix(s.Eportal.URL, "http://"))
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+func writeJSONP(w http.ResponseWriter, r *http.Request, v any) {
+	b, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "text/javascript;charset=UTF-8")
+	if cb := r.URL.Query().Get("callback"); len(cb) > 0 {
+		fmt.Fprintf(w, "%s(%s)", cb, b)
+		return
+	}
+	_, _ = w.Write(b)
+}
+
+// writeGBK 与真实门户一样以 GBK 返回页面
+func writeGBK(w http.ResponseWriter, page string) {
+	gbk, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(page))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html;charset=gb2312")
+	_, _ = w.Write(gbk)
+}
+
+func (s *Server) session(ip string) *Session {
+	if sess, ok := s.Session(ip); ok {
+		return &sess
+	}
+	return nil
+}
+
+// check 校验账号密码, 返回 Msg 代码和 msga, 成功时代码为空
+func (s *Server) check(uid, password string) (code, msga string) {
+	switch {
+	case uid != s.Uid:
+		return "01", MsgaUserNotFound
+	case password != s.Password:
+		return "01", MsgaWrongPass
+	}
+	return "", ""
+}
+
+func (s *Server) online(uid, ip, mode string) {
+	s.PutSession(ip, Session{Uid: uid, IP: ip, Mode: mode, LoginTime: time.Now()})
+}
+
+func (s *Server) offline(ip string) bool {
+	return s.DeleteSession(ip)
+}
+
+// script 生成页面中携带在线信息的脚本
+func (s *Server) script(ip string, sess *Session, code, msga string) string {
+	var b strings.Builder
+	b.WriteString("<script language=\"JavaScript\">")
+	if sess != nil {
+		minutes := int64(time.Since(sess.LoginTime).Minutes())
+		fmt.Fprintf(&b, "time='%-10d';flow='%-10d';fsele=1;fee='%-10d';xsele=0;uid='%s';", minutes, s.Flow, s.Fee, sess.Uid)
+	}
+	fmt.Fprintf(&b, "v46m=0;v4ip='%s';v6ip='::';", ip)
+	if len(code) > 0 {
+		fmt.Fprintf(&b, "Msg=%s;msga='%s';", code, msga)
+	}
+	b.WriteString("</script>")
+	return b.String()
+}
+
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if _, done := s.Intercept(EndpointRoot, w, r); done {
+		return
+	}
+	ip := portaltest.RemoteIP(r)
+	sess := s.session(ip)
+	title := "上网登录页"
+	if sess != nil {
+		title = "注销页"
+	}
+	writeGBK(w, "<html><head><title>"+title+"</title>"+s.script(ip, sess, "", "")+"</head><body></body></html>")
+}
+
+func (s *Server) handleForm(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	f, done := s.Intercept(EndpointForm, w, r)
+	if done {
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ip := portaltest.RemoteIP(r)
+	code, msga := f.Code, f.Message
+	if len(code) == 0 {
+		code, msga = s.check(r.PostForm.Get("DDDDD"), r.PostForm.Get("upass"))
+	}
+	if len(code) > 0 {
+		writeGBK(w, "<html><head><title>信息返回窗</title>"+s.script(ip, nil, code, msga)+"</head><body></body></html>")
+		return
+	}
+	s.online(r.PostForm.Get("DDDDD"), ip, "form")
+	writeGBK(w, "<html><head><title>认证成功页</title>"+s.script(ip, nil, "15", "")+"</head><body>You have successfully logged into our system.</body></html>")
+}
+
+func (s *Server) handleFormLogout(w http.ResponseWriter, r *http.Request) {
+	if _, done := s.Intercept(EndpointFormLogout, w, r); done {
+		return
+	}
+	ip := portaltest.RemoteIP(r)
+	s.offline(ip)
+	writeGBK(w, "<html><head><title>信息返回窗</title>"+s.script(ip, nil, "14", "")+"</head><body></body></html>")
+}
+
+func (s *Server) handleLogin(w http.ResponseWriter, r *http.Request) {
+	f, done := s.Intercept(EndpointLogin, w, r)
+	if done {
+		return
+	}
+	q := r.URL.Query()
+	ip := portaltest.RemoteIP(r)
+	code, msga := f.Code, f.Message
+	if len(code) == 0 {
+		code, msga = s.check(q.Get("DDDDD"), q.Get("upass"))
+	}
+	if len(code) > 0 {
+		writeJSONP(w, r, map[string]any{"result": 0, "msg": code, "msga": msga})
+		return
+	}
+	s.online(q.Get("DDDDD"), ip, "drcom")
+	writeJSONP(w, r, map[string]any{"result": 1, "uid": q.Get("DDDDD"), "v46ip": ip, "olmac": "000000000000"})
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if _, done := s.Intercept(EndpointStatus, w, r); done {
+		return
+	}
+	ip := portaltest.RemoteIP(r)
+	sess := s.session(ip)
+	if sess == nil {
+		writeJSONP(w, r, map[string]any{"result": 0, "v46ip": ip})
+		return
+	}
+	writeJSONP(w, r, map[string]any{
+		"result": 1,
+		"uid":    sess.Uid,
+		"time":   int64(time.Since(sess.LoginTime).Minutes()),
+		"flow":   s.Flow,
+		"fee":    s.Fee,
+		"v4ip":   ip,
+		"v46ip":  ip,
+	})
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if _, done := s.Intercept(EndpointLogout, w, r); done {
+		return
+	}
+	if !s.offline(portaltest.RemoteIP(r)) {
+		writeJSONP(w, r, map[string]any{"result": 0, "msg": "not online"})
+		return
+	}
+	writeJSONP(w, r, map[string]any{"result": 1})
+}
+
+// handleEportal 按 wlan_user_ip 登录, 部分错误信息以 Base64 返回
+func (s *Server) handleEportal(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("c") != "Portal" {
+		http.NotFound(w, r)
+		return
+	}
+	ip := q.Get("wlan_user_ip")
+	switch q.Get("a") {
+	case "login":
+		f, done := s.Intercept(EndpointEportalLogin, w, r)
+		if done {
+			return
+		}
+		if ip != portaltest.RemoteIP(r) {
+			writeJSONP(w, r, map[string]any{"result": "0", "msg": "IP地址不匹配", "ret_code": "1"})
+			return
+		}
+		if s.session(ip) != nil {
+			writeJSONP(w, r, map[string]any{"result": "0", "msg": "", "ret_code": "2"})
+			return
+		}
+		// user_account 格式为 ",0,账号"
+		account := q.Get("user_account")
+		if i := strings.LastIndex(account, ","); i >= 0 {
+			account = account[i+1:]
+		}
+		code, msga := f.Code, f.Message
+		if len(code) == 0 {
+			code, msga = s.check(account, q.Get("user_password"))
+		}
+		if len(code) > 0 {
+			writeJSONP(w, r, map[string]any{"result": "0", "msg": base64.StdEncoding.EncodeToString([]byte(msga)), "ret_code": "1"})
+			return
+		}
+		s.online(account, ip, "eportal")
+		writeJSONP(w, r, map[string]any{"result": "1", "msg": "Portal协议认证成功！"})
+	case "logout":
+		if _, done := s.Intercept(EndpointEportalLogout, w, r); done {
+			return
+		}
+		if !s.offline(ip) {
+			writeJSONP(w, r, map[string]any{"result": "0", "msg": "用户不在线"})
+			return
+		}
+		writeJSONP(w, r, map[string]any{"result": "1", "msg": "注销成功"})
+	default:
+		http.NotFound(w, r)
+	}
+}
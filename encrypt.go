@@ -0,0 +1,52 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"shunet/config"
+	"shunet/shuclient"
+	"strings"
+)
+
+// runEncrypt 输出锐捷 ePortal 登录时发送的加密密码, 便于排查或在脚本中直接调用接口.
+// 未指定密码时使用配置中的密码, 为 - 时从 stdin 读取, 以免出现在进程列表中
+func runEncrypt(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("encrypt", flag.ExitOnError)
+	mac := fs.String("mac", "", "mac appended to the password, default the one in the portal redirect")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return usageErrorf("usage: shunet encrypt [-mac mac] [password|-]")
+	}
+	if len(cfg.Portal) > 0 && cfg.Portal != "ruijie" {
+		return fmt.Errorf("portal %q does not encrypt passwords with the ePortal public key", cfg.Portal)
+	}
+
+	password := cfg.Password
+	if fs.NArg() == 1 {
+		password = fs.Arg(0)
+	}
+	if password == "-" {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && len(line) == 0 {
+			return fmt.Errorf("read password: %w", err)
+		}
+		password = strings.TrimRight(line, "\r\n")
+	}
+
+	client, err := shuclient.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	encrypted, err := client.EncryptPassword(ctx, password, *mac)
+	if err != nil {
+		return err
+	}
+	if len(*mac) == 0 {
+		*mac = cfg.Mac
+	}
+	return printResult(map[string]string{"password": encrypted, "mac": *mac}, encrypted)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"shunet/instance"
+	"shunet/portal"
+)
+
+// 退出码, 见 usage
+const (
+	exitOK       = 0
+	exitFailure  = 1
+	exitUsage    = 2
+	exitConfig   = 3
+	exitRejected = 4
+	exitOffline  = 5
+	exitInstance = 6
+)
+
+// exitError 指定退出码, err 为 nil 时不输出错误信息
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	if e.err == nil {
+		return fmt.Sprintf("exit code %d", e.code)
+	}
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+func usageErrorf(format string, a ...any) error {
+	return &exitError{code: exitUsage, err: fmt.Errorf(format, a...)}
+}
+
+func exitCode(err error) int {
+	var e *exitError
+	var portalErr *portal.PortalError
+	switch {
+	case err == nil:
+		return exitOK
+	case errors.As(err, &e):
+		return e.code
+	case errors.Is(err, instance.ErrRunning), errors.Is(err, instance.ErrNotRunning):
+		return exitInstance
+	case errors.As(err, &portalErr):
+		return exitRejected
+	default:
+		return exitFailure
+	}
+}
+
+// exit 输出错误并返回退出码. 使用 --json 时错误以 JSON 输出到 stdout, 否则写入日志
+func exit(err error) int {
+	code := exitCode(err)
+	var e *exitError
+	if err == nil || (errors.As(err, &e) && e.err == nil) {
+		return code
+	}
+	if *jsonOutput {
+		_ = json.NewEncoder(os.Stdout).Encode(map[string]any{"error": err.Error(), "exitCode": code})
+	} else {
+		log.Error(err)
+	}
+	return code
+}
+
+// printResult 使用 --json 时输出 v, 否则输出 text
+func printResult(v any, text string) error {
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	fmt.Println(text)
+	return nil
+}
@@ -0,0 +1,68 @@
+package formportal
+
+import (
+	"golang.org/x/net/context"
+	"net/url"
+	"shunet/portal"
+)
+
+// formPortal 把 Client 适配为 portal.Authenticator
+type formPortal struct {
+	c *Client
+}
+
+// Authenticator 返回通用表单门户的 portal.Authenticator 实现
+func (c *Client) Authenticator() portal.Authenticator {
+	return &formPortal{c: c}
+}
+
+func (f *formPortal) Detect(ctx context.Context) (bool, error) {
+	online, err := f.c.Detect(ctx)
+	if err != nil {
+		return false, err
+	}
+	log.Info("form detect")
+	return online, nil
+}
+
+// PortalHost 返回 detect.url 的主机, 门户重定向的目标通常与之相同
+func (f *formPortal) PortalHost() string {
+	raw, err := f.c.render(f.c.form.Detect.URL)
+	if err != nil {
+		return ""
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return ""
+	}
+	return u.Host
+}
+
+func (f *formPortal) Login(ctx context.Context) error {
+	return f.c.Login(ctx)
+}
+
+func (f *formPortal) KeepAlive(ctx context.Context) error {
+	return f.c.KeepAlive(ctx)
+}
+
+func (f *formPortal) Logout(ctx context.Context) error {
+	return f.c.Logout(ctx)
+}
+
+func (f *formPortal) Status(ctx context.Context) (*portal.Status, error) {
+	online, err := f.c.Detect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !online {
+		return &portal.Status{Online: false}, nil
+	}
+	status := &portal.Status{Online: true, UserId: f.c.cfg.UserId}
+	for _, name := range f.c.form.Detect.Status {
+		if v, ok := f.c.Var(name); ok {
+			status.Items = append(status.Items, portal.Item{Name: name, Value: v})
+		}
+	}
+	return status, nil
+}
@@ -0,0 +1,623 @@
+// Package formportal 是由配置驱动的通用表单门户, 用于酒店、会议、访客网络等
+// 没有专门实现的门户: 访问 detect.url 判断是否在线, 然后依次执行 login 中的
+// HTTP 步骤, 步骤之间通过正则或 JSONPath 提取的变量传递 token 等信息.
+package formportal
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/url"
+	"regexp"
+	"shunet/config"
+	"shunet/portal"
+	"shunet/rsa"
+	"shunet/utils"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	userAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"
+	log       = utils.Log
+)
+
+// 密码变换
+const (
+	TransformMD5    = "md5"
+	TransformBase64 = "base64"
+	TransformRSA    = "rsa"
+)
+
+// 提取变量的来源
+const (
+	FromBody   = "body"
+	FromURL    = "url"
+	FromHeader = "header"
+)
+
+// messageVar 是失败时作为错误信息的变量名
+const messageVar = "message"
+
+// response 是一次请求的结果, url 为跟随跳转后的地址
+type response struct {
+	status int
+	url    string
+	header http.Header
+	body   string
+}
+
+type Client struct {
+	cfg        *config.Config
+	form       *config.FormConfig
+	httpClient *http.Client
+	vars       map[string]string
+}
+
+func NewClient(c *config.Config) (*Client, error) {
+	hc, err := portal.NewHTTPClient(c)
+	if err != nil {
+		return nil, err
+	}
+	client := &Client{
+		cfg:        c,
+		form:       &c.Form,
+		httpClient: hc,
+		vars:       make(map[string]string),
+	}
+	for k, v := range c.Form.Vars {
+		client.vars[k] = v
+	}
+	client.vars["UserId"] = c.UserId
+	client.vars["RawPassword"] = c.Password
+	client.vars["Password"] = c.Password
+	return client, nil
+}
+
+// Validate 检查配置中的模板、正则、JSONPath 和密码变换.
+// 模板只能引用内置变量、vars 以及在此之前的 detect 或步骤提取的变量
+func Validate(f *config.FormConfig) error {
+	if len(f.Detect.URL) == 0 {
+		return fmt.Errorf("form.detect.url is empty")
+	}
+	if f.Detect.Online == (config.FormPredicate{}) {
+		return fmt.Errorf("form.detect.online is empty")
+	}
+	if len(f.Login) == 0 {
+		return fmt.Errorf("form.login is empty")
+	}
+	known := map[string]bool{"UserId": true, "RawPassword": true, "Password": true, "Timestamp": true}
+	for k := range f.Vars {
+		known[k] = true
+	}
+	if err := checkTemplate("detect.url", f.Detect.URL, known); err != nil {
+		return err
+	}
+	if err := checkPredicate("detect.online", &f.Detect.Online); err != nil {
+		return err
+	}
+	if err := checkExtract("detect", f.Detect.Extract); err != nil {
+		return err
+	}
+	known = withExtracted(known, f.Detect.Extract)
+	for _, t := range f.Password {
+		switch t {
+		case TransformMD5, TransformBase64:
+		case TransformRSA:
+			if len(f.RSA.Exponent) == 0 || len(f.RSA.Modulus) == 0 {
+				return fmt.Errorf("form.rsa.exponent and form.rsa.modulus are required by rsa transform")
+			}
+			for name, text := range map[string]string{"rsa.exponent": f.RSA.Exponent, "rsa.modulus": f.RSA.Modulus, "rsa.mac": f.RSA.Mac} {
+				if err := checkTemplate(name, text, known); err != nil {
+					return err
+				}
+			}
+		default:
+			return fmt.Errorf("unknown password transform %q", t)
+		}
+	}
+	known, err := checkSteps("login", f.Login, known)
+	if err != nil {
+		return err
+	}
+	// keepalive 与 logout 都在登录之后执行
+	if _, err = checkSteps("keepalive", f.KeepAlive, known); err != nil {
+		return err
+	}
+	_, err = checkSteps("logout", f.Logout, known)
+	return err
+}
+
+// checkSteps 依次检查一组步骤, 返回加上这些步骤提取的变量后的已知变量
+func checkSteps(group string, steps []config.FormStep, known map[string]bool) (map[string]bool, error) {
+	for i := range steps {
+		name := fmt.Sprintf("%s[%d]", group, i)
+		if err := checkStep(name, &steps[i], known); err != nil {
+			return nil, err
+		}
+		known = withExtracted(known, steps[i].Extract)
+	}
+	return known, nil
+}
+
+// withExtracted 返回加上 extract 中变量名的副本
+func withExtracted(known map[string]bool, extract []config.FormExtract) map[string]bool {
+	res := make(map[string]bool, len(known)+len(extract))
+	for k := range known {
+		res[k] = true
+	}
+	for _, e := range extract {
+		res[e.Name] = true
+	}
+	return res
+}
+
+// checkTemplate 解析模板, 并检查其中 {{.Name}} 引用的变量是否已知
+func checkTemplate(name, text string, known map[string]bool) error {
+	t, err := template.New(name).Parse(text)
+	if err != nil {
+		return fmt.Errorf("form %s: %w", name, err)
+	}
+	if t.Tree == nil {
+		return nil
+	}
+	var missing []string
+	walkFields(t.Tree.Root, func(field string) {
+		if !known[field] {
+			missing = append(missing, field)
+		}
+	})
+	if len(missing) > 0 {
+		return fmt.Errorf("form %s: unknown variable %s", name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// walkFields 对模板中每个 .Name 引用调用 f
+func walkFields(node parse.Node, f func(field string)) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n != nil {
+			for _, c := range n.Nodes {
+				walkFields(c, f)
+			}
+		}
+	case *parse.ActionNode:
+		walkFields(n.Pipe, f)
+	case *parse.PipeNode:
+		if n != nil {
+			for _, cmd := range n.Cmds {
+				for _, arg := range cmd.Args {
+					walkFields(arg, f)
+				}
+			}
+		}
+	case *parse.FieldNode:
+		f(n.Ident[0])
+	case *parse.IfNode:
+		walkFields(n.Pipe, f)
+		walkFields(n.List, f)
+		walkFields(n.ElseList, f)
+	case *parse.RangeNode:
+		// range 与 with 内部的 . 不再是变量表
+		walkFields(n.Pipe, f)
+		walkFields(n.ElseList, f)
+	case *parse.WithNode:
+		walkFields(n.Pipe, f)
+		walkFields(n.ElseList, f)
+	}
+}
+
+func checkPredicate(name string, p *config.FormPredicate) error {
+	if len(p.Regex) > 0 {
+		if _, err := regexp.Compile(p.Regex); err != nil {
+			return fmt.Errorf("form %s.regex: %w", name, err)
+		}
+	}
+	if len(p.URL) > 0 {
+		if _, err := regexp.Compile(p.URL); err != nil {
+			return fmt.Errorf("form %s.url: %w", name, err)
+		}
+	}
+	if len(p.JSONPath) > 0 {
+		if _, err := parseJSONPath(p.JSONPath); err != nil {
+			return fmt.Errorf("form %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+func checkExtract(name string, extract []config.FormExtract) error {
+	for _, e := range extract {
+		if len(e.Name) == 0 {
+			return fmt.Errorf("form %s.extract: name is empty", name)
+		}
+		switch e.From {
+		case "", FromBody, FromURL:
+		case FromHeader:
+			if len(e.Header) == 0 {
+				return fmt.Errorf("form %s.extract %s: header is empty", name, e.Name)
+			}
+		default:
+			return fmt.Errorf("form %s.extract %s: unknown from %q", name, e.Name, e.From)
+		}
+		if len(e.Regex) > 0 {
+			re, err := regexp.Compile(e.Regex)
+			if err != nil {
+				return fmt.Errorf("form %s.extract %s: %w", name, e.Name, err)
+			}
+			if re.NumSubexp() < 1 {
+				return fmt.Errorf("form %s.extract %s: regex needs a group", name, e.Name)
+			}
+		}
+		if len(e.JSONPath) > 0 {
+			if _, err := parseJSONPath(e.JSONPath); err != nil {
+				return fmt.Errorf("form %s.extract %s: %w", name, e.Name, err)
+			}
+		}
+	}
+	return nil
+}
+
+func checkStep(name string, s *config.FormStep, known map[string]bool) error {
+	if len(s.URL) == 0 {
+		return fmt.Errorf("form %s: url is empty", name)
+	}
+	if len(s.Form) > 0 && len(s.JSON) > 0 {
+		return fmt.Errorf("form %s: form and json can not be used together", name)
+	}
+	texts := map[string]string{"url": s.URL}
+	for _, m := range []map[string]string{s.Query, s.Headers, s.Form, s.JSON} {
+		for k, v := range m {
+			texts[k] = v
+		}
+	}
+	for k, v := range texts {
+		if err := checkTemplate(name+"."+k, v, known); err != nil {
+			return err
+		}
+	}
+	for _, p := range []*config.FormPredicate{s.Success, s.Failure} {
+		if p != nil {
+			if err := checkPredicate(name, p); err != nil {
+				return err
+			}
+		}
+	}
+	return checkExtract(name, s.Extract)
+}
+
+// render 以当前变量执行模板, 引用不存在的变量时返回错误
+func (c *Client) render(text string) (string, error) {
+	t, err := template.New("").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", err
+	}
+	data := make(map[string]string, len(c.vars)+1)
+	for k, v := range c.vars {
+		data[k] = v
+	}
+	data["Timestamp"] = strconv.FormatInt(time.Now().UnixMilli(), 10)
+	var buf bytes.Buffer
+	if err = t.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+func (c *Client) renderMap(m map[string]string) (map[string]string, error) {
+	res := make(map[string]string, len(m))
+	for k, v := range m {
+		s, err := c.render(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		res[k] = s
+	}
+	return res, nil
+}
+
+// transformPassword 依次执行配置的密码变换, 结果保存为 Password 变量
+func (c *Client) transformPassword() error {
+	password := c.cfg.Password
+	for _, t := range c.form.Password {
+		switch t {
+		case TransformMD5:
+			sum := md5.Sum([]byte(password))
+			password = hex.EncodeToString(sum[:])
+		case TransformBase64:
+			password = base64.StdEncoding.EncodeToString([]byte(password))
+		case TransformRSA:
+			exponent, err := c.render(c.form.RSA.Exponent)
+			if err != nil {
+				return fmt.Errorf("rsa.exponent: %w", err)
+			}
+			modulus, err := c.render(c.form.RSA.Modulus)
+			if err != nil {
+				return fmt.Errorf("rsa.modulus: %w", err)
+			}
+			mac, err := c.render(c.form.RSA.Mac)
+			if err != nil {
+				return fmt.Errorf("rsa.mac: %w", err)
+			}
+			pair := rsa.NewRSAPair(exponent, "", modulus)
+			if len(mac) > 0 {
+				password = pair.EncryptedPassword(password, mac)
+			} else {
+				password = pair.Encrypt(password)
+			}
+		default:
+			return fmt.Errorf("unknown password transform %q", t)
+		}
+	}
+	c.vars["Password"] = password
+	return nil
+}
+
+// fetch 渲染并发送一个步骤的请求
+func (c *Client) fetch(ctx context.Context, step *config.FormStep) (*response, error) {
+	rawUrl, err := c.render(step.URL)
+	if err != nil {
+		return nil, fmt.Errorf("url: %w", err)
+	}
+	u, err := url.Parse(rawUrl)
+	if err != nil {
+		return nil, err
+	}
+	if len(step.Query) > 0 {
+		query, err := c.renderMap(step.Query)
+		if err != nil {
+			return nil, err
+		}
+		q := u.Query()
+		for k, v := range query {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+	}
+
+	method := strings.ToUpper(step.Method)
+	var body []byte
+	var contentType string
+	switch {
+	case len(step.Form) > 0:
+		form, err := c.renderMap(step.Form)
+		if err != nil {
+			return nil, err
+		}
+		values := url.Values{}
+		for k, v := range form {
+			values.Set(k, v)
+		}
+		body, contentType = []byte(values.Encode()), "application/x-www-form-urlencoded"
+	case len(step.JSON) > 0:
+		fields, err := c.renderMap(step.JSON)
+		if err != nil {
+			return nil, err
+		}
+		if body, err = json.Marshal(fields); err != nil {
+			return nil, err
+		}
+		contentType = "application/json"
+	}
+	if len(method) == 0 {
+		method = "GET"
+		if body != nil {
+			method = "POST"
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if len(contentType) > 0 {
+		req.Header.Set("Content-Type", contentType)
+	}
+	headers, err := c.renderMap(step.Headers)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	s, err := utils.DecodeContent(resp)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.ValidString(s) {
+		if s, err = utils.ConvertGBKToUTF8([]byte(s)); err != nil {
+			return nil, err
+		}
+	}
+	return &response{status: resp.StatusCode, url: resp.Request.URL.String(), header: resp.Header, body: s}, nil
+}
+
+// extract 把提取到的变量保存到 vars, 返回没有找到的变量名
+func (c *Client) extract(resp *response, extract []config.FormExtract) ([]string, error) {
+	var missing []string
+	for _, e := range extract {
+		source := resp.body
+		switch e.From {
+		case FromURL:
+			source = resp.url
+		case FromHeader:
+			source = resp.header.Get(e.Header)
+		}
+
+		value, found := source, len(source) > 0
+		if len(e.Regex) > 0 {
+			v, err := utils.Match(source, e.Regex)
+			value, found = v, err == nil
+		}
+		if found && len(e.JSONPath) > 0 {
+			v, ok, err := jsonPath(value, e.JSONPath)
+			if err != nil {
+				return nil, err
+			}
+			value, found = v, ok
+		}
+		if !found {
+			missing = append(missing, e.Name)
+			continue
+		}
+		c.vars[e.Name] = value
+	}
+	return missing, nil
+}
+
+// match 判断响应是否满足 p 中设置的所有条件
+func match(resp *response, p *config.FormPredicate) (bool, error) {
+	if p.Status != 0 && p.Status != resp.status {
+		return false, nil
+	}
+	if len(p.Contains) > 0 && !strings.Contains(resp.body, p.Contains) {
+		return false, nil
+	}
+	if len(p.Regex) > 0 {
+		if ok, err := regexp.MatchString(p.Regex, resp.body); err != nil || !ok {
+			return false, err
+		}
+	}
+	if len(p.URL) > 0 {
+		if ok, err := regexp.MatchString(p.URL, resp.url); err != nil || !ok {
+			return false, err
+		}
+	}
+	if len(p.JSONPath) > 0 {
+		v, ok, err := jsonPath(resp.body, p.JSONPath)
+		if err != nil || !ok || v != p.Equals {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// step 执行一个步骤, 失败时返回 *portal.PortalError
+func (c *Client) step(ctx context.Context, name string, step *config.FormStep) error {
+	delete(c.vars, messageVar)
+	resp, err := c.fetch(ctx, step)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	missing, err := c.extract(resp, step.Extract)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	if step.Failure != nil {
+		failed, err := match(resp, step.Failure)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if failed {
+			return portal.NewError(c.message(name, "failure condition matched"))
+		}
+	}
+	if step.Success != nil {
+		ok, err := match(resp, step.Success)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		if !ok {
+			return portal.NewError(c.message(name, "success condition not met"))
+		}
+	} else if resp.status < 200 || resp.status >= 300 {
+		return fmt.Errorf("%s: %s", name, http.StatusText(resp.status))
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("%s: %s not found", name, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (c *Client) message(name, fallback string) string {
+	if msg, ok := c.vars[messageVar]; ok && len(msg) > 0 {
+		return msg
+	}
+	return name + ": " + fallback
+}
+
+func (c *Client) run(ctx context.Context, group string, steps []config.FormStep) error {
+	for i := range steps {
+		name := steps[i].Name
+		if len(name) == 0 {
+			name = fmt.Sprintf("%s[%d]", group, i)
+		}
+		if err := c.step(ctx, name, &steps[i]); err != nil {
+			return err
+		}
+		log.Infof("form step %s", name)
+	}
+	return nil
+}
+
+// Detect 访问 detect.url, 满足 online 时返回 true. 未在线时提取登录所需的变量
+func (c *Client) Detect(ctx context.Context) (bool, error) {
+	resp, err := c.fetch(ctx, &config.FormStep{URL: c.form.Detect.URL})
+	if err != nil {
+		return false, err
+	}
+	missing, err := c.extract(resp, c.form.Detect.Extract)
+	if err != nil {
+		return false, err
+	}
+	online, err := match(resp, &c.form.Detect.Online)
+	if err != nil || online {
+		return online, err
+	}
+	if len(missing) > 0 {
+		return false, fmt.Errorf("detect: %s not found", strings.Join(missing, ", "))
+	}
+	return false, nil
+}
+
+func (c *Client) Login(ctx context.Context) error {
+	if err := c.transformPassword(); err != nil {
+		return err
+	}
+	return c.run(ctx, "login", c.form.Login)
+}
+
+func (c *Client) KeepAlive(ctx context.Context) error {
+	if len(c.form.KeepAlive) == 0 {
+		online, err := c.Detect(ctx)
+		if err != nil {
+			return err
+		}
+		if !online {
+			return fmt.Errorf("not online")
+		}
+		return nil
+	}
+	return c.run(ctx, "keepalive", c.form.KeepAlive)
+}
+
+func (c *Client) Logout(ctx context.Context) error {
+	if len(c.form.Logout) == 0 {
+		return fmt.Errorf("form.logout is not configured")
+	}
+	return c.run(ctx, "logout", c.form.Logout)
+}
+
+// Var 返回变量的当前值
+func (c *Client) Var(name string) (string, bool) {
+	v, ok := c.vars[name]
+	return v, ok
+}
@@ -0,0 +1,194 @@
+package formportal
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"shunet/config"
+	"shunet/formportal/formportaltest"
+	"shunet/portal"
+	"shunet/shuclient/portaltest"
+	"strings"
+	"testing"
+)
+
+// portalYAML 与 README 中的示例相同, BASE 替换为模拟服务器的地址
+const portalYAML = `
+portal: form
+userId: guest
+password: secret
+form:
+  detect:
+    url: "BASE/generate_204"
+    online: { status: 204 }
+    extract:
+      - { name: token, from: url, regex: 'token=(\w+)' }
+      - { name: csrf, regex: 'name="csrf" value="(\w+)"' }
+    status: [session]
+  password: [md5]
+  login:
+    - name: auth
+      url: "BASE/api/auth"
+      json: { user: "{{.UserId}}", pass: "{{.Password}}", token: "{{.token}}", csrf: "{{.csrf}}" }
+      extract:
+        - { name: session, jsonPath: $.data.session }
+        - { name: message, jsonPath: $.message }
+      success: { jsonPath: $.code, equals: "0" }
+  keepalive:
+    - url: "BASE/api/keepalive"
+      query: { session: "{{.session}}" }
+      extract:
+        - { name: message, jsonPath: $.message }
+      success: { jsonPath: $.code, equals: "0" }
+  logout:
+    - { url: "BASE/api/logout", form: { session: "{{.session}}" }, success: { contains: '"code":0' } }
+`
+
+func loadConfig(t *testing.T, s *formportaltest.Server, password string) *config.Config {
+	t.Helper()
+	c := portaltest.LoadConfig(t, strings.ReplaceAll(portalYAML, "BASE", s.URL))
+	c.Password = password
+	if err := Validate(&c.Form); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+	return c
+}
+
+func newTestClient(t *testing.T, s *formportaltest.Server, password string) portal.Authenticator {
+	t.Helper()
+	c, err := NewClient(loadConfig(t, s, password))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Authenticator()
+}
+
+func TestLoginLogout(t *testing.T) {
+	s := formportaltest.NewServer("guest", "secret")
+	defer s.Close()
+	auth := newTestClient(t, s, "secret")
+	ctx := context.Background()
+
+	online, err := auth.Detect(ctx)
+	if err != nil || online {
+		t.Fatalf("Detect = %v, %v, want offline", online, err)
+	}
+	if host, want := auth.(portal.HostProvider).PortalHost(), strings.TrimPrefix(s.URL, "http://"); host != want {
+		t.Errorf("PortalHost = %q, want %q", host, want)
+	}
+	if err = auth.Login(ctx); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	if s.Online() != 1 {
+		t.Fatalf("%d sessions after login", s.Online())
+	}
+	if err = auth.KeepAlive(ctx); err != nil {
+		t.Fatalf("KeepAlive: %v", err)
+	}
+	status, err := auth.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	session := s.Sessions()[0].Token
+	if !status.Online || len(status.Items) != 1 || status.Items[0].Value != session {
+		t.Errorf("Status = %+v, want session %s", status, session)
+	}
+
+	if err = auth.Logout(ctx); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if s.Online() != 0 {
+		t.Errorf("%d sessions after logout", s.Online())
+	}
+	if err = auth.KeepAlive(ctx); err == nil {
+		t.Error("KeepAlive succeeded after logout")
+	}
+}
+
+func TestLoginError(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		failure  *formportaltest.Failure
+		want     error
+	}{
+		{name: "wrong password", password: "wrong", want: portal.ErrWrongPassword},
+		{name: "expired", password: "secret", failure: &formportaltest.Failure{Message: formportaltest.MessageExpired}, want: portal.ErrAccountArrears},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := formportaltest.NewServer("guest", "secret")
+			defer s.Close()
+			if tt.failure != nil {
+				s.Inject(formportaltest.EndpointAuth, *tt.failure)
+			}
+			auth := newTestClient(t, s, tt.password)
+			ctx := context.Background()
+			if _, err := auth.Detect(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := auth.Login(ctx); !errors.Is(err, tt.want) {
+				t.Errorf("Login err = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	s := formportaltest.NewServer("guest", "secret")
+	defer s.Close()
+	if err := Validate(&config.FormConfig{}); err == nil {
+		t.Error("Validate accepted an empty config")
+	}
+	f := s.Config()
+	if err := Validate(&f); err != nil {
+		t.Errorf("Validate(server config): %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(f *config.FormConfig)
+		want   string
+	}{
+		{"bad extract regex", func(f *config.FormConfig) {
+			f.Detect.Extract[0].Regex = `token=(\w+`
+		}, "detect.extract token"},
+		{"extract regex without group", func(f *config.FormConfig) {
+			f.Detect.Extract[1].Regex = `csrf`
+		}, "regex needs a group"},
+		{"bad predicate regex", func(f *config.FormConfig) {
+			f.Login[0].Success = &config.FormPredicate{Regex: `[`}
+		}, "login[0].regex"},
+		{"bad template", func(f *config.FormConfig) {
+			f.Login[0].URL = s.URL + "/api/auth?t={{.Timestamp"
+		}, "login[0].url"},
+		{"missing template key", func(f *config.FormConfig) {
+			f.Login[0].JSON["csrf"] = "{{.csrf_token}}"
+		}, "unknown variable csrf_token"},
+		{"key extracted by a later step", func(f *config.FormConfig) {
+			f.Detect.URL = s.URL + "/generate_204?token={{.token}}"
+		}, "detect.url: unknown variable token"},
+		{"missing key in keepalive", func(f *config.FormConfig) {
+			f.KeepAlive[0].Query["session"] = "{{.sesion}}"
+		}, "keepalive[0].session: unknown variable sesion"},
+		{"unknown password transform", func(f *config.FormConfig) {
+			f.Password = []string{"sha1"}
+		}, "unknown password transform"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := s.Config()
+			tt.modify(&f)
+			err := Validate(&f)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Validate err = %v, want containing %q", err, tt.want)
+			}
+		})
+	}
+
+	f = s.Config()
+	f.Vars = map[string]string{"csrf_token": "x"}
+	f.Login[0].JSON["csrf"] = "{{.csrf_token}}"
+	if err := Validate(&f); err != nil {
+		t.Errorf("Validate rejected a key defined in vars: %v", err)
+	}
+}
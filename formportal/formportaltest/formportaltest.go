@@ -0,0 +1,235 @@
+// Package formportaltest 提供一个进程内的访客网络门户模拟服务器, 用于离线测试 formportal.
+//
+// 未登录时 /generate_204 跳转到带 token 的登录页, 登录页中带有 csrf;
+// /api/auth 接收 JSON 格式的账号、md5 后的密码和 csrf, 返回 session;
+// /api/keepalive 与 /api/logout 使用该 session. Config 返回与之对应的配置.
+package formportaltest
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"shunet/config"
+	"shunet/shuclient/portaltest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 可注入故障的接口名
+const (
+	EndpointDetect    = "generate_204"
+	EndpointAuth      = "auth"
+	EndpointKeepAlive = "keepalive"
+	EndpointLogout    = "logout"
+)
+
+// 门户返回的提示信息
+const (
+	MessageWrongPassword = "用户名或密码错误"
+	MessageExpired       = "账号已过期"
+	MessageBadCsrf       = "页面已过期, 请刷新"
+)
+
+// Failure 描述一次注入的故障, Message 以 code=1 及该 message 返回
+type Failure = portaltest.Failure
+
+// Session 是服务器上的一个在线会话
+type Session struct {
+	Token     string
+	IP        string
+	LoginTime time.Time
+}
+
+func (s Session) LoginAt() time.Time {
+	return s.LoginTime
+}
+
+// Server 是模拟的访客网络门户, 导出字段需在发起请求前设置
+type Server struct {
+	*httptest.Server
+	*portaltest.Fixture[Session] // 会话以 IP 为键
+
+	UserId   string
+	Password string
+
+	mu   sync.Mutex
+	csrf map[string]string // token -> csrf
+}
+
+// NewServer 启动一个模拟服务器, 使用完毕后需调用 Close
+func NewServer(userId, password string) *Server {
+	s := &Server{
+		UserId:   userId,
+		Password: password,
+		csrf:     make(map[string]string),
+	}
+	s.Fixture = portaltest.NewFixture[Session](func(w http.ResponseWriter, r *http.Request, f Failure) {
+		writeJSON(w, map[string]any{"code": 1, "message": f.Message})
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/generate_204", s.handleDetect)
+	mux.HandleFunc("/guest/login", s.handleLoginPage)
+	mux.HandleFunc("/api/auth", s.handleAuth)
+	mux.HandleFunc("/api/keepalive", s.handleKeepAlive)
+	mux.HandleFunc("/api/logout", s.handleLogout)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Config 返回访问该服务器的 formportal 配置
+func (s *Server) Config() config.FormConfig {
+	return config.FormConfig{
+		Detect: config.FormDetect{
+			URL:    s.URL + "/generate_204",
+			Online: config.FormPredicate{Status: http.StatusNoContent},
+			Extract: []config.FormExtract{
+				{Name: "token", From: "url", Regex: `token=(\w+)`},
+				{Name: "csrf", Regex: `name="csrf" value="(\w+)"`},
+			},
+		},
+		Password: []string{"md5"},
+		Login: []config.FormStep{{
+			Name: "auth",
+			URL:  s.URL + "/api/auth",
+			JSON: map[string]string{
+				"user":  "{{.UserId}}",
+				"pass":  "{{.Password}}",
+				"token": "{{.token}}",
+				"csrf":  "{{.csrf}}",
+			},
+			Extract: []config.FormExtract{
+				{Name: "session", JSONPath: "$.data.session"},
+				{Name: "message", JSONPath: "$.message"},
+			},
+			Success: &config.FormPredicate{JSONPath: "$.code", Equals: "0"},
+		}},
+		KeepAlive: []config.FormStep{{
+			Name:    "keepalive",
+			URL:     s.URL + "/api/keepalive",
+			Query:   map[string]string{"session": "{{.session}}"},
+			Extract: []config.FormExtract{{Name: "message", JSONPath: "$.message"}},
+			Success: &config.FormPredicate{JSONPath: "$.code", Equals: "0"},
+		}},
+		Logout: []config.FormStep{{
+			Name:    "logout",
+			URL:     s.URL + "/api/logout",
+			Form:    map[string]string{"session": "{{.session}}"},
+			Success: &config.FormPredicate{Contains: `"code":0`},
+		}},
+	}
+}
+
+// Online 返回当前在线的 IP 数
+func (s *Server) Online() int {
+	return len(s.Sessions())
+}
+
+// intercept 执行注入的故障, 返回 true 表示请求已被处理
+func (s *Server) intercept(endpoint string, w http.ResponseWriter, r *http.Request) bool {
+	_, done := s.Intercept(endpoint, w, r)
+	return done
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handleDetect(w http.ResponseWriter, r *http.Request) {
+	if s.intercept(EndpointDetect, w, r) {
+		return
+	}
+	if _, online := s.Session(portaltest.RemoteIP(r)); online {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	http.Redirect(w, r, "/guest/login?token="+newToken()[:16], http.StatusFound)
+}
+
+func (s *Server) handleLoginPage(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	csrf := newToken()[:8]
+	s.mu.Lock()
+	s.csrf[token] = csrf
+	s.mu.Unlock()
+	w.Header().Set("Content-Type", "text/html;charset=UTF-8")
+	fmt.Fprintf(w, `<html><head><title>Guest WiFi</title></head><body><form><input type="hidden" name="csrf" value="%s"></form></body></html>`, csrf)
+}
+
+func (s *Server) handleAuth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/json") {
+		http.Error(w, "bad request", http.StatusBadRequest)
+		return
+	}
+	if s.intercept(EndpointAuth, w, r) {
+		return
+	}
+	var req struct {
+		User  string `json:"user"`
+		Pass  string `json:"pass"`
+		Token string `json:"token"`
+		Csrf  string `json:"csrf"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	csrf, ok := s.csrf[req.Token]
+	delete(s.csrf, req.Token)
+	s.mu.Unlock()
+	if !ok || csrf != req.Csrf {
+		writeJSON(w, map[string]any{"code": 2, "message": MessageBadCsrf})
+		return
+	}
+	sum := md5.Sum([]byte(s.Password))
+	if req.User != s.UserId || req.Pass != hex.EncodeToString(sum[:]) {
+		writeJSON(w, map[string]any{"code": 1, "message": MessageWrongPassword})
+		return
+	}
+
+	session := newToken()
+	s.PutSession(portaltest.RemoteIP(r), Session{Token: session, IP: portaltest.RemoteIP(r), LoginTime: time.Now()})
+	writeJSON(w, map[string]any{"code": 0, "message": "ok", "data": map[string]any{"session": session, "expire": time.Now().Add(time.Hour).Unix()}})
+}
+
+func (s *Server) checkSession(r *http.Request, session string) bool {
+	sess, ok := s.Session(portaltest.RemoteIP(r))
+	return ok && sess.Token == session
+}
+
+func (s *Server) handleKeepAlive(w http.ResponseWriter, r *http.Request) {
+	if s.intercept(EndpointKeepAlive, w, r) {
+		return
+	}
+	if !s.checkSession(r, r.URL.Query().Get("session")) {
+		writeJSON(w, map[string]any{"code": 3, "message": "会话不存在"})
+		return
+	}
+	writeJSON(w, map[string]any{"code": 0, "message": "ok"})
+}
+
+func (s *Server) handleLogout(w http.ResponseWriter, r *http.Request) {
+	if s.intercept(EndpointLogout, w, r) {
+		return
+	}
+	_ = r.ParseForm()
+	if !s.checkSession(r, r.PostForm.Get("session")) {
+		writeJSON(w, map[string]any{"code": 3, "message": "会话不存在"})
+		return
+	}
+	s.DeleteSession(portaltest.RemoteIP(r))
+	writeJSON(w, map[string]any{"code": 0, "message": "ok"})
+}
+
+func newToken() string {
+	b := make([]byte, 16)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,110 @@
+package formportal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseJSONPath 解析 JSONPath 的一个子集: $.a.b[0]['c-d'], 返回依次访问的键或下标
+func parseJSONPath(path string) ([]any, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("jsonPath %q must start with $", path)
+	}
+	var keys []any
+	s := path[1:]
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			s = s[1:]
+			end := strings.IndexAny(s, ".[")
+			if end < 0 {
+				end = len(s)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("jsonPath %q: empty key", path)
+			}
+			keys = append(keys, s[:end])
+			s = s[end:]
+		case '[':
+			end := strings.IndexByte(s, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("jsonPath %q: missing ]", path)
+			}
+			inner := s[1:end]
+			s = s[end+1:]
+			if len(inner) >= 2 && (inner[0] == '\'' || inner[0] == '"') && inner[len(inner)-1] == inner[0] {
+				keys = append(keys, inner[1:len(inner)-1])
+				continue
+			}
+			i, err := strconv.Atoi(inner)
+			if err != nil {
+				return nil, fmt.Errorf("jsonPath %q: invalid index %q", path, inner)
+			}
+			keys = append(keys, i)
+		default:
+			return nil, fmt.Errorf("jsonPath %q: unexpected %q", path, s[0])
+		}
+	}
+	return keys, nil
+}
+
+// trimJSONP 去掉 JSONP 的回调函数, 如 cb({...})
+func trimJSONP(body string) string {
+	s := strings.TrimSpace(body)
+	if i := strings.Index(s, "("); i > 0 && !strings.HasPrefix(s, "{") && !strings.HasPrefix(s, "[") {
+		s = strings.TrimSuffix(strings.TrimSpace(s[i+1:]), ";")
+		s = strings.TrimSuffix(s, ")")
+	}
+	return s
+}
+
+// jsonPath 取出 body 中 path 对应的值, 字符串原样返回, 其他类型返回 JSON 文本
+func jsonPath(body, path string) (string, bool, error) {
+	keys, err := parseJSONPath(path)
+	if err != nil {
+		return "", false, err
+	}
+	dec := json.NewDecoder(strings.NewReader(trimJSONP(body)))
+	dec.UseNumber()
+	var v any
+	if err = dec.Decode(&v); err != nil {
+		return "", false, nil
+	}
+	for _, k := range keys {
+		switch k := k.(type) {
+		case string:
+			m, ok := v.(map[string]any)
+			if !ok {
+				return "", false, nil
+			}
+			if v, ok = m[k]; !ok {
+				return "", false, nil
+			}
+		case int:
+			a, ok := v.([]any)
+			if !ok || k < 0 || k >= len(a) {
+				return "", false, nil
+			}
+			v = a[k]
+		}
+	}
+	switch v := v.(type) {
+	case string:
+		return v, true, nil
+	case json.Number:
+		return v.String(), true, nil
+	case nil:
+		return "", true, nil
+	default:
+		var buf bytes.Buffer
+		enc := json.NewEncoder(&buf)
+		enc.SetEscapeHTML(false)
+		if err = enc.Encode(v); err != nil {
+			return "", false, err
+		}
+		return strings.TrimSpace(buf.String()), true, nil
+	}
+}
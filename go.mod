@@ -5,10 +5,8 @@ go 1.22
 require (
 	github.com/sirupsen/logrus v1.9.3
 	golang.org/x/net v0.28.0
+	golang.org/x/text v0.17.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require (
-	golang.org/x/sys v0.23.0 // indirect
-	golang.org/x/text v0.17.0 // indirect
-)
+require golang.org/x/sys v0.23.0 // indirect
@@ -0,0 +1,204 @@
+// Package instance 管理一份配置对应的运行时文件: 保证同一配置只有一个进程运行的锁文件,
+// 以及供 stop、status、reload 等命令访问运行中进程的控制 socket.
+//
+// 锁文件中记录持有者的 pid, 进程退出时清空. 锁由操作系统在进程退出时释放,
+// 因此能取得锁但文件中仍有 pid 时, 说明上次的进程异常退出, 其 socket 等文件需要清理.
+package instance
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"shunet/config"
+	"shunet/portal"
+	"shunet/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var log = utils.Log
+
+var (
+	ErrRunning    = errors.New("shunet is already running")
+	ErrNotRunning = errors.New("shunet is not running")
+	errLocked     = errors.New("locked")
+)
+
+const (
+	lockRetries    = 3
+	lockRetryDelay = 50 * time.Millisecond
+	// maxSocketPath 是 sockaddr_un.sun_path 的长度, Linux 为 108, macOS 与 BSD 为 104, 需留出结尾的 0
+	maxSocketPath = 104
+)
+
+// Instance 是一份配置的运行时文件所在的位置
+type Instance struct {
+	Dir  string
+	Name string // 配置文件名加路径的摘要, 不同配置可以同时运行
+}
+
+// New 返回 cfg 对应的 Instance, 并创建运行时目录
+func New(cfg *config.Config) (*Instance, error) {
+	dir := cfg.RuntimeDir
+	if len(dir) == 0 {
+		var err error
+		if dir, err = defaultDir(); err != nil {
+			return nil, err
+		}
+	}
+	if err := ensureDir(dir); err != nil {
+		return nil, err
+	}
+	name, err := cfg.InstanceName()
+	if err != nil {
+		return nil, err
+	}
+	i := &Instance{Dir: dir, Name: name}
+	if path := i.SocketPath(); len(path) >= maxSocketPath {
+		return nil, fmt.Errorf("control socket path %s is longer than %d bytes, set a shorter runtimeDir", path, maxSocketPath-1)
+	}
+	return i, nil
+}
+
+func (i *Instance) LockPath() string {
+	return filepath.Join(i.Dir, i.Name+".lock")
+}
+
+// SocketPath 只使用 Name 中的摘要, 不含配置文件名, 路径长度不随配置文件名增长
+func (i *Instance) SocketPath() string {
+	sum := i.Name
+	if n := strings.LastIndexByte(sum, '-'); n >= 0 {
+		sum = sum[n+1:]
+	}
+	return filepath.Join(i.Dir, "shunet-"+sum+".sock")
+}
+
+func (i *Instance) tokenPath() string {
+	return filepath.Join(i.Dir, i.Name+".token")
+}
+
+// Lock 是取得的单实例锁
+type Lock struct {
+	inst  *Instance
+	file  *os.File
+	Token string // 控制 socket 的 token, 保存在只有本用户可读的文件中
+}
+
+// Lock 取得单实例锁并写入 pid, 已有进程持有时返回 ErrRunning
+func (i *Instance) Lock() (*Lock, error) {
+	f, err := openLocked(i.LockPath())
+	// Running 的探测会短暂持有共享锁, 稍等后重试
+	for n := 0; n < lockRetries && errors.Is(err, errLocked); n++ {
+		time.Sleep(lockRetryDelay)
+		f, err = openLocked(i.LockPath())
+	}
+	if errors.Is(err, errLocked) {
+		if pid, _ := i.readPid(); pid > 0 {
+			return nil, fmt.Errorf("%w (pid %d)", ErrRunning, pid)
+		}
+		return nil, ErrRunning
+	}
+	if err != nil {
+		return nil, err
+	}
+	if pid, _ := i.readPid(); pid > 0 {
+		log.Warningf("Removed stale lock left by pid %d", pid)
+		_ = os.Remove(i.SocketPath())
+		_ = os.Remove(i.tokenPath())
+	}
+
+	l := &Lock{inst: i, file: f}
+	if err = l.writePid(os.Getpid()); err != nil {
+		l.Release()
+		return nil, err
+	}
+	b := make([]byte, 16)
+	if _, err = rand.Read(b); err != nil {
+		l.Release()
+		return nil, err
+	}
+	l.Token = hex.EncodeToString(b)
+	_ = os.Remove(i.tokenPath())
+	if err = os.WriteFile(i.tokenPath(), []byte(l.Token), 0600); err != nil {
+		l.Release()
+		return nil, err
+	}
+	return l, nil
+}
+
+// Release 清空 pid 并释放锁. 锁文件本身保留, 避免删除时与新进程竞争
+func (l *Lock) Release() error {
+	_ = os.Remove(l.inst.tokenPath())
+	err := l.writePid(0)
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (l *Lock) writePid(pid int) error {
+	if err := l.file.Truncate(0); err != nil {
+		return err
+	}
+	if pid == 0 {
+		return nil
+	}
+	_, err := l.file.WriteAt([]byte(strconv.Itoa(pid)+"\n"), 0)
+	return err
+}
+
+func (i *Instance) readPid() (int, error) {
+	b, err := os.ReadFile(i.LockPath())
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(b)))
+}
+
+// Running 判断是否有进程持有锁, 并返回其 pid
+// 只做不阻塞的共享探测, 不会与正在启动的进程争抢锁
+func (i *Instance) Running() (int, bool, error) {
+	locked, err := isLocked(i.LockPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, false, nil
+	}
+	if err != nil || !locked {
+		return 0, false, err
+	}
+	pid, _ := i.readPid()
+	return pid, true, nil
+}
+
+// Client 返回访问运行中进程的控制客户端, 没有进程运行时返回 ErrNotRunning
+func (i *Instance) Client() (*portal.ControlClient, error) {
+	_, running, err := i.Running()
+	if err != nil {
+		return nil, err
+	}
+	if !running {
+		return nil, ErrNotRunning
+	}
+	token, err := os.ReadFile(i.tokenPath())
+	if err != nil {
+		return nil, err
+	}
+	return portal.NewControlClient("unix:"+i.SocketPath(), strings.TrimSpace(string(token))), nil
+}
+
+// Serve 在控制 socket 上提供 portal.ControlServer, 返回的 ControlServer 需在退出时 Close
+func (l *Lock) Serve(d *portal.Daemon) (*portal.ControlServer, error) {
+	s, err := portal.NewControlServer(d, config.ControlConfig{Listen: "unix:" + l.inst.SocketPath(), Token: l.Token})
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := s.Serve(); err != nil {
+			log.Errorf("Control socket err: %v", err)
+		}
+	}()
+	return s, nil
+}
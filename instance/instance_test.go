@@ -0,0 +1,137 @@
+package instance
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"shunet/config"
+	"shunet/shuclient/portaltest"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// runtimeDir 返回尚不存在的运行时目录, 由 New 以 0700 创建
+func runtimeDir(t *testing.T) string {
+	return filepath.Join(t.TempDir(), "run")
+}
+
+func newTestInstance(t *testing.T) *Instance {
+	t.Helper()
+	c := portaltest.LoadConfig(t, "runtimeDir: "+runtimeDir(t))
+	i, err := New(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return i
+}
+
+func TestSocketPath(t *testing.T) {
+	dir := t.TempDir()
+	long := filepath.Join(dir, strings.Repeat("long-config-name-", 8)+".yaml")
+	if err := os.WriteFile(long, []byte("runtimeDir: "+runtimeDir(t)), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadConfig(long)
+	if err != nil {
+		t.Fatal(err)
+	}
+	i, err := New(c)
+	if err != nil {
+		t.Fatalf("New with a long config name: %v", err)
+	}
+	if path := i.SocketPath(); len(path) >= maxSocketPath || !strings.HasPrefix(filepath.Base(path), "shunet-") {
+		t.Errorf("SocketPath = %s", path)
+	}
+
+	c.RuntimeDir = filepath.Join(dir, strings.Repeat("d", maxSocketPath))
+	if _, err = New(c); err == nil || !strings.Contains(err.Error(), "runtimeDir") {
+		t.Errorf("New with a long runtimeDir err = %v", err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	i := newTestInstance(t)
+	if _, running, err := i.Running(); err != nil || running {
+		t.Fatalf("Running before Lock = %v, %v", running, err)
+	}
+	l, err := i.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, running, err := i.Running()
+	if err != nil || !running || pid != os.Getpid() {
+		t.Errorf("Running = %d, %v, %v, want our pid", pid, running, err)
+	}
+	if _, err = i.Lock(); !errors.Is(err, ErrRunning) {
+		t.Errorf("second Lock err = %v, want ErrRunning", err)
+	}
+
+	fi, err := os.Stat(i.tokenPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+		t.Errorf("token mode = %v, want 0600", fi.Mode().Perm())
+	}
+	if b, _ := os.ReadFile(i.tokenPath()); string(b) != l.Token || len(l.Token) != 32 {
+		t.Errorf("token file = %q, Token = %q", b, l.Token)
+	}
+	if _, err = i.Client(); err != nil {
+		t.Errorf("Client: %v", err)
+	}
+
+	if err = l.Release(); err != nil {
+		t.Fatal(err)
+	}
+	if _, running, _ = i.Running(); running {
+		t.Error("Running after Release")
+	}
+	if _, err = os.Stat(i.tokenPath()); !os.IsNotExist(err) {
+		t.Errorf("token file left after Release: %v", err)
+	}
+	if _, err = i.Client(); !errors.Is(err, ErrNotRunning) {
+		t.Errorf("Client after Release err = %v, want ErrNotRunning", err)
+	}
+}
+
+func TestStaleLock(t *testing.T) {
+	i := newTestInstance(t)
+	// 异常退出的进程留下了 pid、socket 与 token
+	for path, content := range map[string]string{i.LockPath(): strconv.Itoa(1<<22 - 1), i.SocketPath(): "", i.tokenPath(): "old"} {
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, running, err := i.Running(); err != nil || running {
+		t.Fatalf("Running with a stale pid = %v, %v", running, err)
+	}
+	l, err := i.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Release()
+	if _, err = os.Stat(i.SocketPath()); !os.IsNotExist(err) {
+		t.Errorf("stale socket not removed: %v", err)
+	}
+	if b, _ := os.ReadFile(i.tokenPath()); string(b) != l.Token {
+		t.Errorf("token file = %q, want the new token", b)
+	}
+	if pid, _ := i.readPid(); pid != os.Getpid() {
+		t.Errorf("pid = %d, want %d", pid, os.Getpid())
+	}
+}
+
+func TestRuntimeDirPermissions(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("no unix permissions")
+	}
+	dir := runtimeDir(t)
+	if err := os.Mkdir(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(portaltest.LoadConfig(t, "runtimeDir: "+dir)); err == nil {
+		t.Error("New accepted a runtime dir readable by other users")
+	}
+}
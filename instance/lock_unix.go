@@ -0,0 +1,70 @@
+//go:build !windows
+// +build !windows
+
+package instance
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// defaultDir 优先使用 $XDG_RUNTIME_DIR, 否则使用临时目录下以 uid 区分的目录
+func defaultDir() (string, error) {
+	if dir := os.Getenv("XDG_RUNTIME_DIR"); len(dir) > 0 {
+		return filepath.Join(dir, "shunet"), nil
+	}
+	return filepath.Join(os.TempDir(), fmt.Sprintf("shunet-%d", os.Getuid())), nil
+}
+
+// ensureDir 创建只有本用户可访问的目录, 已存在时检查属主与权限, 以免 token 被其他用户读取
+func ensureDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	fi, err := os.Stat(dir)
+	if err != nil {
+		return err
+	}
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok && int(st.Uid) != os.Getuid() {
+		return fmt.Errorf("runtime dir %s is owned by uid %d", dir, st.Uid)
+	}
+	if fi.Mode().Perm()&0077 != 0 {
+		return fmt.Errorf("runtime dir %s is accessible by other users, chmod 700 it", dir)
+	}
+	return nil
+}
+
+// openLocked 打开并以 flock 锁住文件, 已被锁住时返回 errLocked. 关闭文件即释放锁
+func openLocked(path string) (*os.File, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// isLocked 以非阻塞的共享锁探测文件是否被锁住, 探测后立即释放, 不会创建文件
+func isLocked(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	if err = syscall.Flock(int(f.Fd()), syscall.LOCK_SH|syscall.LOCK_NB); err != nil {
+		if errors.Is(err, syscall.EWOULDBLOCK) {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
@@ -0,0 +1,61 @@
+//go:build windows
+// +build windows
+
+package instance
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+const errorSharingViolation syscall.Errno = 32
+
+// defaultDir 使用用户缓存目录下的 shunet\run
+func defaultDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "shunet", "run"), nil
+}
+
+func ensureDir(dir string) error {
+	return os.MkdirAll(dir, 0700)
+}
+
+// openLocked 以不允许其他进程写入的共享模式打开文件, 已被打开时返回 errLocked. 关闭文件即释放锁
+func openLocked(path string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return nil, err
+	}
+	h, err := syscall.CreateFile(name, syscall.GENERIC_READ|syscall.GENERIC_WRITE, syscall.FILE_SHARE_READ,
+		nil, syscall.OPEN_ALWAYS, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if errors.Is(err, errorSharingViolation) {
+			return nil, errLocked
+		}
+		return nil, err
+	}
+	return os.NewFile(uintptr(h), path), nil
+}
+
+// isLocked 以只读方式打开已有的文件, 持有者以写入模式打开时返回 true. 不会创建文件
+func isLocked(path string) (bool, error) {
+	name, err := syscall.UTF16PtrFromString(path)
+	if err != nil {
+		return false, err
+	}
+	h, err := syscall.CreateFile(name, syscall.GENERIC_READ, syscall.FILE_SHARE_READ,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		if errors.Is(err, errorSharingViolation) {
+			return true, nil
+		}
+		return false, err
+	}
+	syscall.CloseHandle(h)
+	return false, nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"shunet/config"
+	"shunet/portal"
+	"strings"
+
+	"golang.org/x/net/context"
+)
+
+// runMac 管理无感知认证绑定的 MAC, 未指定 MAC 时使用本机的
+func runMac(cfg *config.Config, args []string) error {
+	if len(args) == 0 {
+		return usageErrorf("usage: shunet mac bind [mac] | shunet mac unbind [mac] | shunet mac show [-json]")
+	}
+	auth, err := newAuthenticator(cfg)
+	if err != nil {
+		return err
+	}
+	b, ok := auth.(portal.MacBinder)
+	if !ok {
+		return fmt.Errorf("portal %q does not support mac binding", cfg.Portal)
+	}
+	ctx := context.Background()
+
+	switch args[0] {
+	case "bind", "unbind":
+		if len(args) > 2 {
+			return usageErrorf("usage: shunet mac %s [mac]", args[0])
+		}
+		var mac string
+		if len(args) == 2 {
+			mac = args[1]
+		}
+		done := "bound"
+		if args[0] == "bind" {
+			err = b.BindMac(ctx, mac)
+		} else {
+			err, done = b.UnbindMac(ctx, mac), "unbound"
+		}
+		if err != nil {
+			return err
+		}
+		res := map[string]any{"mac": mac, "bound": done == "bound"}
+		if len(mac) == 0 {
+			mac = "this device"
+		}
+		return printResult(res, mac+" "+done)
+	case "show":
+		fs := flag.NewFlagSet("mac show", flag.ExitOnError)
+		fs.BoolVar(jsonOutput, "json", *jsonOutput, "print bound macs as JSON")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		macs, err := b.BoundMacs(ctx)
+		if err != nil {
+			return err
+		}
+		text := strings.Join(macs, "\n")
+		if len(macs) == 0 {
+			text = "no mac bound"
+		}
+		return printResult(macs, text)
+	default:
+		return usageErrorf("unknown mac command %q", args[0])
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"fmt"
+	"shunet/shuclient/portaltest"
+	"strings"
+	"testing"
+)
+
+func TestMac(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	s.MaxBindings = 2
+	path := testConfig(t, s, "secret")
+	const other = "11:22:33:44:55:66"
+
+	tests := []struct {
+		args []string
+		code int
+		want string
+	}{
+		{[]string{"mac"}, exitUsage, ""},
+		{[]string{"mac", "bind", "a", "b"}, exitUsage, ""},
+		{[]string{"mac", "show"}, exitOK, "no mac bound"}, // 离线时先登录
+		{[]string{"mac", "bind"}, exitOK, "this device bound"},
+		{[]string{"mac", "bind", other}, exitOK, other + " bound"},
+		{[]string{"mac", "bind", "22:33:44:55:66:77"}, exitRejected, ""}, // 超出绑定数
+		{[]string{"--json", "mac", "show"}, exitOK, `"112233445566"`},    // 门户保存不带分隔符的 MAC
+		{[]string{"mac", "unbind", other}, exitOK, other + " unbound"},
+		{[]string{"mac", "unbind", other}, exitRejected, ""},
+	}
+	for _, tt := range tests {
+		code, out := runCLI(t, path, tt.args...)
+		if code != tt.code || !strings.Contains(out, tt.want) {
+			t.Errorf("shunet %s = %d, %q, want %d with %q", strings.Join(tt.args, " "), code, out, tt.code, tt.want)
+		}
+	}
+	if got := s.BoundMacs(); fmt.Sprint(got) != fmt.Sprint([]string{s.Mac}) {
+		t.Errorf("bound macs = %v, want this device %s", got, s.Mac)
+	}
+}
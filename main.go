@@ -7,46 +7,182 @@ import (
 	"os"
 	"os/signal"
 	"shunet/config"
-	"shunet/shuclient"
+	"shunet/instance"
+	"shunet/portal"
 	"shunet/utils"
 	"syscall"
 )
 
 func usage() {
-	fmt.Println(`SHU Net is a tool designed to maintain the network connections of Shanghai University. 
-Usage: 
-	shunet
-Options:`)
+	fmt.Fprint(flag.CommandLine.Output(), `SHU Net is a tool designed to maintain the network connections of Shanghai University.
+
+Usage:
+	shunet [global options] [command] [arguments]
+
+Commands:
+	run, daemon                           log in and keep the session alive, the default command
+	login                                 log in once and exit, or ask the running shunet to log in
+	logout                                log out, the running shunet stops logging in until login
+	status [-json]                        show the running shunet and the online session
+	stop                                  log out and stop the running shunet
+	reload                                make the running shunet reload its config
+	config init [-force]                  write a config template to --config
+	config show                           print the config with secrets masked
+	config validate                       check the config without connecting to the portal
+	encrypt [-mac mac] [password]         print the password encrypted with the portal public key
+	services [-json]                      list the services that can be used to login
+	devices list [-json]                  list the online sessions of the account
+	devices kick <session|ip|mac>         log out one of them, or -oldest
+	mac bind|unbind [mac]                 bind this device (or mac) for seamless authentication
+	mac show [-json]                      list the bound macs
+	version                               print the version
+
+Global options:
+`)
 	flag.PrintDefaults()
+	fmt.Fprint(flag.CommandLine.Output(), `
+Exit codes:
+	0  success
+	1  error, such as the portal is unreachable
+	2  invalid command line
+	3  invalid config
+	4  the portal rejected the login, such as wrong password or device limit
+	5  status: not online
+	6  shunet is already running (run), or not running (stop, reload)
+`)
 }
 
 var (
 	log        = utils.Log
-	stop       = flag.Bool("stop", false, "stop connect school network, and kill running process")
-	configPath = `config.yaml`
+	configPath = flag.String("config", "config.yaml", "config file, one per portal profile")
+	logLevel   = flag.String("log-level", "", "override logLevel in config: debug, info, warning, error")
+	jsonOutput = flag.Bool("json", false, "print machine-readable JSON to stdout")
+	stop       = flag.Bool("stop", false, "same as shunet stop")
 	ctx        = context.Background()
 )
 
+// commands 是需要读取配置文件的命令
+var commands = map[string]func(cfg *config.Config, args []string) error{
+	"run":      runDaemon,
+	"daemon":   runDaemon,
+	"login":    runLogin,
+	"logout":   runLogout,
+	"status":   runStatus,
+	"stop":     runStop,
+	"reload":   runReload,
+	"encrypt":  runEncrypt,
+	"services": runServices,
+	"devices":  runDevices,
+	"mac":      runMac,
+}
+
 func main() {
 	flag.Usage = usage
 	flag.Parse() // 默认有个help参数
+	os.Exit(exit(run(flag.Args())))
+}
+
+func run(args []string) error {
+	switch *logLevel {
+	case "", "debug", "info", "warning", "error":
+	default:
+		return usageErrorf("unknown log level %q", *logLevel)
+	}
+	name := "run"
+	if len(args) > 0 {
+		name, args = args[0], args[1:]
+	}
+	if *stop {
+		name = "stop"
+	}
 
-	cfg, err := config.LoadConfig(configPath)
+	var err error
+	switch name {
+	case "version":
+		err = runVersion(args)
+	case "config":
+		err = runConfig(args)
+	default:
+		f, ok := commands[name]
+		if !ok {
+			return usageErrorf("unknown command %q, see shunet -help", name)
+		}
+		var cfg *config.Config
+		if cfg, err = loadConfig(); err != nil {
+			return err
+		}
+		err = f(cfg, args)
+	}
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		return fmt.Errorf("%s: %w", name, err)
 	}
+	return nil
+}
 
-	if *stop {
-		// kill running process
-		utils.Kill(cfg.Pid)
-		return
+// loadConfig 读取 --config 并应用 --log-level
+func loadConfig() (*config.Config, error) {
+	cfg, err := config.LoadConfig(*configPath)
+	if err != nil {
+		return nil, &exitError{code: exitConfig, err: fmt.Errorf("load config: %w", err)}
+	}
+	applyLogLevel(cfg)
+	return cfg, nil
+}
+
+func applyLogLevel(cfg *config.Config) {
+	if len(*logLevel) > 0 {
+		cfg.LogLevel = *logLevel
+		utils.SetLogLevel(*logLevel)
+	}
+}
+
+// runDaemon 取得单实例锁后运行 portal.Daemon, 直到收到退出信号或 stop 命令
+func runDaemon(cfg *config.Config, args []string) error {
+	if len(args) > 0 {
+		return usageErrorf("unexpected arguments %v", args)
+	}
+	inst, err := instance.New(cfg)
+	if err != nil {
+		return err
+	}
+	lock, err := inst.Lock()
+	if err != nil {
+		return err
+	}
+	defer lock.Release()
+
+	auth, err := newAuthenticator(cfg)
+	if err != nil {
+		return err
+	}
+	d := portal.NewDaemon(auth, cfg)
+	d.SetReloader(func(c *config.Config) (portal.Authenticator, error) {
+		applyLogLevel(c)
+		return newAuthenticator(c)
+	})
+	ipc, err := lock.Serve(d)
+	if err != nil {
+		return err
+	}
+	defer ipc.Close()
+	if len(cfg.Control.Listen) > 0 {
+		control, err := portal.NewControlServer(d, cfg.Control)
+		if err != nil {
+			return err
+		}
+		defer control.Close()
+		go func() {
+			if err := control.Serve(); err != nil {
+				log.Errorf("Control API err: %v", err)
+			}
+		}()
 	}
 
 	runCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
 	go ListenSignal(cancel)
-
-	client := shuclient.NewClient(cfg)
-	client.Run(runCtx)
+	d.Run(runCtx)
+	return nil
 }
 
 // ListenSignal to stop process
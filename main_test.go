@@ -0,0 +1,151 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"shunet/config"
+	"shunet/shuclient/portaltest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// runCLI 以 --config path 执行 shunet args, 开头的 --json 与全局选项相同, 返回退出码与 stdout
+func runCLI(t *testing.T, path string, args ...string) (int, string) {
+	t.Helper()
+	*configPath = path
+	*jsonOutput = len(args) > 0 && args[0] == "--json"
+	if *jsonOutput {
+		args = args[1:]
+	}
+	defer func() { *jsonOutput = false }()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = w
+	out := make(chan string)
+	go func() {
+		b, _ := io.ReadAll(r)
+		out <- string(b)
+	}()
+	code := exit(run(args))
+	os.Stdout = stdout
+	w.Close()
+	return code, <-out
+}
+
+// testConfig 返回登录 s 的配置文件路径, 运行时文件与状态文件都在临时目录中
+func testConfig(t *testing.T, s *portaltest.Server, password string) string {
+	t.Helper()
+	return s.Config(t, password, "runtimeDir: "+filepath.Join(t.TempDir(), "run")+"\n").Path()
+}
+
+func TestExitCodes(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	good := testConfig(t, s, "secret")
+	wrong := testConfig(t, s, "wrong")
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+
+	tests := []struct {
+		config string
+		args   []string
+		want   int
+	}{
+		{good, []string{"version"}, exitOK},
+		{good, []string{"config", "validate"}, exitOK},
+		{good, []string{"nope"}, exitUsage},
+		{good, []string{"config"}, exitUsage},
+		{good, []string{"stop", "now"}, exitUsage},
+		{missing, []string{"status"}, exitConfig},
+		{good, []string{"stop"}, exitInstance},
+		{good, []string{"reload"}, exitInstance},
+		{good, []string{"status"}, exitOffline},
+		{wrong, []string{"login"}, exitRejected},
+		{good, []string{"login"}, exitOK},
+		{good, []string{"status"}, exitOK},
+		{good, []string{"logout"}, exitOK},
+	}
+	for _, tt := range tests {
+		if code, out := runCLI(t, tt.config, tt.args...); code != tt.want {
+			t.Errorf("shunet %s = %d, want %d, output %q", strings.Join(tt.args, " "), code, tt.want, out)
+		}
+	}
+}
+
+func TestJSONError(t *testing.T) {
+	code, out := runCLI(t, filepath.Join(t.TempDir(), "missing.yaml"), "--json", "status")
+	if code != exitConfig || !strings.Contains(out, `"exitCode":3`) || !strings.Contains(out, `"error":`) {
+		t.Errorf("--json status = %d, %q, want the error as JSON", code, out)
+	}
+}
+
+func TestLoginLogout(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	path := testConfig(t, s, "secret")
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"login"}, "logged in"},
+		{[]string{"login"}, "already online"},
+		{[]string{"--json", "login"}, `"online": true`},
+		{[]string{"logout"}, "logged out"},
+		{[]string{"logout"}, "already offline"},
+	}
+	for _, tt := range tests {
+		code, out := runCLI(t, path, tt.args...)
+		if code != exitOK || !strings.Contains(out, tt.want) {
+			t.Errorf("shunet %s = %d, %q, want %q", strings.Join(tt.args, " "), code, out, tt.want)
+		}
+	}
+	if n := len(s.Sessions()); n != 0 {
+		t.Errorf("%d sessions after logout", n)
+	}
+}
+
+func TestRunStop(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	path := testConfig(t, s, "secret")
+	cfg, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- runDaemon(cfg, nil) }()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for code, _ := runCLI(t, path, "status"); code != exitOK; code, _ = runCLI(t, path, "status") {
+		if time.Now().After(deadline) {
+			t.Fatalf("status = %d, want online", code)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if code, out := runCLI(t, path, "login"); code != exitOK || !strings.Contains(out, "already online (by the running shunet)") {
+		t.Errorf("login = %d, %q, want handled by the running shunet", code, out)
+	}
+	if code, out := runCLI(t, path, "run"); code != exitInstance {
+		t.Errorf("second run = %d, %q, want %d", code, out, exitInstance)
+	}
+	if code, out := runCLI(t, path, "stop"); code != exitOK {
+		t.Errorf("stop = %d, %q", code, out)
+	}
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Errorf("run: %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("run did not return after stop")
+	}
+	if n := len(s.Sessions()); n != 0 {
+		t.Errorf("%d sessions after stop", n)
+	}
+}
@@ -0,0 +1,141 @@
+package portal
+
+import (
+	"math"
+	"math/rand"
+	"shunet/config"
+	"time"
+)
+
+// Phase 是守护循环中可能失败的步骤, 每个步骤独立退避
+type Phase int
+
+const (
+	PhaseDetect  Phase = iota // Authenticator.Detect, ePortal 中为 EnterLoginPage
+	PhasePrepare              // Preparer.Prepare, ePortal 中为 GetPageInfo
+	PhaseLogin                // Authenticator.Login
+)
+
+func (p Phase) String() string {
+	switch p {
+	case PhaseDetect:
+		return "Detect"
+	case PhasePrepare:
+		return "Prepare"
+	case PhaseLogin:
+		return "Login"
+	default:
+		return "Phase(?)"
+	}
+}
+
+// RetryPolicy 指数退避策略
+type RetryPolicy struct {
+	InitialDelay time.Duration
+	Multiplier   float64
+	MaxDelay     time.Duration
+	Jitter       float64 // 随机抖动比例, 0.2 表示 ±20%
+	MaxFailures  int     // 连续相同错误达到该次数后需要人工处理, <=0 表示不限制
+}
+
+// NewRetryPolicy 从配置生成退避策略, 未配置的项使用默认值
+func NewRetryPolicy(c config.RetryConfig) RetryPolicy {
+	p := RetryPolicy{
+		InitialDelay: 5 * time.Second,
+		Multiplier:   2,
+		MaxDelay:     10 * time.Minute,
+		Jitter:       0.2,
+		MaxFailures:  5,
+	}
+	if c.InitialDelay > 0 {
+		p.InitialDelay = time.Duration(c.InitialDelay) * time.Second
+	}
+	if c.Multiplier >= 1 {
+		p.Multiplier = c.Multiplier
+	}
+	if c.MaxDelay > 0 {
+		p.MaxDelay = time.Duration(c.MaxDelay) * time.Second
+	}
+	if c.Jitter > 0 && c.Jitter < 1 {
+		p.Jitter = c.Jitter
+	}
+	if c.MaxFailures != 0 {
+		p.MaxFailures = c.MaxFailures
+	}
+	return p
+}
+
+// kindPolicies 返回门户失败类型各自的登录退避策略, 未列出的类型使用 PhaseLogin 的策略
+func kindPolicies(p RetryPolicy) map[error]RetryPolicy {
+	// 验证码多半需要人工处理: 固定间隔等待应答, 很快转入人工处理
+	captcha := p
+	captcha.InitialDelay, captcha.Multiplier = time.Minute, 1
+	captcha.MaxFailures = atMost(p.MaxFailures, 2)
+
+	// 等待人工输入验证码: 固定间隔检查应答, 不转入人工处理
+	pending := p
+	pending.Multiplier, pending.Jitter = 1, 0
+	pending.MaxFailures = 0
+
+	// 门户故障与账号无关: 退避更久, 恢复后即可登录, 不转入人工处理
+	outage := p
+	outage.InitialDelay, outage.MaxDelay = 6*p.InitialDelay, 3*p.MaxDelay
+	outage.MaxFailures = 0
+
+	// MAC 校验失败时重新探测即可取得新的跳转参数, 短间隔重试几次
+	mac := p
+	mac.Multiplier = 1
+	mac.MaxFailures = atMost(p.MaxFailures, 3)
+
+	// 未知的提示可能是门户改版, 正常退避但更早转入人工处理
+	unknown := p
+	unknown.MaxFailures = atMost(p.MaxFailures, 3)
+
+	return map[error]RetryPolicy{
+		ErrCaptchaRequired:    captcha,
+		ErrCaptchaPending:     pending,
+		ErrServiceUnavailable: outage,
+		ErrMacMismatch:        mac,
+		ErrUnknown:            unknown,
+	}
+}
+
+// atMost 收紧失败预算, 不限制(<=0)的配置保持不变
+func atMost(maxFailures, n int) int {
+	if maxFailures > n {
+		return n
+	}
+	return maxFailures
+}
+
+// backoff 记录一个步骤的连续失败
+type backoff struct {
+	policy   RetryPolicy
+	failures int    // 连续失败次数
+	same     int    // 连续相同错误次数
+	last     string // 上一次的错误
+}
+
+// fail 记录一次失败, 返回下一次重试前的等待时间, exhausted 表示相同错误已超出预算
+func (b *backoff) fail(reason string) (delay time.Duration, exhausted bool) {
+	if reason == b.last {
+		b.same++
+	} else {
+		b.same, b.last = 1, reason
+	}
+	b.failures++
+
+	p := b.policy
+	d := float64(p.InitialDelay) * math.Pow(p.Multiplier, float64(b.failures-1))
+	if d > float64(p.MaxDelay) {
+		d = float64(p.MaxDelay)
+	}
+	if p.Jitter > 0 {
+		d += d * p.Jitter * (2*rand.Float64() - 1)
+	}
+	return time.Duration(d), p.MaxFailures > 0 && b.same >= p.MaxFailures
+}
+
+func (b *backoff) reset() {
+	b.failures, b.same, b.last = 0, 0, ""
+}
@@ -0,0 +1,99 @@
+package portal
+
+import (
+	"golang.org/x/net/context"
+	"shunet/config"
+	"testing"
+	"time"
+)
+
+func TestBackoff(t *testing.T) {
+	p := NewRetryPolicy(config.RetryConfig{InitialDelay: 1, Multiplier: 3, MaxDelay: 10, MaxFailures: 3})
+	p.Jitter = 0
+	b := &backoff{policy: p}
+	tests := []struct {
+		reason    string
+		delay     time.Duration
+		exhausted bool
+	}{
+		{"a", 1 * time.Second, false},
+		{"a", 3 * time.Second, false},
+		{"b", 9 * time.Second, false}, // 不同的错误重新计数, 但等待时间继续增长
+		{"b", 10 * time.Second, false},
+		{"b", 10 * time.Second, true},
+	}
+	for i, tt := range tests {
+		delay, exhausted := b.fail(tt.reason)
+		if delay != tt.delay || exhausted != tt.exhausted {
+			t.Errorf("fail #%d(%s) = %v, %v, want %v, %v", i, tt.reason, delay, exhausted, tt.delay, tt.exhausted)
+		}
+	}
+	b.reset()
+	if delay, _ := b.fail("b"); delay != time.Second {
+		t.Errorf("delay after reset = %v, want 1s", delay)
+	}
+
+	// 抖动不超出比例
+	b = &backoff{policy: NewRetryPolicy(config.RetryConfig{InitialDelay: 10, Jitter: 0.2})}
+	for i := 0; i < 20; i++ {
+		if delay, _ := b.fail("x"); i == 0 && (delay < 8*time.Second || delay > 12*time.Second) {
+			t.Errorf("first delay with jitter = %v, want 8s..12s", delay)
+		}
+		b.reset()
+	}
+}
+
+// hinter 是只提供心跳间隔的 Authenticator
+type hinter struct {
+	Authenticator
+	interval time.Duration
+}
+
+func (h hinter) KeepAliveInterval() time.Duration {
+	return h.interval
+}
+
+func TestHeartbeat(t *testing.T) {
+	tests := []struct {
+		name  string
+		hint  time.Duration
+		keep  config.KeepAliveConfig
+		delay int
+		want  time.Duration
+	}{
+		{"portal interval", 120 * time.Second, config.KeepAliveConfig{}, 0, 120 * time.Second},
+		{"no hint uses delayTime", 0, config.KeepAliveConfig{}, 30, 30 * time.Second},
+		{"no hint default", 0, config.KeepAliveConfig{}, 0, 60 * time.Second},
+		{"clamped to min", 2 * time.Second, config.KeepAliveConfig{MinInterval: 15}, 0, 15 * time.Second},
+		{"default min", 2 * time.Second, config.KeepAliveConfig{}, 0, 10 * time.Second},
+		{"clamped to max", time.Hour, config.KeepAliveConfig{MaxInterval: 300}, 0, 300 * time.Second},
+	}
+	for _, tt := range tests {
+		d := NewDaemon(hinter{interval: tt.hint}, &config.Config{KeepAlive: tt.keep, DelayTime: tt.delay})
+		if got := d.heartbeat(); got != tt.want {
+			t.Errorf("%s: heartbeat = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+// TestRunCancelWhileWaiting 取消 ctx 后等待中的保活与退避都立即结束
+func TestRunCancelWhileWaiting(t *testing.T) {
+	for _, state := range []State{StateOnline, StateBackoff} {
+		d := NewDaemon(hinter{interval: time.Hour}, &config.Config{})
+		d.state.State = state
+		d.nextKeepAlive = time.Now().Add(time.Hour)
+		d.retryAt = time.Now().Add(time.Hour)
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			d.wait(ctx, time.Hour)
+		}()
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Errorf("%v: wait did not return after cancel", state)
+		}
+	}
+}
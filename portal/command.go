@@ -0,0 +1,176 @@
+package portal
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"shunet/config"
+)
+
+// 由其他 goroutine 交给 Run 执行的命令
+const (
+	cmdLogin     = "login"
+	cmdLogout    = "logout"
+	cmdKeepAlive = "keepalive"
+	cmdReload    = "reload"
+)
+
+var errDaemonStopped = errors.New("daemon is not running")
+
+type command struct {
+	name string
+	done chan error // 容量为 1, 回复不会阻塞 Run
+}
+
+// Login 立即登录并等待结果, 已在线时直接返回. 也用于恢复 Logout 或 StateNeedsAttention
+func (d *Daemon) Login(ctx context.Context) error {
+	return d.do(ctx, cmdLogin)
+}
+
+// Logout 下线并停止自动登录, 直到再次调用 Login
+func (d *Daemon) Logout(ctx context.Context) error {
+	return d.do(ctx, cmdLogout)
+}
+
+// KeepAlive 立即保活一次, 不在线时返回错误
+func (d *Daemon) KeepAlive(ctx context.Context) error {
+	return d.do(ctx, cmdKeepAlive)
+}
+
+// Reload 重新读取配置文件, 然后重新探测. 在线时先保存会话, 新的 Authenticator 可以直接恢复
+func (d *Daemon) Reload(ctx context.Context) error {
+	return d.do(ctx, cmdReload)
+}
+
+// Stop 让 Run 下线后退出并等待其结束, 与取消 Run 的 ctx 相同
+func (d *Daemon) Stop(ctx context.Context) error {
+	d.quitOnce.Do(func() { close(d.quit) })
+	select {
+	case <-d.stopped:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// do 把命令交给 Run 执行. Run 只在等待时接收命令, 正在登录时命令要等到登录结束
+func (d *Daemon) do(ctx context.Context, name string) error {
+	cmd := command{name: name, done: make(chan error, 1)}
+	select {
+	case d.commands <- cmd:
+	case <-d.stopped:
+		return errDaemonStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	select {
+	case err := <-cmd.done:
+		return err
+	case <-d.stopped:
+		return errDaemonStopped
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// handle 在 Run 中执行命令
+func (d *Daemon) handle(ctx context.Context, cmd command) {
+	log.Infof("Execute %s command", cmd.name)
+	switch cmd.name {
+	case cmdLogin:
+		if d.State().State == StateOnline {
+			cmd.done <- nil
+			return
+		}
+		d.resetRetry()
+		// 结果由 notifyWaiters 在登录结束后回复
+		d.waiters = append(d.waiters, cmd.done)
+		d.transition(EventLogin, "login requested")
+	case cmdLogout:
+		cmd.done <- d.logout(ctx)
+	case cmdKeepAlive:
+		if d.State().State != StateOnline {
+			cmd.done <- errors.New("not online")
+			return
+		}
+		d.keepAlive(ctx)
+		if info := d.State(); info.State != StateOnline {
+			cmd.done <- errors.New(info.Reason)
+			return
+		}
+		cmd.done <- nil
+	case cmdReload:
+		cmd.done <- d.reload(ctx)
+	default:
+		cmd.done <- errors.New("unknown command " + cmd.name)
+	}
+}
+
+// notifyWaiters 登录成功或失败后回复等待中的 Login
+func (d *Daemon) notifyWaiters() {
+	if len(d.waiters) == 0 {
+		return
+	}
+	info := d.State()
+	var err error
+	switch info.State {
+	case StateOnline:
+	case StateBackoff, StateNeedsAttention, StateLoggedOut, StateShuttingDown:
+		err = errors.New(info.Reason)
+	default:
+		return
+	}
+	for _, w := range d.waiters {
+		w <- err
+	}
+	d.waiters = nil
+}
+
+func (d *Daemon) logout(ctx context.Context) error {
+	if d.online {
+		if err := d.auth.Logout(ctx); err != nil {
+			return err
+		}
+		d.online = false
+		d.clearSession()
+	}
+	log.Info("Logout, stop login until requested")
+	d.transition(EventLogout, "logout requested")
+	return nil
+}
+
+func (d *Daemon) reload(ctx context.Context) error {
+	c, err := config.LoadConfig(d.cfg.Path())
+	if err != nil {
+		return err
+	}
+	auth := d.auth
+	if d.reloader != nil {
+		if auth, err = d.reloader(c); err != nil {
+			return err
+		}
+	}
+	if d.online {
+		d.saveSession()
+	}
+	d.configure(auth, c)
+	log.Info("Config reloaded")
+	if d.State().State == StateLoggedOut {
+		return nil
+	}
+	d.transition(EventReload, "config reloaded")
+	d.restoreSession(ctx)
+	return nil
+}
+
+// Subscribe 订阅状态变化, 不再需要时调用 cancel. 来不及接收的变化会被丢弃
+func (d *Daemon) Subscribe() (<-chan StateInfo, func()) {
+	ch := make(chan StateInfo, 16)
+	d.stateMu.Lock()
+	d.subscribers[ch] = struct{}{}
+	d.stateMu.Unlock()
+	return ch, func() {
+		d.stateMu.Lock()
+		delete(d.subscribers, ch)
+		d.stateMu.Unlock()
+	}
+}
@@ -0,0 +1,250 @@
+package portal
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"net"
+	"net/http"
+	"os"
+	"shunet/config"
+	"strings"
+	"time"
+)
+
+// ControlServer 是本地的 HTTP/JSON 控制接口, 所有操作都交给 Daemon 的 Run 执行:
+//
+//	GET  /status     当前状态
+//	POST /login      立即登录, 也用于恢复 /logout 或停止重试后的状态
+//	POST /logout     下线并停止自动登录
+//	POST /keepalive  立即保活
+//	POST /reload     重新读取配置文件
+//	POST /stop       下线并退出
+//	GET  /events     以 server-sent events 推送状态变化
+type ControlServer struct {
+	d        *Daemon
+	token    string
+	listener net.Listener
+	server   *http.Server
+}
+
+// StatusResponse 是 /status 的响应, 也是 /events 推送的数据
+type StatusResponse struct {
+	State       string     `json:"state"`
+	Event       string     `json:"event"`
+	Reason      string     `json:"reason,omitempty"`
+	Since       time.Time  `json:"since"`
+	Session     string     `json:"session,omitempty"`
+	LastLogin   *time.Time `json:"lastLogin,omitempty"`
+	LastError   string     `json:"lastError,omitempty"`
+	LastErrorAt *time.Time `json:"lastErrorAt,omitempty"`
+}
+
+func newStatusResponse(info StateInfo) StatusResponse {
+	return StatusResponse{
+		State:       info.State.String(),
+		Event:       info.Event.String(),
+		Reason:      info.Reason,
+		Since:       info.Since,
+		Session:     info.Session,
+		LastLogin:   optionalTime(info.LastLogin),
+		LastError:   info.LastError,
+		LastErrorAt: optionalTime(info.LastErrorAt),
+	}
+}
+
+// optionalTime 让零值时间在 JSON 中省略
+func optionalTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// ValidateControl 检查控制接口的配置: 必须有 token, 只能监听回环地址或 unix socket
+func ValidateControl(c config.ControlConfig) error {
+	if len(c.Token) == 0 {
+		return errors.New("control token is required")
+	}
+	if strings.HasPrefix(c.Listen, "unix:") {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(c.Listen)
+	if err != nil {
+		return fmt.Errorf("invalid control listen address %q: %w", c.Listen, err)
+	}
+	if ip := net.ParseIP(host); host != "localhost" && (ip == nil || !ip.IsLoopback()) {
+		return fmt.Errorf("control listen address %q is not a loopback address", c.Listen)
+	}
+	return nil
+}
+
+// NewControlServer 按配置监听回环地址或 unix socket, 需要调用 Serve 开始处理请求
+func NewControlServer(d *Daemon, c config.ControlConfig) (*ControlServer, error) {
+	if err := ValidateControl(c); err != nil {
+		return nil, err
+	}
+	var ln net.Listener
+	var err error
+	if path, ok := strings.CutPrefix(c.Listen, "unix:"); ok {
+		// 上次异常退出留下的 socket 文件
+		if fi, err := os.Lstat(path); err == nil && fi.Mode()&os.ModeSocket != 0 {
+			_ = os.Remove(path)
+		}
+		// socket 应位于只有本用户可访问的目录(如 instance 的运行时目录)中, 创建后再收紧文件权限
+		if ln, err = net.Listen("unix", path); err != nil {
+			return nil, err
+		}
+		if err = os.Chmod(path, 0600); err != nil {
+			ln.Close()
+			return nil, err
+		}
+	} else if ln, err = net.Listen("tcp", c.Listen); err != nil {
+		return nil, err
+	}
+
+	s := &ControlServer{d: d, token: c.Token, listener: ln}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", s.handleStatus)
+	mux.HandleFunc("/events", s.handleEvents)
+	mux.HandleFunc("/login", s.command(d.Login))
+	mux.HandleFunc("/logout", s.command(d.Logout))
+	mux.HandleFunc("/keepalive", s.command(d.KeepAlive))
+	mux.HandleFunc("/reload", s.command(d.Reload))
+	mux.HandleFunc("/stop", s.command(d.Stop))
+	s.server = &http.Server{Handler: s.authorize(mux), ReadHeaderTimeout: 10 * time.Second}
+	return s, nil
+}
+
+// Addr 返回实际监听的地址
+func (s *ControlServer) Addr() net.Addr {
+	return s.listener.Addr()
+}
+
+// Serve 处理请求直到 Close
+func (s *ControlServer) Serve() error {
+	log.Infof("Control API listening on %s", s.listener.Addr())
+	if err := s.server.Serve(s.listener); !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Close 停止监听, 等待处理中的请求(如 /stop)写完响应
+func (s *ControlServer) Close() error {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := s.server.Shutdown(ctx); err != nil {
+		return s.server.Close()
+	}
+	return nil
+}
+
+func (s *ControlServer) authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) != 1 {
+			writeError(w, http.StatusUnauthorized, errors.New("invalid token"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (s *ControlServer) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	writeJSON(w, http.StatusOK, newStatusResponse(s.d.State()))
+}
+
+// command 执行命令并返回之后的状态, 失败时状态码为 500, 响应中带 error
+func (s *ControlServer) command(f func(ctx context.Context) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+			return
+		}
+		if err := f(r.Context()); err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, newStatusResponse(s.d.State()))
+	}
+}
+
+func (s *ControlServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, errors.New("method not allowed"))
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming is not supported"))
+		return
+	}
+	ch, cancel := s.d.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	send := func(info StateInfo) error {
+		data, err := json.Marshal(newStatusResponse(info))
+		if err != nil {
+			return err
+		}
+		if _, err = fmt.Fprintf(w, "event: state\ndata: %s\n\n", data); err != nil {
+			return err
+		}
+		flusher.Flush()
+		return nil
+	}
+	// 先推送当前状态
+	if send(s.d.State()) != nil {
+		return
+	}
+	ticker := time.NewTicker(30 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-s.d.stopped:
+			// 推送退出前最后的状态变化
+			for {
+				select {
+				case info := <-ch:
+					if send(info) != nil {
+						return
+					}
+				default:
+					return
+				}
+			}
+		case info := <-ch:
+			if send(info) != nil {
+				return
+			}
+		case <-ticker.C:
+			// 注释行, 防止空闲连接被中间设备断开
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		}
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,70 @@
+package portal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// ControlClient 访问运行中进程的 ControlServer
+type ControlClient struct {
+	base   string
+	token  string
+	client *http.Client
+}
+
+// NewControlClient 的 listen 与 config.ControlConfig.Listen 的写法相同
+func NewControlClient(listen, token string) *ControlClient {
+	c := &ControlClient{base: "http://" + listen, token: token, client: &http.Client{}}
+	if path, ok := strings.CutPrefix(listen, "unix:"); ok {
+		c.base = "http://shunet"
+		c.client.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+	}
+	return c
+}
+
+// Status 返回 GET /status 的结果
+func (c *ControlClient) Status(ctx context.Context) (*StatusResponse, error) {
+	return c.do(ctx, http.MethodGet, "/status")
+}
+
+// Command 执行 login, logout, keepalive, reload 或 stop, 返回之后的状态
+func (c *ControlClient) Command(ctx context.Context, name string) (*StatusResponse, error) {
+	return c.do(ctx, http.MethodPost, "/"+name)
+}
+
+func (c *ControlClient) do(ctx context.Context, method, path string) (*StatusResponse, error) {
+	req, err := http.NewRequestWithContext(ctx, method, c.base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		var e struct {
+			Error string `json:"error"`
+		}
+		if err = json.NewDecoder(resp.Body).Decode(&e); err != nil || len(e.Error) == 0 {
+			return nil, fmt.Errorf("control api: %s", resp.Status)
+		}
+		return nil, errors.New(e.Error)
+	}
+	status := &StatusResponse{}
+	if err = json.NewDecoder(resp.Body).Decode(status); err != nil {
+		return nil, err
+	}
+	return status, nil
+}
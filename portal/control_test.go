@@ -0,0 +1,134 @@
+package portal_test
+
+import (
+	"golang.org/x/net/context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"runtime"
+	"shunet/config"
+	"shunet/portal"
+	"shunet/shuclient/portaltest"
+	"strings"
+	"testing"
+)
+
+const testToken = "0123456789abcdef"
+
+// startControl 在 listen 上为 d 提供控制接口
+func startControl(t *testing.T, d *portal.Daemon, listen string) *portal.ControlServer {
+	t.Helper()
+	s, err := portal.NewControlServer(d, config.ControlConfig{Listen: listen, Token: testToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	t.Cleanup(func() { s.Close() })
+	return s
+}
+
+func TestValidateControl(t *testing.T) {
+	tests := []struct {
+		listen, token string
+		ok            bool
+	}{
+		{"127.0.0.1:7788", testToken, true},
+		{"[::1]:7788", testToken, true},
+		{"localhost:7788", testToken, true},
+		{"unix:/run/shunet.sock", testToken, true},
+		{"127.0.0.1:7788", "", false},
+		{"0.0.0.0:7788", testToken, false},
+		{"192.168.1.2:7788", testToken, false},
+		{"127.0.0.1", testToken, false},
+	}
+	for _, tt := range tests {
+		err := portal.ValidateControl(config.ControlConfig{Listen: tt.listen, Token: tt.token})
+		if (err == nil) != tt.ok {
+			t.Errorf("ValidateControl(%q, %q) = %v, want ok %v", tt.listen, tt.token, err, tt.ok)
+		}
+	}
+}
+
+func TestControlUnauthorized(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	d := startDaemon(t, s, "secret", "")
+	cs := startControl(t, d.Daemon, "127.0.0.1:0")
+	url := "http://" + cs.Addr().String() + "/status"
+
+	for _, auth := range []string{"", "Bearer wrong", "Basic " + testToken, testToken} {
+		req, _ := http.NewRequest(http.MethodGet, url, nil)
+		if len(auth) > 0 {
+			req.Header.Set("Authorization", auth)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusUnauthorized {
+			t.Errorf("Authorization %q: status %d, want 401", auth, resp.StatusCode)
+		}
+	}
+	if _, err := portal.NewControlClient(cs.Addr().String(), "wrong").Command(context.Background(), "logout"); err == nil {
+		t.Error("logout with a wrong token succeeded")
+	}
+	if n := s.Calls(portaltest.EndpointLogout); n != 0 {
+		t.Errorf("%d logouts from unauthorized requests", n)
+	}
+}
+
+func TestControlCommands(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	d := startDaemon(t, s, "secret", "")
+	d.WaitFor(t, portal.StateOnline)
+
+	listen := "127.0.0.1:0"
+	if runtime.GOOS != "windows" {
+		listen = "unix:" + filepath.Join(t.TempDir(), "control.sock")
+	}
+	cs := startControl(t, d.Daemon, listen)
+	if path, ok := strings.CutPrefix(listen, "unix:"); ok {
+		fi, err := os.Stat(path)
+		if err != nil || fi.Mode().Perm() != 0600 {
+			t.Errorf("socket mode = %v, %v, want 0600", fi.Mode(), err)
+		}
+	} else {
+		listen = cs.Addr().String()
+	}
+	c := portal.NewControlClient(listen, testToken)
+	ctx := context.Background()
+
+	tests := []struct {
+		command  string
+		want     string
+		sessions int
+	}{
+		{"status", "Online", 1},
+		{"keepalive", "Online", 1},
+		{"logout", "LoggedOut", 0},
+		{"login", "Online", 1},
+	}
+	for _, tt := range tests {
+		var status *portal.StatusResponse
+		var err error
+		if tt.command == "status" {
+			status, err = c.Status(ctx)
+		} else {
+			status, err = c.Command(ctx, tt.command)
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.command, err)
+		}
+		if status.State != tt.want {
+			t.Errorf("%s: state %s, want %s", tt.command, status.State, tt.want)
+		}
+		if n := len(s.Sessions()); n != tt.sessions {
+			t.Errorf("%s: %d sessions, want %d", tt.command, n, tt.sessions)
+		}
+	}
+	if _, err := c.Command(ctx, "unknown"); err == nil {
+		t.Error("unknown command succeeded")
+	}
+}
@@ -0,0 +1,477 @@
+package portal
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"shunet/config"
+	"sync"
+	"time"
+)
+
+// Daemon 针对一个 Authenticator 循环执行探测、登录与保活
+type Daemon struct {
+	auth          Authenticator
+	cfg           *config.Config
+	prober        *Prober       // 为 nil 时只相信门户的保活结果
+	delayTime     time.Duration // 门户未下发心跳间隔时使用的间隔
+	keepAliveMin  time.Duration
+	keepAliveMax  time.Duration
+	nextKeepAlive time.Time
+	online        bool
+	mu            sync.Mutex // 保护 retry, Resume 在其他 goroutine 中重置退避
+	retry         map[Phase]*backoff
+	kindRetry     map[error]*backoff // 登录失败按门户返回的类型各自退避
+	retryAt       time.Time          // StateBackoff 结束的时间
+	resume        chan struct{}
+	reloader      Reloader
+	commands      chan command
+	waiters       []chan error  // 等待 Login 结果的命令, 只在 Run 中访问
+	stopped       chan struct{} // Run 退出时关闭
+	quit          chan struct{} // Stop 时关闭, 效果与取消 Run 的 ctx 相同
+	quitOnce      sync.Once
+	stateMu       sync.Mutex
+	state         StateInfo
+	subscribers   map[chan StateInfo]struct{}
+}
+
+// Reloader 根据重新读取的配置创建 Authenticator, 用于 Daemon.Reload
+type Reloader func(c *config.Config) (Authenticator, error)
+
+func NewDaemon(auth Authenticator, c *config.Config) *Daemon {
+	d := &Daemon{
+		resume:      make(chan struct{}, 1),
+		commands:    make(chan command),
+		stopped:     make(chan struct{}),
+		quit:        make(chan struct{}),
+		subscribers: make(map[chan StateInfo]struct{}),
+	}
+	d.configure(auth, c)
+	return d
+}
+
+// SetReloader 设置 Reload 时创建 Authenticator 的方式, 未设置时 Reload 只更新 Daemon 自身的配置
+func (d *Daemon) SetReloader(r Reloader) {
+	d.reloader = r
+}
+
+// configure 按配置设置 Daemon 的参数, 同时用于 NewDaemon 与 Reload
+func (d *Daemon) configure(auth Authenticator, c *config.Config) {
+	delayTime := 60 * time.Second
+	if c.DelayTime > 0 {
+		delayTime = time.Duration(c.DelayTime) * time.Second
+	}
+	keepAliveMin := 10 * time.Second
+	if c.KeepAlive.MinInterval > 0 {
+		keepAliveMin = time.Duration(c.KeepAlive.MinInterval) * time.Second
+	}
+	keepAliveMax := time.Duration(c.KeepAlive.MaxInterval) * time.Second
+
+	var prober *Prober
+	if c.Connectivity.Enabled {
+		var err error
+		if prober, err = NewProber(c); err != nil {
+			log.Errorf("Connectivity probing disabled: %v", err)
+		}
+	}
+
+	if h, ok := auth.(HostProvider); ok && prober != nil {
+		prober.SetPortalHost(h.PortalHost())
+	}
+	if a, ok := auth.(DaemonAttacher); ok {
+		a.AttachDaemon(d)
+	}
+
+	policy := NewRetryPolicy(c.Retry)
+	// 探测失败多是断网或门户不可达, 与账号无关, 恢复后即可继续, 不转入人工处理
+	detect := policy
+	detect.MaxFailures = 0
+	d.auth, d.cfg, d.prober = auth, c, prober
+	d.delayTime, d.keepAliveMin, d.keepAliveMax = delayTime, keepAliveMin, keepAliveMax
+	d.mu.Lock()
+	d.retry = map[Phase]*backoff{
+		PhaseDetect:  {policy: detect},
+		PhasePrepare: {policy: policy},
+		PhaseLogin:   {policy: policy},
+	}
+	d.kindRetry = make(map[error]*backoff)
+	for kind, p := range kindPolicies(policy) {
+		d.kindRetry[kind] = &backoff{policy: p}
+	}
+	d.mu.Unlock()
+}
+
+func (d *Daemon) Run(ctx context.Context) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-d.quit:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	d.transition(EventStart, "daemon started")
+	d.restoreSession(ctx)
+	for {
+		if ctx.Err() != nil && d.State().State != StateShuttingDown {
+			d.transition(EventShutdown, "receive stop signal")
+		}
+		d.notifyWaiters()
+		switch d.State().State {
+		case StateProbing:
+			d.probe(ctx)
+		case StateUnauthenticated:
+			d.prepare(ctx)
+		case StateAuthenticating:
+			d.authenticate(ctx)
+		case StateOnline:
+			if d.wait(ctx, time.Until(d.nextKeepAlive)) {
+				d.keepAlive(ctx)
+			}
+		case StateBackoff:
+			if d.wait(ctx, time.Until(d.retryAt)) {
+				d.transition(EventRetry, "backoff elapsed")
+			}
+		case StateNeedsAttention:
+			select {
+			case <-ctx.Done():
+			case cmd := <-d.commands:
+				d.handle(ctx, cmd)
+			case <-d.resume:
+				d.transition(EventResume, "resumed")
+			}
+		case StateLoggedOut:
+			select {
+			case <-ctx.Done():
+			case cmd := <-d.commands:
+				d.handle(ctx, cmd)
+			}
+		case StateShuttingDown:
+			d.shutdown()
+			close(d.stopped)
+			return
+		}
+	}
+}
+
+// wait 等待 t 时间, ctx 取消或期间执行了命令时返回 false
+func (d *Daemon) wait(ctx context.Context, t time.Duration) bool {
+	log.Infof("Sleep %v", t.Round(time.Millisecond).String())
+	timer := time.NewTimer(t)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case cmd := <-d.commands:
+		d.handle(ctx, cmd)
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// Resume 让处于 StateNeedsAttention 的 Run 重新开始登录
+func (d *Daemon) Resume() {
+	if d.State().State != StateNeedsAttention {
+		return
+	}
+	d.resetRetry()
+	select {
+	case d.resume <- struct{}{}:
+	default:
+	}
+}
+
+// fail 按步骤计算退避时间, 相同错误连续出现超出预算时转入 StateNeedsAttention
+func (d *Daemon) fail(phase Phase, e Event, reason string) {
+	d.failWith(phase, nil, e, reason)
+}
+
+// failWith 与 fail 相同, 但 kind 有单独的退避策略时使用该策略
+func (d *Daemon) failWith(phase Phase, kind error, e Event, reason string) {
+	d.mu.Lock()
+	b, ok := d.kindRetry[kind]
+	if !ok {
+		b = d.retry[phase]
+	}
+	delay, exhausted := b.fail(reason)
+	d.mu.Unlock()
+	if exhausted {
+		log.Errorf("%v keeps failing with %q, stop retrying until resumed", phase, reason)
+		d.transition(EventNeedsAttention, phase.String()+": "+reason)
+		return
+	}
+	d.retryAt = time.Now().Add(delay)
+	d.transition(e, reason)
+}
+
+// resetRetry 清空步骤的连续失败记录, 不指定 phase 时清空所有步骤
+func (d *Daemon) resetRetry(phases ...Phase) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(phases) == 0 {
+		phases = []Phase{PhaseDetect, PhasePrepare, PhaseLogin}
+	}
+	for _, p := range phases {
+		d.retry[p].reset()
+		if p == PhaseLogin {
+			for _, b := range d.kindRetry {
+				b.reset()
+			}
+		}
+	}
+}
+
+// stop 用于重试没有意义的错误, 直接转入 StateNeedsAttention
+func (d *Daemon) stop(phase Phase, reason string) {
+	log.Errorf("%v failed with %q, stop retrying until resumed", phase, reason)
+	d.transition(EventNeedsAttention, phase.String()+": "+reason)
+}
+
+// loginFailed 按门户返回的失败类型决定如何重试
+func (d *Daemon) loginFailed(ctx context.Context, err error) {
+	switch {
+	case errors.Is(err, ErrWrongPassword), errors.Is(err, ErrAccountArrears), errors.Is(err, ErrAccountLocked):
+		// 继续重试只会导致账号被锁
+		d.stop(PhaseLogin, err.Error())
+	case errors.Is(err, ErrDeviceLimit):
+		switch d.cfg.OnDeviceLimit {
+		case "stop":
+			d.stop(PhaseLogin, err.Error())
+		case "kick-oldest":
+			d.kickOldest(ctx)
+			d.fail(PhaseLogin, EventLoginFailed, err.Error())
+		default:
+			d.fail(PhaseLogin, EventLoginFailed, err.Error())
+		}
+	default:
+		// 验证码、门户故障(含连接失败)、MAC 校验失败与未知错误各有退避策略, 见 kindPolicies
+		d.failWith(PhaseLogin, failureKind(err), EventLoginFailed, err.Error())
+	}
+}
+
+// kickOldest 下线最早登录的会话, 下一次重试时即可登录.
+// 仍按登录失败退避, 避免门户下线不生效时反复踢人
+func (d *Daemon) kickOldest(ctx context.Context) {
+	m, ok := d.auth.(DeviceManager)
+	if !ok {
+		log.Warning("Portal does not support device management, can not kick-oldest")
+		return
+	}
+	devices, err := m.Devices(ctx)
+	if err != nil {
+		log.Warningf("List devices err: %v", err)
+		return
+	}
+	if len(devices) == 0 {
+		log.Warning("Device limit reached but no online device is listed")
+		return
+	}
+	oldest := devices[0]
+	for _, dev := range devices[1:] {
+		if dev.LoginTime.Before(oldest.LoginTime) {
+			oldest = dev
+		}
+	}
+	if err = m.Kick(ctx, oldest); err != nil {
+		log.Warningf("Kick device %s err: %v", oldest.IP, err)
+		return
+	}
+	log.Warningf("Device limit reached, kicked the oldest session: ip %s, mac %s, login at %s",
+		oldest.IP, oldest.MAC, oldest.LoginTime.Format(time.DateTime))
+}
+
+// restoreSession 使用上次保存的会话, 仍然有效则跳过登录
+func (d *Daemon) restoreSession(ctx context.Context) {
+	store, ok := d.auth.(SessionStore)
+	if !ok {
+		return
+	}
+	ok, err := store.RestoreSession(ctx)
+	if err != nil {
+		log.Infof("Saved session is invalid: %v", err)
+		d.clearSession()
+		return
+	}
+	if !ok {
+		return
+	}
+	log.Info("Restore saved session")
+	d.online = true
+	d.scheduleKeepAlive()
+	d.transition(EventSessionRestored, "saved session is alive")
+}
+
+func (d *Daemon) saveSession() {
+	if store, ok := d.auth.(SessionStore); ok {
+		if err := store.SaveSession(); err != nil {
+			log.Warningf("SaveSession err: %+v", err)
+		}
+	}
+}
+
+func (d *Daemon) clearSession() {
+	if store, ok := d.auth.(SessionStore); ok {
+		if err := store.ClearSession(); err != nil {
+			log.Warningf("ClearSession err: %+v", err)
+		}
+	}
+}
+
+func (d *Daemon) probe(ctx context.Context) {
+	d.online = false
+	online, err := d.auth.Detect(ctx)
+	if d.interrupted(ctx, err) {
+		return
+	}
+	if err != nil {
+		log.Errorf("Detect err: %+v", err)
+		d.fail(PhaseDetect, EventProbeFailed, err.Error())
+		return
+	}
+	d.resetRetry(PhaseDetect)
+	if h, ok := d.auth.(HostProvider); ok && d.prober != nil {
+		d.prober.SetPortalHost(h.PortalHost())
+	}
+	if online && d.captive(ctx) {
+		log.Warning("portal reports online but traffic is redirected, login again")
+		d.transition(EventPortalRedirect, "traffic redirected to portal")
+		return
+	}
+	if online {
+		log.Warning("already login, skip login")
+		d.online = true
+		d.scheduleKeepAlive()
+		d.saveSession()
+		d.transition(EventAlreadyOnline, "portal reports online")
+		return
+	}
+	d.transition(EventPortalRedirect, "redirected to portal")
+}
+
+func (d *Daemon) prepare(ctx context.Context) {
+	if p, ok := d.auth.(Preparer); ok {
+		if err := p.Prepare(ctx); err != nil {
+			if d.interrupted(ctx, err) {
+				return
+			}
+			log.Errorf("Prepare err: %v", err)
+			d.failWith(PhasePrepare, failureKind(err), EventPrepareFailed, err.Error())
+			return
+		}
+		d.resetRetry(PhasePrepare)
+	}
+	d.transition(EventPrepared, "ready to login")
+}
+
+func (d *Daemon) authenticate(ctx context.Context) {
+	if err := d.auth.Login(ctx); err != nil {
+		if d.interrupted(ctx, err) {
+			return
+		}
+		log.Warningf("Login fail: %v", err)
+		d.loginFailed(ctx, err)
+		return
+	}
+	d.resetRetry(PhaseLogin)
+	d.online = true
+	d.scheduleKeepAlive()
+	d.saveSession()
+	log.Info("Login success")
+	d.transition(EventLoginSuccess, "login success")
+}
+
+func (d *Daemon) keepAlive(ctx context.Context) {
+	if err := d.auth.KeepAlive(ctx); err != nil {
+		if d.interrupted(ctx, err) {
+			// 会话仍然有效, 交给 shutdown 下线或保存
+			return
+		}
+		d.online = false
+		log.Warningf("KeepAlive fail: %v", err)
+		d.clearSession()
+		d.transition(EventKeepAliveFailed, err.Error())
+		return
+	}
+	log.Info("KeepAlive")
+	if d.captive(ctx) {
+		d.online = false
+		log.Warning("KeepAlive success but traffic is redirected to portal")
+		d.clearSession()
+		d.transition(EventCaptive, "traffic redirected to portal")
+		return
+	}
+	d.scheduleKeepAlive()
+	d.transition(EventKeepAliveOK, "keepalive success")
+}
+
+// interrupted 判断请求是否因 Run 的 ctx 取消而中断, 此时不算作失败, 由下一轮循环进入 StateShuttingDown
+func (d *Daemon) interrupted(ctx context.Context, err error) bool {
+	if err == nil || ctx.Err() == nil {
+		return false
+	}
+	log.Infof("Request aborted: %v", err)
+	return true
+}
+
+// captive 用探测确认外网是否可用, 只有流量被重定向到门户时才返回 true.
+// 所有探测都失败时多半是外网故障, 重新登录无济于事
+func (d *Daemon) captive(ctx context.Context) bool {
+	if d.prober == nil {
+		return false
+	}
+	connectivity, results := d.prober.Check(ctx)
+	for _, r := range results {
+		if r.Err != nil {
+			log.Debugf("probe %s: %v (%v)", r.Name, r.Err, r.Latency.Round(time.Millisecond))
+		}
+	}
+	switch connectivity {
+	case ConnectivityCaptive:
+		return true
+	case ConnectivityOffline:
+		log.Warning("All connectivity probes failed, the network may be down")
+	}
+	return false
+}
+
+// heartbeat 返回心跳间隔: 优先使用门户下发的间隔, 并限制在配置的范围内
+func (d *Daemon) heartbeat() time.Duration {
+	var t time.Duration
+	if h, ok := d.auth.(HeartbeatHinter); ok {
+		t = h.KeepAliveInterval()
+	}
+	if t <= 0 {
+		t = d.delayTime
+	}
+	if t < d.keepAliveMin {
+		t = d.keepAliveMin
+	}
+	if d.keepAliveMax > 0 && t > d.keepAliveMax {
+		t = d.keepAliveMax
+	}
+	return t
+}
+
+func (d *Daemon) scheduleKeepAlive() {
+	d.nextKeepAlive = time.Now().Add(d.heartbeat())
+}
+
+func (d *Daemon) shutdown() {
+	log.Info("Daemon.Run Receive stop signal, Daemon Run exit")
+	switch {
+	case !d.online:
+		log.Info("Already logout!")
+	case d.cfg.KeepSessionOnExit:
+		log.Info("Keep session on exit, skip logout")
+		d.saveSession()
+	default:
+		// Run 的 ctx 已取消, 给下线请求单独留出有限的时间
+		ctx, cancel := context.WithTimeout(context.Background(), seconds(d.cfg.Timeout.Logout, 5))
+		defer cancel()
+		if err := d.auth.Logout(ctx); err != nil {
+			log.Errorf("Daemon.Run Logout err: %+v", err)
+		}
+		d.clearSession()
+	}
+}
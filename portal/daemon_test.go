@@ -0,0 +1,361 @@
+package portal_test
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"shunet/portal"
+	"shunet/shuclient"
+	"shunet/shuclient/portaltest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func startDaemon(t *testing.T, s *portaltest.Server, password, extra string) *portaltest.Daemon {
+	t.Helper()
+	c := s.Config(t, password, "keepalive: { minInterval: 1 }\nretry: { initialDelay: 1, maxDelay: 1, maxFailures: 2 }\n"+extra)
+	client, err := shuclient.NewClient(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return portaltest.StartDaemon(t, client.Authenticator(), c)
+}
+
+func TestDaemonReloginAfterExpiry(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	// 会话在下一次保活之前过期
+	s.SessionTTL = 500 * time.Millisecond
+	s.KeepAliveInterval = 1
+	d := startDaemon(t, s, "secret", "")
+
+	first := d.WaitFor(t, portal.StateOnline)
+	if len(first.Session) == 0 {
+		t.Error("no session id while online")
+	}
+	d.WaitFor(t, portal.StateProbing)
+	second := d.WaitFor(t, portal.StateOnline)
+	if second.Session == first.Session {
+		t.Errorf("session %s was not renewed", second.Session)
+	}
+	if !second.LastLogin.After(first.LastLogin) {
+		t.Errorf("LastLogin %v not after %v", second.LastLogin, first.LastLogin)
+	}
+	if n := s.Calls(portaltest.EndpointLogin); n != 2 {
+		t.Errorf("%d logins, want 2", n)
+	}
+}
+
+func TestDaemonWrongPassword(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	d := startDaemon(t, s, "wrong", "")
+
+	info := d.WaitFor(t, portal.StateNeedsAttention)
+	if !strings.Contains(info.Reason, portal.ErrWrongPassword.Error()) {
+		t.Errorf("Reason = %q, want wrong password", info.Reason)
+	}
+	// 密码错误不重试, 以免账号被锁
+	time.Sleep(1500 * time.Millisecond)
+	if n := s.Calls(portaltest.EndpointLogin); n != 1 {
+		t.Errorf("%d logins, want 1", n)
+	}
+	if state := d.State().State; state != portal.StateNeedsAttention {
+		t.Errorf("state = %v, want NeedsAttention", state)
+	}
+}
+
+func TestDaemonLoginFailures(t *testing.T) {
+	tests := []struct {
+		name, message, extra string
+		want                 portal.State
+		logins               int // 停止重试时的登录次数, 0 表示应继续重试
+	}{
+		{"arrears", "账户欠费,请充值", "", portal.StateNeedsAttention, 1},
+		{"locked", "账号已锁定", "", portal.StateNeedsAttention, 1},
+		{"device limit stop", portaltest.MessageDeviceLimit, "onDeviceLimit: stop\n", portal.StateNeedsAttention, 1},
+		{"device limit", portaltest.MessageDeviceLimit, "", portal.StateBackoff, 0},
+		{"service unavailable", "系统繁忙", "", portal.StateBackoff, 0},
+		{"unknown", "未知错误", "", portal.StateBackoff, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := portaltest.NewServer("20120001", "secret")
+			defer s.Close()
+			s.Inject(portaltest.EndpointLogin, portaltest.Failure{Message: tt.message})
+			d := startDaemon(t, s, "secret", tt.extra)
+
+			d.WaitFor(t, tt.want)
+			if tt.logins > 0 {
+				time.Sleep(1500 * time.Millisecond)
+				if n := s.Calls(portaltest.EndpointLogin); n != tt.logins {
+					t.Errorf("%d logins, want %d", n, tt.logins)
+				}
+				return
+			}
+			// 可重试的失败在门户恢复后登录
+			s.ClearFailures()
+			d.WaitFor(t, portal.StateOnline)
+		})
+	}
+}
+
+func TestDaemonDetectOutage(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	// 门户连续不可达的次数超过 MaxFailures(2), 恢复后应自行登录而不是等待 Resume
+	s.Inject(portaltest.EndpointRoot, portaltest.Failure{Status: http.StatusServiceUnavailable, Times: 7})
+	d := startDaemon(t, s, "secret", "")
+
+	d.WaitFor(t, portal.StateOnline)
+	if n := s.Calls(portaltest.EndpointRoot); n < 7 {
+		t.Errorf("%d detects, want at least 7", n)
+	}
+}
+
+func TestDaemonCaptchaPending(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	s.CaptchaAfter = 1
+	dir := t.TempDir()
+	// 第一次密码错误, 修正后需要验证码, 等待应答期间应持续退避重试而不是转入 NeedsAttention
+	d := startDaemon(t, s, "wrong", "")
+	d.WaitFor(t, portal.StateNeedsAttention)
+	d.Stop()
+	d = startDaemon(t, s, "secret", "captcha: { solver: pause, dir: "+dir+" }\n")
+
+	var images []string
+	for deadline := time.Now().Add(10 * time.Second); len(images) == 0 && time.Now().Before(deadline); {
+		time.Sleep(50 * time.Millisecond)
+		images, _ = filepath.Glob(filepath.Join(dir, "shunet-captcha-*", "captcha.jpg"))
+	}
+	if len(images) != 1 {
+		t.Fatalf("images = %v, want one", images)
+	}
+	time.Sleep(2500 * time.Millisecond)
+	if info := d.State(); info.State != portal.StateBackoff && info.State != portal.StateProbing {
+		t.Fatalf("state = %+v while waiting for the captcha", info)
+	}
+	if err := os.WriteFile(images[0]+".txt", []byte(s.CaptchaCode()), 0600); err != nil {
+		t.Fatal(err)
+	}
+	d.WaitFor(t, portal.StateOnline)
+}
+
+func TestDaemonLogoutOnShutdown(t *testing.T) {
+	tests := []struct {
+		name        string
+		extra       string
+		wantLogout  int
+		wantSession int
+	}{
+		{"logout", "", 1, 0},
+		{"keep session", "keepSessionOnExit: true\n", 0, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := portaltest.NewServer("20120001", "secret")
+			defer s.Close()
+			d := startDaemon(t, s, "secret", tt.extra)
+
+			d.WaitFor(t, portal.StateOnline)
+			d.Stop()
+			if n := s.Calls(portaltest.EndpointLogout); n != tt.wantLogout {
+				t.Errorf("%d logouts, want %d", n, tt.wantLogout)
+			}
+			if n := len(s.Sessions()); n != tt.wantSession {
+				t.Errorf("%d sessions after shutdown, want %d", n, tt.wantSession)
+			}
+		})
+	}
+}
+
+func TestDaemonRestoreSession(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	c := s.Config(t, "secret", "keepSessionOnExit: true\n")
+	start := func() *portaltest.Daemon {
+		client, err := shuclient.NewClient(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return portaltest.StartDaemon(t, client.Authenticator(), c)
+	}
+
+	d := start()
+	first := d.WaitFor(t, portal.StateOnline)
+	d.Stop()
+	// 重启后继续使用保存的会话, 不重新登录
+	d = start()
+	second := d.WaitFor(t, portal.StateOnline)
+	if n := s.Calls(portaltest.EndpointLogin); n != 1 {
+		t.Errorf("%d logins, want 1", n)
+	}
+	if second.Session != first.Session {
+		t.Errorf("session = %s, want the saved %s", second.Session, first.Session)
+	}
+	d.Stop()
+
+	// 保存的会话已失效时重新登录
+	s.ExpireSessions()
+	d = start()
+	d.WaitFor(t, portal.StateOnline)
+	if n := s.Calls(portaltest.EndpointLogin); n != 2 {
+		t.Errorf("%d logins after the session expired, want 2", n)
+	}
+}
+
+// fakeAuth 只实现 portal.Authenticator, 不实现任何可选接口
+type fakeAuth struct {
+	mu      sync.Mutex
+	online  bool
+	expired bool // 为 true 时保活失败并下线
+	calls   map[string]int
+}
+
+func (f *fakeAuth) call(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.calls == nil {
+		f.calls = make(map[string]int)
+	}
+	f.calls[name]++
+}
+
+func (f *fakeAuth) count(name string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.calls[name]
+}
+
+func (f *fakeAuth) expire() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.expired = true
+}
+
+func (f *fakeAuth) Detect(ctx context.Context) (bool, error) {
+	f.call("detect")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.online, nil
+}
+
+func (f *fakeAuth) Login(ctx context.Context) error {
+	f.call("login")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.online, f.expired = true, false
+	return nil
+}
+
+func (f *fakeAuth) KeepAlive(ctx context.Context) error {
+	f.call("keepalive")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.expired {
+		f.online = false
+		return errors.New("session expired")
+	}
+	return nil
+}
+
+func (f *fakeAuth) Logout(ctx context.Context) error {
+	f.call("logout")
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.online = false
+	return nil
+}
+
+func (f *fakeAuth) Status(ctx context.Context) (*portal.Status, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return &portal.Status{Online: f.online}, nil
+}
+
+func TestDaemonAuthenticator(t *testing.T) {
+	auth := &fakeAuth{}
+	c := portaltest.LoadConfig(t, "userId: 20120001\npassword: secret\nhost: 127.0.0.1\ndelayTime: 1\nkeepalive: { minInterval: 1 }\n")
+	d := portaltest.StartDaemon(t, auth, c)
+
+	d.WaitFor(t, portal.StateOnline)
+	if info := d.State(); info.Event != portal.EventLoginSuccess || info.LastLogin.IsZero() || len(info.Session) > 0 {
+		t.Errorf("state = %+v, want logged in without a session id", info)
+	}
+	d.WaitFor(t, portal.StateOnline)
+	if n := auth.count("keepalive"); n == 0 {
+		t.Error("no keepalive while online")
+	}
+
+	// 保活失败后重新探测并登录
+	auth.expire()
+	d.WaitFor(t, portal.StateProbing)
+	d.WaitFor(t, portal.StateOnline)
+	if info := d.State(); info.LastError != "session expired" {
+		t.Errorf("LastError = %q, want session expired", info.LastError)
+	}
+	if n := auth.count("login"); n != 2 {
+		t.Errorf("%d logins, want 2", n)
+	}
+
+	d.Stop()
+	if n := auth.count("logout"); n != 1 {
+		t.Errorf("%d logouts on shutdown, want 1", n)
+	}
+	if state := d.State().State; state != portal.StateShuttingDown {
+		t.Errorf("state after stop = %v", state)
+	}
+}
+
+func TestDaemonStopDuringRequest(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	// 登录请求与退出时的下线请求都不响应
+	s.Inject(portaltest.EndpointLogin, portaltest.Failure{Delay: 30 * time.Second})
+	d := startDaemon(t, s, "secret", "timeout: { logout: 1 }\n")
+	d.WaitFor(t, portal.StateAuthenticating)
+	time.Sleep(200 * time.Millisecond)
+
+	start := time.Now()
+	d.Stop()
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Errorf("Stop took %v while login was hanging", elapsed)
+	}
+	// 中断的请求不计为登录失败
+	if info := d.State(); info.State != portal.StateShuttingDown || len(info.LastError) > 0 {
+		t.Errorf("state after stop = %+v", info)
+	}
+
+	s.ClearFailures()
+	s.Inject(portaltest.EndpointLogout, portaltest.Failure{Delay: 30 * time.Second})
+	d = startDaemon(t, s, "secret", "timeout: { logout: 1 }\n")
+	d.WaitFor(t, portal.StateOnline)
+	start = time.Now()
+	d.Stop()
+	if elapsed := time.Since(start); elapsed < time.Second || elapsed > 3*time.Second {
+		t.Errorf("Stop took %v, want the 1s logout timeout", elapsed)
+	}
+}
+
+func TestDaemonKickOldest(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	s.MaxDevices = 2
+	s.AddSession("10.0.0.1", "aa:bb:cc:dd:ee:01", time.Now().Add(-2*time.Hour))
+	newer := s.AddSession("10.0.0.2", "aa:bb:cc:dd:ee:02", time.Now().Add(-time.Hour))
+	d := startDaemon(t, s, "secret", "onDeviceLimit: kick-oldest\n")
+
+	info := d.WaitFor(t, portal.StateOnline)
+	sessions := s.Sessions()
+	if len(sessions) != 2 || sessions[0].UserIndex != newer.UserIndex || sessions[1].UserIndex != info.Session {
+		t.Errorf("sessions = %+v, want %s and ours", sessions, newer.UserIndex)
+	}
+	if n := s.Calls(portaltest.EndpointLogin); n != 2 {
+		t.Errorf("%d logins, want 2", n)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build linux
+// +build linux
+
+package portal
+
+import (
+	"net"
+	"syscall"
+)
+
+// bindToDevice 使用 SO_BINDTODEVICE 把连接绑定到网卡, 不受路由表影响
+func bindToDevice(d *net.Dialer, name string) {
+	d.Control = func(network, address string, rc syscall.RawConn) error {
+		var bindErr error
+		if err := rc.Control(func(fd uintptr) {
+			bindErr = syscall.BindToDevice(int(fd), name)
+		}); err != nil {
+			return err
+		}
+		return bindErr
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+// +build !linux
+
+package portal
+
+import "net"
+
+// bindToDevice 非 Linux 系统只能通过源地址选择网卡
+func bindToDevice(d *net.Dialer, name string) {}
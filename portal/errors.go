@@ -0,0 +1,104 @@
+package portal
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"net"
+	"shunet/utils"
+	"strings"
+	"unicode/utf8"
+)
+
+// 门户返回的失败类型
+var (
+	ErrWrongPassword      = errors.New("wrong user id or password")
+	ErrAccountArrears     = errors.New("account in arrears or expired")
+	ErrDeviceLimit        = errors.New("online device limit reached")
+	ErrAccountLocked      = errors.New("account locked")
+	ErrCaptchaRequired    = errors.New("captcha required")
+	ErrMacMismatch        = errors.New("mac address mismatch")
+	ErrServiceUnavailable = errors.New("portal service not available")
+	ErrUnknown            = errors.New("unknown portal error")
+)
+
+// ErrCaptchaPending 表示验证码已交给人工处理, 应答之前暂停登录
+var ErrCaptchaPending = errors.New("captcha pending, waiting for manual input")
+
+// PortalError 是门户返回的登录等操作失败的原因, 可用 errors.Is 判断类型
+type PortalError struct {
+	Kind    error
+	Message string
+}
+
+func (e *PortalError) Error() string {
+	if len(e.Message) == 0 {
+		return e.Kind.Error()
+	}
+	return e.Kind.Error() + ": " + e.Message
+}
+
+func (e *PortalError) Unwrap() error {
+	return e.Kind
+}
+
+// messageKinds 按顺序匹配, 如"密码错误次数过多,账号已锁定"应归为锁定.
+// 关键字使用完整的短语, 避免"页面已过期"、"绑定数量已达上限"等无关提示被误判
+var messageKinds = []struct {
+	kind     error
+	keywords []string
+}{
+	{ErrCaptchaRequired, []string{"验证码", "validcode", "captcha"}},
+	{ErrAccountLocked, []string{"账号已锁定", "账户已锁定", "账号被锁定", "账号已冻结", "账户已冻结", "账号被冻结", "账号已禁用", "账号被禁用", "用户已禁用", "黑名单", "account locked", "account is locked", "account disabled"}},
+	{ErrMacMismatch, []string{"mac地址校验失败", "mac地址不匹配", "mac校验失败", "mac不匹配", "mac mismatch"}},
+	{ErrDeviceLimit, []string{"在线数", "终端数", "设备数", "同时在线", "超过最大在线", "在线人数已达上限", "在线设备已达上限", "device limit", "too many devices"}},
+	{ErrAccountArrears, []string{"欠费", "余额不足", "账号已过期", "账户已过期", "账号过期", "账号已到期", "账户已到期", "套餐已到期", "已停机", "arrear", "account expired", "account has expired"}},
+	{ErrWrongPassword, []string{"密码不匹配", "密码错误", "用户不存在", "用户名或密码", "账号或密码", "账号不存在", "wrong password", "incorrect password", "invalid password", "password is error", "password error"}},
+	{ErrServiceUnavailable, []string{"服务不可用", "服务暂不可用", "系统繁忙", "服务器繁忙", "系统错误", "系统维护", "unavailable", "server busy", "system busy"}},
+}
+
+// ClassifyMessage 根据门户返回的 message 判断失败类型, 支持未转码的 GBK 文本
+func ClassifyMessage(message string) error {
+	if !utf8.ValidString(message) {
+		if s, err := utils.ConvertGBKToUTF8([]byte(message)); err == nil {
+			message = s
+		}
+	}
+	lower := strings.ToLower(message)
+	for _, k := range messageKinds {
+		for _, keyword := range k.keywords {
+			if strings.Contains(lower, keyword) {
+				return k.kind
+			}
+		}
+	}
+	return ErrUnknown
+}
+
+// kindOf 返回 err 中 *PortalError 的失败类型, 不是门户返回的失败时返回 nil
+func kindOf(err error) error {
+	var e *PortalError
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return nil
+}
+
+// failureKind 与 kindOf 相同, 但把等待验证码归为 ErrCaptchaPending, 连接失败、超时等传输错误归为 ErrServiceUnavailable
+func failureKind(err error) error {
+	if kind := kindOf(err); kind != nil {
+		return kind
+	}
+	if errors.Is(err, ErrCaptchaPending) {
+		return ErrCaptchaPending
+	}
+	var ne net.Error
+	if errors.As(err, &ne) || errors.Is(err, context.DeadlineExceeded) {
+		return ErrServiceUnavailable
+	}
+	return nil
+}
+
+// NewError 按 message 分类生成 *PortalError
+func NewError(message string) error {
+	return &PortalError{Kind: ClassifyMessage(message), Message: message}
+}
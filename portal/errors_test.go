@@ -0,0 +1,66 @@
+package portal
+
+import (
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"net"
+	"net/url"
+	"testing"
+)
+
+func TestFailureKind(t *testing.T) {
+	refused := &url.Error{Op: "Post", URL: "http://10.10.9.9/", Err: &net.OpError{Op: "dial", Net: "tcp", Err: errors.New("connection refused")}}
+	tests := []struct {
+		err  error
+		want error
+	}{
+		{NewError("密码不匹配"), ErrWrongPassword},
+		{fmt.Errorf("login: %w", refused), ErrServiceUnavailable},
+		{context.DeadlineExceeded, ErrServiceUnavailable},
+		{errors.New("neither redirect nor success page found"), nil},
+	}
+	for _, tt := range tests {
+		if got := failureKind(tt.err); got != tt.want {
+			t.Errorf("failureKind(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyMessage(t *testing.T) {
+	gbk, _ := simplifiedchinese.GBK.NewEncoder().String("密码错误")
+	tests := []struct {
+		message string
+		want    error
+	}{
+		{"密码不匹配,请输入正确的密码!", ErrWrongPassword},
+		{"用户不存在,请输入正确的用户名!", ErrWrongPassword},
+		{gbk, ErrWrongPassword},
+		{"Wrong Password", ErrWrongPassword},
+		{"账户欠费,请充值", ErrAccountArrears},
+		{"您的账号已过期", ErrAccountArrears},
+		{"您的账号在线终端数已达上限", ErrDeviceLimit},
+		{"密码错误次数过多,账号已锁定", ErrAccountLocked},
+		{"MAC地址校验失败!", ErrMacMismatch},
+		{"请输入正确的验证码", ErrCaptchaRequired},
+		{"系统繁忙,请稍后再试", ErrServiceUnavailable},
+		// 不应被误判的提示
+		{"页面已过期,请刷新", ErrUnknown},
+		{"无感知认证绑定的MAC数量已满", ErrUnknown},
+		{"", ErrUnknown},
+	}
+	for _, tt := range tests {
+		if got := ClassifyMessage(tt.message); got != tt.want {
+			t.Errorf("ClassifyMessage(%q) = %v, want %v", tt.message, got, tt.want)
+		}
+	}
+
+	err := NewError("账号已冻结")
+	if !errors.Is(err, ErrAccountLocked) || err.Error() != "account locked: 账号已冻结" {
+		t.Errorf("NewError = %v", err)
+	}
+	if err = (&PortalError{Kind: ErrUnknown}); err.Error() != ErrUnknown.Error() {
+		t.Errorf("PortalError without message = %q", err)
+	}
+}
@@ -0,0 +1,98 @@
+// Package portal 定义门户认证的通用接口, 以及基于该接口的保活守护循环.
+//
+// 各门户的实现(如 shuclient 的锐捷 ePortal)只需实现 Authenticator,
+// 即可共用 Daemon 的重试、心跳、会话保存等逻辑以及 status 等命令.
+package portal
+
+import (
+	"golang.org/x/net/context"
+	"shunet/utils"
+	"time"
+)
+
+var log = utils.Log
+
+// Authenticator 是一种门户的认证实现
+type Authenticator interface {
+	// Detect 访问门户判断是否已在线, 未在线时准备好登录所需的信息
+	Detect(ctx context.Context) (online bool, err error)
+	Login(ctx context.Context) error
+	KeepAlive(ctx context.Context) error
+	Logout(ctx context.Context) error
+	Status(ctx context.Context) (*Status, error)
+}
+
+// Preparer 由登录前需要额外步骤的门户实现, 如 ePortal 获取加密公钥.
+// 该步骤与 Login 分开退避
+type Preparer interface {
+	Prepare(ctx context.Context) error
+}
+
+// HeartbeatHinter 由会下发心跳间隔的门户实现, 返回 0 表示使用配置的间隔
+type HeartbeatHinter interface {
+	KeepAliveInterval() time.Duration
+}
+
+// SessionStore 由能够持久化会话的门户实现, 重启后先恢复会话再决定是否登录
+type SessionStore interface {
+	// RestoreSession 恢复保存的会话并确认其仍然有效
+	RestoreSession(ctx context.Context) (bool, error)
+	SaveSession() error
+	ClearSession() error
+}
+
+// HostProvider 返回门户当前使用的地址, 供连通性探测识别重定向. 地址会自动发现的门户在每次检测后更新
+type HostProvider interface {
+	PortalHost() string
+}
+
+// DeviceManager 由能够列出并下线账号在线会话的门户实现, 用于 devices 命令与 onDeviceLimit: kick-oldest
+type DeviceManager interface {
+	Devices(ctx context.Context) ([]Device, error)
+	Kick(ctx context.Context, d Device) error
+}
+
+// Device 是账号的一个在线会话
+type Device struct {
+	Session   string    `json:"session"` // 门户的会话标识, 如 ePortal 的 userIndex
+	IP        string    `json:"ip,omitempty"`
+	MAC       string    `json:"mac,omitempty"`
+	LoginTime time.Time `json:"loginTime,omitempty"`
+}
+
+// Status 是当前的在线信息
+type Status struct {
+	Online   bool   `json:"online"`
+	UserId   string `json:"userId,omitempty"`
+	UserName string `json:"userName,omitempty"`
+	IP       string `json:"ip,omitempty"`
+	MAC      string `json:"mac,omitempty"`
+	Service  string `json:"service,omitempty"`
+	Balance  string `json:"balance,omitempty"`
+	Session  string `json:"session,omitempty"` // 门户的会话标识, 如 ePortal 的 userIndex
+	Items    []Item `json:"items,omitempty"`   // 门户提供的其他统计, 如流量、在线时长
+}
+
+// Item 是门户提供的一项统计
+type Item struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// MacBinder 由支持无感知认证的门户实现: 绑定 MAC 后该设备掉线时由门户直接放行, 不需要守护进程重新登录
+type MacBinder interface {
+	BindMac(ctx context.Context, mac string) error
+	UnbindMac(ctx context.Context, mac string) error
+	BoundMacs(ctx context.Context) ([]string, error)
+}
+
+// SessionIdentifier 由能给出当前会话标识的门户实现, 如 ePortal 的 userIndex, 用于控制接口的 /status
+type SessionIdentifier interface {
+	SessionID() string
+}
+
+// DaemonAttacher 由需要观察自身状态的门户实现, 如 shuclient.Client.State.
+// Daemon 创建时以及 Reload 换用新的 Authenticator 时调用 AttachDaemon
+type DaemonAttacher interface {
+	AttachDaemon(d *Daemon)
+}
@@ -0,0 +1,260 @@
+package portal
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"shunet/config"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 探测类型
+const (
+	ProbeHTTP = "http"
+	ProbeTCP  = "tcp"
+	ProbeDNS  = "dns"
+)
+
+// DefaultProbes 是未配置探测地址时使用的探测
+var DefaultProbes = []config.ProbeConfig{
+	{Name: "generate_204", URL: "http://connect.rom.miui.com/generate_204"},
+	{Name: "connecttest", URL: "http://www.msftconnecttest.com/connecttest.txt", Body: "Microsoft Connect Test"},
+	{Name: "alidns", Type: ProbeTCP, Address: "223.5.5.5:53"},
+	{Name: "dns", Type: ProbeDNS, Host: "www.baidu.com"},
+}
+
+// Connectivity 是综合所有探测得出的连通性
+type Connectivity int
+
+const (
+	ConnectivityOnline  Connectivity = iota // 至少一个探测成功, 且没有被重定向到门户
+	ConnectivityCaptive                     // 流量被重定向到门户, 需要重新登录
+	ConnectivityOffline                     // 所有探测都失败, 重新登录通常无济于事
+)
+
+func (c Connectivity) String() string {
+	switch c {
+	case ConnectivityOnline:
+		return "online"
+	case ConnectivityCaptive:
+		return "captive"
+	case ConnectivityOffline:
+		return "offline"
+	}
+	return fmt.Sprintf("Connectivity(%d)", int(c))
+}
+
+// ProbeResult 是一个探测的结果
+type ProbeResult struct {
+	Name    string
+	OK      bool
+	Captive bool // 被重定向到门户
+	Latency time.Duration
+	Err     error
+}
+
+// Prober 并行执行配置的探测, 判断外网是否真的可用
+type Prober struct {
+	probes     []config.ProbeConfig
+	timeout    time.Duration
+	client     *http.Client
+	dial       func(ctx context.Context, network, addr string) (net.Conn, error) // socks5 代理时经过代理
+	dialer     *net.Dialer
+	mu         sync.Mutex
+	portalHost string
+}
+
+func NewProber(c *config.Config) (*Prober, error) {
+	probes := c.Connectivity.Probes
+	if len(probes) == 0 {
+		probes = DefaultProbes
+	}
+	for i, p := range probes {
+		switch p.Type {
+		case "", ProbeHTTP:
+			if len(p.URL) == 0 {
+				return nil, fmt.Errorf("probe %d: url is empty", i)
+			}
+		case ProbeTCP:
+			if len(p.Address) == 0 {
+				return nil, fmt.Errorf("probe %d: address is empty", i)
+			}
+		case ProbeDNS:
+			if len(p.Host) == 0 {
+				return nil, fmt.Errorf("probe %d: host is empty", i)
+			}
+		default:
+			return nil, fmt.Errorf("probe %d: unknown type %q", i, p.Type)
+		}
+	}
+
+	transport, err := newTransport(c)
+	if err != nil {
+		return nil, err
+	}
+	dialer, err := newDialer(c)
+	if err != nil {
+		return nil, err
+	}
+	timeout := 5 * time.Second
+	if c.Connectivity.Timeout > 0 {
+		timeout = time.Duration(c.Connectivity.Timeout) * time.Second
+	}
+	return &Prober{
+		probes:  probes,
+		timeout: timeout,
+		client: &http.Client{
+			Transport: transport,
+			// 不跟随跳转, 以便比较跳转地址与门户
+			CheckRedirect: func(req *http.Request, via []*http.Request) error {
+				return http.ErrUseLastResponse
+			},
+		},
+		dial:   transport.DialContext,
+		dialer: dialer,
+	}, nil
+}
+
+// SetPortalHost 更新用于判断重定向的门户地址
+func (p *Prober) SetPortalHost(host string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.portalHost = host
+}
+
+func (p *Prober) portalHostname() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	host := p.portalHost
+	if u, err := url.Parse(host); err == nil && len(u.Host) > 0 {
+		host = u.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	return strings.ToLower(host)
+}
+
+// Check 并行执行所有探测, 每个探测有各自的超时
+func (p *Prober) Check(ctx context.Context) (Connectivity, []ProbeResult) {
+	results := make([]ProbeResult, len(p.probes))
+	var wg sync.WaitGroup
+	for i := range p.probes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			results[i] = p.run(ctx, &p.probes[i])
+		}(i)
+	}
+	wg.Wait()
+
+	connectivity := ConnectivityOffline
+	for _, r := range results {
+		if r.Captive {
+			return ConnectivityCaptive, results
+		}
+		if r.OK {
+			connectivity = ConnectivityOnline
+		}
+	}
+	return connectivity, results
+}
+
+func (p *Prober) run(ctx context.Context, probe *config.ProbeConfig) ProbeResult {
+	timeout := p.timeout
+	if probe.Timeout > 0 {
+		timeout = time.Duration(probe.Timeout) * time.Second
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	res := ProbeResult{Name: probe.Name}
+	if len(res.Name) == 0 {
+		res.Name = probe.Type + " " + probe.URL + probe.Address + probe.Host
+	}
+	start := time.Now()
+	switch probe.Type {
+	case ProbeTCP:
+		var conn net.Conn
+		if conn, res.Err = p.dial(ctx, "tcp", probe.Address); res.Err == nil {
+			conn.Close()
+		}
+	case ProbeDNS:
+		res.Err = p.lookup(ctx, probe)
+	default:
+		res.Captive, res.Err = p.get(ctx, probe)
+	}
+	res.Latency = time.Since(start)
+	res.OK = res.Err == nil
+	return res
+}
+
+func (p *Prober) lookup(ctx context.Context, probe *config.ProbeConfig) error {
+	resolver := &net.Resolver{PreferGo: true}
+	if len(probe.Server) > 0 {
+		resolver.Dial = func(ctx context.Context, network, address string) (net.Conn, error) {
+			return p.dialer.DialContext(ctx, network, probe.Server)
+		}
+	}
+	addrs, err := resolver.LookupHost(ctx, probe.Host)
+	if err != nil {
+		return err
+	}
+	if len(addrs) == 0 {
+		return fmt.Errorf("no address for %s", probe.Host)
+	}
+	return nil
+}
+
+// get 执行 HTTP 探测, 跳转到门户或页面中出现门户地址时返回 captive
+func (p *Prober) get(ctx context.Context, probe *config.ProbeConfig) (captive bool, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", probe.URL, nil)
+	if err != nil {
+		return false, err
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	if err != nil {
+		return false, err
+	}
+
+	portalHost := p.portalHostname()
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		location, err := resp.Location()
+		if err != nil {
+			return false, fmt.Errorf("%s redirect without location", resp.Status)
+		}
+		if len(portalHost) > 0 && strings.EqualFold(location.Hostname(), portalHost) {
+			return true, fmt.Errorf("redirected to portal %s", location)
+		}
+		return false, fmt.Errorf("redirected to %s", location)
+	}
+
+	status := probe.Status
+	if status == 0 {
+		status = http.StatusNoContent
+		if len(probe.Body) > 0 {
+			status = http.StatusOK
+		}
+	}
+	if resp.StatusCode == status && strings.Contains(string(body), probe.Body) {
+		return false, nil
+	}
+	// 门户常以 200 返回 top.self.location.href 等脚本跳转
+	if len(portalHost) > 0 && strings.Contains(strings.ToLower(string(body)), portalHost) {
+		return true, fmt.Errorf("page refers to portal %s", portalHost)
+	}
+	if resp.StatusCode != status {
+		return false, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return false, fmt.Errorf("body does not contain %q", probe.Body)
+}
@@ -0,0 +1,94 @@
+package portal
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"shunet/config"
+	"testing"
+)
+
+const testPortalHost = "http://portal.example:8080"
+
+func newTestProber(t *testing.T, probes ...config.ProbeConfig) *Prober {
+	t.Helper()
+	p, err := NewProber(&config.Config{Connectivity: config.ConnectivityConfig{Enabled: true, Timeout: 2, Probes: probes}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetPortalHost(testPortalHost)
+	return p
+}
+
+func TestProberCheck(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/generate_204", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+	mux.HandleFunc("/connecttest.txt", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Microsoft Connect Test")
+	})
+	mux.HandleFunc("/redirect-portal", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, testPortalHost+"/eportal/index.jsp?wlanuserip=10.0.0.2", http.StatusFound)
+	})
+	mux.HandleFunc("/redirect-other", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://www.example.com/", http.StatusFound)
+	})
+	mux.HandleFunc("/script-portal", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<script>top.self.location.href='http://PORTAL.example:8080/eportal/index.jsp'</script>")
+	})
+	mux.HandleFunc("/unrelated", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "<html>hello</html>")
+	})
+	s := httptest.NewServer(mux)
+	defer s.Close()
+
+	closed := httptest.NewServer(http.NotFoundHandler())
+	closed.Close()
+
+	probe := func(path string) config.ProbeConfig {
+		return config.ProbeConfig{Name: path, URL: s.URL + path}
+	}
+	tests := []struct {
+		name   string
+		probes []config.ProbeConfig
+		want   Connectivity
+	}{
+		{"online 204", []config.ProbeConfig{probe("/generate_204")}, ConnectivityOnline},
+		{"online body", []config.ProbeConfig{{URL: s.URL + "/connecttest.txt", Body: "Microsoft Connect Test"}}, ConnectivityOnline},
+		{"captive 302 to portal", []config.ProbeConfig{probe("/redirect-portal")}, ConnectivityCaptive},
+		{"captive 200 refers to portal", []config.ProbeConfig{probe("/script-portal")}, ConnectivityCaptive},
+		{"captive wins over online", []config.ProbeConfig{probe("/generate_204"), probe("/redirect-portal")}, ConnectivityCaptive},
+		{"redirect elsewhere", []config.ProbeConfig{probe("/redirect-other")}, ConnectivityOffline},
+		{"unexpected page", []config.ProbeConfig{probe("/unrelated")}, ConnectivityOffline},
+		{"offline", []config.ProbeConfig{
+			{Name: "http", URL: closed.URL + "/generate_204"},
+			{Name: "tcp", Type: ProbeTCP, Address: closed.Listener.Addr().String()},
+		}, ConnectivityOffline},
+		{"tcp online", []config.ProbeConfig{{Type: ProbeTCP, Address: s.Listener.Addr().String()}}, ConnectivityOnline},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, results := newTestProber(t, tt.probes...).Check(context.Background())
+			if got != tt.want {
+				t.Errorf("Check = %v, want %v, results %+v", got, tt.want, results)
+			}
+			if len(results) != len(tt.probes) {
+				t.Errorf("%d results for %d probes", len(results), len(tt.probes))
+			}
+		})
+	}
+}
+
+func TestProberWithoutPortalHost(t *testing.T) {
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, testPortalHost+"/", http.StatusFound)
+	}))
+	defer s.Close()
+	p := newTestProber(t, config.ProbeConfig{URL: s.URL})
+	p.SetPortalHost("")
+	if got, _ := p.Check(context.Background()); got != ConnectivityOffline {
+		t.Errorf("Check = %v, want offline when the portal host is unknown", got)
+	}
+}
@@ -0,0 +1,181 @@
+package portal
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"golang.org/x/net/proxy"
+	"net"
+	"net/http"
+	"net/url"
+	"shunet/config"
+	"strings"
+)
+
+// bypass 是不经过代理的主机列表, 写法与 NO_PROXY 相同:
+// * 表示全部; IP 或 CIDR 网段; 域名同时匹配其子域名, 以 . 开头时只匹配子域名
+type bypass struct {
+	all     bool
+	ips     []net.IP
+	nets    []*net.IPNet
+	domains []string
+}
+
+func newBypass(entries []string) (*bypass, error) {
+	b := &bypass{}
+	for _, e := range entries {
+		e = strings.ToLower(strings.TrimSpace(e))
+		switch {
+		case len(e) == 0:
+		case e == "*":
+			b.all = true
+		case strings.Contains(e, "/"):
+			_, ipNet, err := net.ParseCIDR(e)
+			if err != nil {
+				return nil, fmt.Errorf("invalid noProxy entry %q", e)
+			}
+			b.nets = append(b.nets, ipNet)
+		default:
+			if h, _, err := net.SplitHostPort(e); err == nil {
+				e = h
+			}
+			if ip := net.ParseIP(e); ip != nil {
+				b.ips = append(b.ips, ip)
+			} else {
+				b.domains = append(b.domains, e)
+			}
+		}
+	}
+	return b, nil
+}
+
+// match 判断 host(不带端口)是否直连
+func (b *bypass) match(host string) bool {
+	if b.all {
+		return true
+	}
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if ip := net.ParseIP(host); ip != nil {
+		for _, v := range b.ips {
+			if v.Equal(ip) {
+				return true
+			}
+		}
+		for _, n := range b.nets {
+			if n.Contains(ip) {
+				return true
+			}
+		}
+		return false
+	}
+	for _, d := range b.domains {
+		if strings.HasPrefix(d, ".") {
+			if strings.HasSuffix(host, d) {
+				return true
+			}
+		} else if host == d || strings.HasSuffix(host, "."+d) {
+			return true
+		}
+	}
+	return false
+}
+
+// setProxy 按配置为 transport 设置代理. http(s) 代理使用 transport.Proxy;
+// socks5 代理替换 DialContext, socks5 在本地解析域名, socks5h 交给代理解析
+func setProxy(transport *http.Transport, dialer *net.Dialer, c *config.Config) error {
+	b, err := newBypass(c.NoProxy)
+	if err != nil {
+		return err
+	}
+	transport.DialContext = dialer.DialContext
+	if len(c.Proxy) == 0 {
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if b.match(req.URL.Hostname()) {
+				return nil, nil
+			}
+			return http.ProxyFromEnvironment(req)
+		}
+		return nil
+	}
+
+	proxyURL, err := url.Parse(c.Proxy)
+	if err != nil {
+		return fmt.Errorf("invalid proxy: %w", err)
+	}
+	if len(proxyURL.Host) == 0 {
+		return fmt.Errorf("invalid proxy %q: missing host", c.Proxy)
+	}
+	switch proxyURL.Scheme {
+	case "http", "https":
+		transport.Proxy = func(req *http.Request) (*url.URL, error) {
+			if b.match(req.URL.Hostname()) {
+				return nil, nil
+			}
+			return proxyURL, nil
+		}
+	case "socks5", "socks5h":
+		var auth *proxy.Auth
+		if proxyURL.User != nil {
+			password, _ := proxyURL.User.Password()
+			auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+		}
+		d, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, dialer)
+		if err != nil {
+			return err
+		}
+		socks := d.(proxy.ContextDialer)
+		remoteDNS := proxyURL.Scheme == "socks5h"
+		resolver := newResolver(dialer)
+		transport.Proxy = nil
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, port, err := net.SplitHostPort(addr)
+			if err != nil {
+				return nil, err
+			}
+			if b.match(host) {
+				return dialer.DialContext(ctx, network, addr)
+			}
+			if !remoteDNS && net.ParseIP(host) == nil {
+				ip, err := lookupIP(ctx, resolver, host)
+				if err != nil {
+					return nil, err
+				}
+				addr = net.JoinHostPort(ip.String(), port)
+			}
+			return socks.DialContext(ctx, network, addr)
+		}
+	default:
+		return fmt.Errorf("unsupported proxy scheme %q", proxyURL.Scheme)
+	}
+	return nil
+}
+
+// newResolver 返回通过 dialer 访问 DNS 的 Resolver, 本地解析同样经过绑定的网卡与源地址
+func newResolver(dialer *net.Dialer) *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			d := *dialer
+			if a, ok := dialer.LocalAddr.(*net.TCPAddr); ok && strings.HasPrefix(network, "udp") {
+				d.LocalAddr = &net.UDPAddr{IP: a.IP}
+			}
+			return d.DialContext(ctx, network, addr)
+		},
+	}
+}
+
+// lookupIP 解析 host, 与 interfaceAddr 一样优先使用 IPv4 地址
+func lookupIP(ctx context.Context, r *net.Resolver, host string) (net.IP, error) {
+	ips, err := r.LookupIP(ctx, "ip", host)
+	if err != nil {
+		return nil, err
+	}
+	for _, ip := range ips {
+		if ip4 := ip.To4(); ip4 != nil {
+			return ip4, nil
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address for %s", host)
+	}
+	return ips[0], nil
+}
@@ -0,0 +1,180 @@
+package portal
+
+import (
+	"encoding/binary"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"shunet/config"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestBypass(t *testing.T) {
+	b, err := newBypass([]string{"10.10.9.9", "192.168.0.0/16", "example.com", ".internal", " LOCALHOST:8080 ", ""})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"10.10.9.9", true},
+		{"10.10.9.10", false},
+		{"192.168.3.4", true},
+		{"example.com", true},
+		{"www.Example.com.", true},
+		{"notexample.com", false},
+		{"internal", false},
+		{"portal.internal", true},
+		{"localhost", true},
+	}
+	for _, tt := range tests {
+		if got := b.match(tt.host); got != tt.want {
+			t.Errorf("match(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+	if all, _ := newBypass([]string{"*"}); !all.match("anything") {
+		t.Error("* does not match everything")
+	}
+	if _, err = newBypass([]string{"10.0.0.0/33"}); err == nil {
+		t.Error("invalid CIDR accepted")
+	}
+}
+
+// socksServer 是只支持无认证 CONNECT 的 SOCKS5 服务器, 记录客户端请求的目标地址
+type socksServer struct {
+	net.Listener
+	mu    sync.Mutex
+	addrs []string
+}
+
+func newSocksServer(t *testing.T) *socksServer {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socksServer{Listener: ln}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { ln.Close() })
+	return s
+}
+
+func (s *socksServer) serve(conn net.Conn) {
+	defer conn.Close()
+	buf := make([]byte, 262)
+	// 问候: VER NMETHODS METHODS
+	if _, err := io.ReadFull(conn, buf[:2]); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, buf[:buf[1]]); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+	// 请求: VER CMD RSV ATYP DST.ADDR DST.PORT
+	if _, err := io.ReadFull(conn, buf[:4]); err != nil {
+		return
+	}
+	var host string
+	switch buf[3] {
+	case 1:
+		io.ReadFull(conn, buf[:4])
+		host = net.IP(buf[:4]).String()
+	case 3:
+		io.ReadFull(conn, buf[:1])
+		n := int(buf[0])
+		io.ReadFull(conn, buf[:n])
+		host = string(buf[:n])
+	case 4:
+		io.ReadFull(conn, buf[:16])
+		host = net.IP(buf[:16]).String()
+	}
+	io.ReadFull(conn, buf[:2])
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(buf[:2]))))
+	s.mu.Lock()
+	s.addrs = append(s.addrs, addr)
+	s.mu.Unlock()
+
+	target, err := net.Dial("tcp", addr)
+	if err != nil {
+		conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+	go io.Copy(target, conn)
+	io.Copy(conn, target)
+}
+
+func (s *socksServer) requested() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.addrs...)
+}
+
+func TestSocksProxy(t *testing.T) {
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}))
+	defer target.Close()
+	_, port, _ := net.SplitHostPort(target.Listener.Addr().String())
+
+	tests := []struct {
+		scheme, noProxy string
+		want            []string
+	}{
+		// socks5 在本地解析, 优先使用 IPv4; socks5h 把域名交给代理
+		{"socks5", "", []string{"127.0.0.1:" + port}},
+		{"socks5h", "", []string{"localhost:" + port}},
+		{"socks5", "localhost", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme+"/"+tt.noProxy, func(t *testing.T) {
+			s := newSocksServer(t)
+			c := &config.Config{Proxy: tt.scheme + "://" + s.Addr().String()}
+			if len(tt.noProxy) > 0 {
+				c.NoProxy = []string{tt.noProxy}
+			}
+			hc, err := NewHTTPClient(c)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := hc.Get("http://localhost:" + port + "/")
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if got := s.requested(); fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Errorf("proxy requests = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolverUsesSourceAddress(t *testing.T) {
+	dialer, err := newDialer(&config.Config{SourceAddress: "127.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// UDP 不需要对端监听, 只检查 DNS 请求从源地址发出
+	conn, err := newResolver(dialer).Dial(context.Background(), "udp", "127.0.0.1:53")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if ip := conn.LocalAddr().(*net.UDPAddr).IP; !ip.Equal(net.IPv4(127, 0, 0, 1)) {
+		t.Errorf("DNS local address = %v, want 127.0.0.1", ip)
+	}
+}
@@ -0,0 +1,199 @@
+package portal
+
+import (
+	"fmt"
+	"time"
+)
+
+// State 是 Daemon 所处的连接状态
+type State int
+
+const (
+	StateProbing         State = iota // 访问门户, 判断是否已在线
+	StateUnauthenticated              // 未认证, 准备登录所需的信息
+	StateAuthenticating               // 正在登录
+	StateOnline                       // 在线, 定时保活
+	StateBackoff                      // 出错, 等待后重试
+	StateShuttingDown                 // 正在退出
+	StateNeedsAttention               // 相同错误连续出现过多, 停止重试等待人工处理
+	StateLoggedOut                    // 已按要求下线, 等待 Login
+)
+
+var stateNames = map[State]string{
+	StateProbing:         "Probing",
+	StateUnauthenticated: "Unauthenticated",
+	StateAuthenticating:  "Authenticating",
+	StateOnline:          "Online",
+	StateBackoff:         "Backoff",
+	StateShuttingDown:    "ShuttingDown",
+	StateNeedsAttention:  "NeedsAttention",
+	StateLoggedOut:       "LoggedOut",
+}
+
+func (s State) String() string {
+	if name, ok := stateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// Event 是引起状态转换的事件
+type Event int
+
+const (
+	EventStart           Event = iota // 启动
+	EventAlreadyOnline                // 门户显示已在线
+	EventPortalRedirect               // 被重定向到认证页面
+	EventProbeFailed                  // 访问门户失败
+	EventPrepared                     // 登录所需的信息已准备好
+	EventPrepareFailed                // 准备登录信息失败
+	EventLoginSuccess                 // 登录成功
+	EventLoginFailed                  // 登录失败
+	EventKeepAliveOK                  // 保活成功
+	EventKeepAliveFailed              // 保活失败, 立即重新探测
+	EventRetry                        // 等待结束, 重试
+	EventShutdown                     // 收到退出信号
+	EventNeedsAttention               // 相同错误超出重试预算
+	EventResume                       // 人工处理后恢复
+	EventSessionRestored              // 保存的会话保活成功
+	EventCaptive                      // 保活成功但流量被重定向到门户
+	EventLogin                        // 要求立即登录
+	EventLogout                       // 要求下线
+	EventReload                       // 重新读取了配置
+)
+
+var eventNames = map[Event]string{
+	EventStart:           "Start",
+	EventAlreadyOnline:   "AlreadyOnline",
+	EventPortalRedirect:  "PortalRedirect",
+	EventProbeFailed:     "ProbeFailed",
+	EventPrepared:        "Prepared",
+	EventPrepareFailed:   "PrepareFailed",
+	EventLoginSuccess:    "LoginSuccess",
+	EventLoginFailed:     "LoginFailed",
+	EventKeepAliveOK:     "KeepAliveOK",
+	EventKeepAliveFailed: "KeepAliveFailed",
+	EventRetry:           "Retry",
+	EventShutdown:        "Shutdown",
+	EventNeedsAttention:  "NeedsAttention",
+	EventResume:          "Resume",
+	EventSessionRestored: "SessionRestored",
+	EventCaptive:         "Captive",
+	EventLogin:           "Login",
+	EventLogout:          "Logout",
+	EventReload:          "Reload",
+}
+
+func (e Event) String() string {
+	if name, ok := eventNames[e]; ok {
+		return name
+	}
+	return fmt.Sprintf("Event(%d)", int(e))
+}
+
+// transitions 状态转换表, EventShutdown 在任意状态下都转到 StateShuttingDown
+var transitions = map[State]map[Event]State{
+	StateProbing: {
+		EventStart:           StateProbing,
+		EventAlreadyOnline:   StateOnline,
+		EventSessionRestored: StateOnline,
+		EventPortalRedirect:  StateUnauthenticated,
+		EventProbeFailed:     StateBackoff,
+		EventNeedsAttention:  StateNeedsAttention,
+	},
+	StateUnauthenticated: {
+		EventPrepared:       StateAuthenticating,
+		EventPrepareFailed:  StateBackoff,
+		EventNeedsAttention: StateNeedsAttention,
+	},
+	StateAuthenticating: {
+		EventLoginSuccess:   StateOnline,
+		EventLoginFailed:    StateBackoff,
+		EventNeedsAttention: StateNeedsAttention,
+	},
+	StateOnline: {
+		EventKeepAliveOK:     StateOnline,
+		EventKeepAliveFailed: StateProbing,
+		EventCaptive:         StateProbing,
+		EventLogout:          StateLoggedOut,
+		EventReload:          StateProbing,
+	},
+	StateBackoff: {
+		EventRetry:  StateProbing,
+		EventLogin:  StateProbing,
+		EventLogout: StateLoggedOut,
+		EventReload: StateProbing,
+	},
+	StateNeedsAttention: {
+		EventResume: StateProbing,
+		EventLogin:  StateProbing,
+		EventLogout: StateLoggedOut,
+		EventReload: StateProbing,
+	},
+	StateLoggedOut: {
+		EventLogin:  StateProbing,
+		EventLogout: StateLoggedOut,
+	},
+}
+
+// StateInfo 描述当前状态及最近一次转换的原因
+type StateInfo struct {
+	State       State
+	Event       Event     // 最近一次转换的事件
+	Reason      string    // 最近一次转换的原因
+	Since       time.Time // 进入当前状态的时间
+	Session     string    // 在线时门户的会话标识, 门户实现了 SessionIdentifier 时才有
+	LastLogin   time.Time // 最近一次登录成功的时间
+	LastError   string    // 最近一次失败的原因
+	LastErrorAt time.Time
+}
+
+// State 返回当前状态, 可在其他 goroutine 中调用
+func (d *Daemon) State() StateInfo {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+	return d.state
+}
+
+// transition 根据事件转换状态, 非法的转换会被忽略并返回 false
+func (d *Daemon) transition(e Event, reason string) bool {
+	d.stateMu.Lock()
+	defer d.stateMu.Unlock()
+
+	from := d.state.State
+	to, ok := transitions[from][e]
+	if e == EventShutdown {
+		to, ok = StateShuttingDown, true
+	}
+	if !ok {
+		log.Errorf("invalid transition %v on %v: %s", from, e, reason)
+		return false
+	}
+
+	now := time.Now()
+	if to != from || d.state.Since.IsZero() {
+		d.state.Since = now
+	}
+	d.state.State, d.state.Event, d.state.Reason = to, e, reason
+	switch e {
+	case EventLoginSuccess:
+		d.state.LastLogin = now
+	case EventProbeFailed, EventPrepareFailed, EventLoginFailed, EventKeepAliveFailed, EventCaptive, EventNeedsAttention:
+		d.state.LastError, d.state.LastErrorAt = reason, now
+	}
+	if to == StateOnline {
+		if s, ok := d.auth.(SessionIdentifier); ok {
+			d.state.Session = s.SessionID()
+		}
+	} else if !d.online {
+		d.state.Session = ""
+	}
+	log.Debugf("state %v -> %v on %v: %s", from, to, e, reason)
+	for ch := range d.subscribers {
+		select {
+		case ch <- d.state:
+		default:
+		}
+	}
+	return true
+}
@@ -0,0 +1,121 @@
+package portal
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"shunet/config"
+	"strings"
+)
+
+// HostURL 把配置的 host 转为不带结尾斜杠的地址, 未写协议时为 http
+func HostURL(host string) string {
+	if strings.Contains(host, "://") {
+		return strings.TrimSuffix(host, "/")
+	}
+	return "http://" + host
+}
+
+// Fingerprint 返回证书的 SHA-256 指纹, 格式与 openssl x509 -fingerprint -sha256 相同
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+// normalizeFingerprint 去掉冒号和空格并转为小写的十六进制
+func normalizeFingerprint(fingerprint string) (string, error) {
+	s := strings.ToLower(strings.NewReplacer(":", "", " ", "").Replace(strings.TrimPrefix(fingerprint, "sha256:")))
+	b, err := hex.DecodeString(s)
+	if err != nil || len(b) != sha256.Size {
+		return "", fmt.Errorf("invalid sha256 fingerprint %q", fingerprint)
+	}
+	return s, nil
+}
+
+// usesHTTPS 判断配置的门户地址是否为 https, 通用表单门户检查 detect 与各步骤的 url
+func usesHTTPS(c *config.Config) bool {
+	if c.Portal != "form" {
+		return strings.HasPrefix(HostURL(c.Host), "https://")
+	}
+	urls := []string{c.Form.Detect.URL}
+	for _, steps := range [][]config.FormStep{c.Form.Login, c.Form.KeepAlive, c.Form.Logout} {
+		for _, s := range steps {
+			urls = append(urls, s.URL)
+		}
+	}
+	for _, u := range urls {
+		if strings.HasPrefix(strings.ToLower(u), "https://") {
+			return true
+		}
+	}
+	return false
+}
+
+// newTLSConfig 创建访问门户使用的 tls.Config.
+// 配置了指纹时只信任证书链中出现这些指纹的对端, 口令不会发给被替换的证书.
+// https 为门户是否使用 https, 只有使用时才提示不校验证书的风险
+func newTLSConfig(c *config.TLSConfig, https bool) (*tls.Config, error) {
+	tc := &tls.Config{}
+	if len(c.CA) > 0 {
+		pem, err := os.ReadFile(c.CA)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", c.CA)
+		}
+		tc.RootCAs = pool
+	}
+
+	pins := make(map[string]bool, len(c.Fingerprints))
+	for _, f := range c.Fingerprints {
+		pin, err := normalizeFingerprint(f)
+		if err != nil {
+			return nil, err
+		}
+		pins[pin] = true
+	}
+
+	switch {
+	case len(pins) > 0:
+		// 自签名证书无法通过 CA 校验, 改由指纹确认对端
+		tc.InsecureSkipVerify = true
+		tc.VerifyConnection = func(cs tls.ConnectionState) error {
+			for _, cert := range cs.PeerCertificates {
+				sum := sha256.Sum256(cert.Raw)
+				if pins[hex.EncodeToString(sum[:])] {
+					return nil
+				}
+			}
+			if len(cs.PeerCertificates) == 0 {
+				return errors.New("no peer certificate")
+			}
+			return fmt.Errorf("peer certificate is not pinned, got fingerprint %s", Fingerprint(cs.PeerCertificates[0]))
+		}
+	case c.InsecureSkipVerify:
+		if https {
+			log.Error("!!! tls.insecureSkipVerify is enabled: portal certificates are NOT verified, " +
+				"anyone on the network can impersonate the portal and receive the encrypted password !!!")
+		}
+		tc.InsecureSkipVerify = true
+		tc.VerifyConnection = func(cs tls.ConnectionState) error {
+			if len(cs.PeerCertificates) > 0 {
+				log.Warningf("Unverified TLS connection, certificate fingerprint %s", Fingerprint(cs.PeerCertificates[0]))
+			}
+			return nil
+		}
+	}
+	return tc, nil
+}
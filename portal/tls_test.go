@@ -0,0 +1,126 @@
+package portal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/pem"
+	"io"
+	stdlog "log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"shunet/config"
+	"strings"
+	"testing"
+)
+
+func TestNormalizeFingerprint(t *testing.T) {
+	const want = "0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+	tests := []struct {
+		in      string
+		wantErr bool
+	}{
+		{want, false},
+		{strings.ToUpper(want), false},
+		{"sha256:" + want, false},
+		{"01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF:01:23:45:67:89:AB:CD:EF", false},
+		{"01 23 45 67 89 ab cd ef 01 23 45 67 89 ab cd ef 01 23 45 67 89 ab cd ef 01 23 45 67 89 ab cd ef", false},
+		{want[:62], true}, // 长度不是 sha256
+		{"zz" + want[2:], true},
+		{"", true},
+	}
+	for _, tt := range tests {
+		got, err := normalizeFingerprint(tt.in)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("normalizeFingerprint(%q) = %q, want error", tt.in, got)
+			}
+			continue
+		}
+		if err != nil || got != want {
+			t.Errorf("normalizeFingerprint(%q) = %q, %v", tt.in, got, err)
+		}
+	}
+}
+
+func TestUsesHTTPS(t *testing.T) {
+	tests := []struct {
+		c    config.Config
+		want bool
+	}{
+		{config.Config{Host: "10.10.9.9"}, false},
+		{config.Config{Host: "https://10.10.9.9"}, true},
+		{config.Config{Portal: "form", Form: config.FormConfig{Detect: config.FormDetect{URL: "http://10.0.0.1/"}}}, false},
+		{config.Config{Portal: "form", Form: config.FormConfig{
+			Detect: config.FormDetect{URL: "http://10.0.0.1/"},
+			Login:  []config.FormStep{{URL: "HTTPS://10.0.0.1/login"}},
+		}}, true},
+	}
+	for _, tt := range tests {
+		if got := usesHTTPS(&tt.c); got != tt.want {
+			t.Errorf("usesHTTPS(%+v) = %v, want %v", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	s := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	// 握手失败是预期的, 不输出服务端日志
+	s.Config.ErrorLog = stdlog.New(io.Discard, "", 0)
+	s.StartTLS()
+	defer s.Close()
+	cert := s.Certificate()
+	sum := sha256.Sum256(cert.Raw)
+	ca := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(ca, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Raw}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	other := strings.Repeat("ab", sha256.Size)
+
+	tests := []struct {
+		name    string
+		tls     config.TLSConfig
+		wantErr string
+	}{
+		{"system CA", config.TLSConfig{}, "certificate"},
+		{"custom CA", config.TLSConfig{CA: ca}, ""},
+		{"pin", config.TLSConfig{Fingerprints: []string{Fingerprint(cert)}}, ""},
+		{"pin hex", config.TLSConfig{Fingerprints: []string{other, hex.EncodeToString(sum[:])}}, ""},
+		{"pin mismatch", config.TLSConfig{Fingerprints: []string{other}}, "not pinned"},
+		// 配置了指纹时不因 insecureSkipVerify 放过其他证书
+		{"pin mismatch insecure", config.TLSConfig{Fingerprints: []string{other}, InsecureSkipVerify: true}, "not pinned"},
+		{"insecure", config.TLSConfig{InsecureSkipVerify: true}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hc, err := NewHTTPClient(&config.Config{Host: s.URL, TLS: tt.tls})
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp, err := hc.Get(s.URL)
+			if err == nil {
+				resp.Body.Close()
+			}
+			if len(tt.wantErr) == 0 && err != nil {
+				t.Errorf("Get: %v", err)
+			}
+			if len(tt.wantErr) > 0 && (err == nil || !strings.Contains(err.Error(), tt.wantErr)) {
+				t.Errorf("Get err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := newTLSConfig(&config.TLSConfig{Fingerprints: []string{"abc"}}, true); err == nil {
+		t.Error("invalid fingerprint accepted")
+	}
+	if _, err := newTLSConfig(&config.TLSConfig{CA: filepath.Join(t.TempDir(), "missing.pem")}, true); err == nil {
+		t.Error("missing CA file accepted")
+	}
+	if err := os.WriteFile(ca, []byte("not a certificate"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newTLSConfig(&config.TLSConfig{CA: ca}, true); err == nil {
+		t.Error("CA file without certificates accepted")
+	}
+}
@@ -0,0 +1,104 @@
+package portal
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"shunet/config"
+	"time"
+)
+
+// NewHTTPClient 创建各门户共用的 http.Client, 带 cookie jar, https 门户按 tls 配置校验证书
+func NewHTTPClient(c *config.Config) (*http.Client, error) {
+	transport, err := newTransport(c)
+	if err != nil {
+		return nil, err
+	}
+	if transport.TLSClientConfig, err = newTLSConfig(&c.TLS, usesHTTPS(c)); err != nil {
+		return nil, err
+	}
+	hc := &http.Client{Transport: transport, Timeout: seconds(c.Timeout.Request, 30)}
+	if jar, err := cookiejar.New(nil); err == nil {
+		hc.Jar = jar
+	}
+	return hc, nil
+}
+
+// newTransport 根据配置创建 http.Transport, 可指定代理以及出口网卡或源地址
+func newTransport(c *config.Config) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSHandshakeTimeout = seconds(c.Timeout.TLS, 10)
+	dialer, err := newDialer(c)
+	if err != nil {
+		return nil, err
+	}
+	if err = setProxy(transport, dialer, c); err != nil {
+		return nil, err
+	}
+	return transport, nil
+}
+
+// newDialer 多网卡时让跳转、pageInfo、login 等请求都从指定的网卡发出,
+// 否则门户看到的是默认路由那块网卡的 IP 和 MAC
+func newDialer(c *config.Config) (*net.Dialer, error) {
+	dialer := &net.Dialer{
+		Timeout:   seconds(c.Timeout.Connect, 10),
+		KeepAlive: 30 * time.Second,
+	}
+	if len(c.SourceAddress) > 0 {
+		ip := net.ParseIP(c.SourceAddress)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid sourceAddress %q", c.SourceAddress)
+		}
+		dialer.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	if len(c.Interface) > 0 {
+		if dialer.LocalAddr == nil {
+			ip, err := interfaceAddr(c.Interface)
+			if err != nil {
+				return nil, err
+			}
+			dialer.LocalAddr = &net.TCPAddr{IP: ip}
+		}
+		bindToDevice(dialer, c.Interface)
+	}
+	return dialer, nil
+}
+
+// interfaceAddr 返回网卡的地址, 优先使用 IPv4
+func interfaceAddr(name string) (net.IP, error) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return nil, err
+	}
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, err
+	}
+	var ipv6 net.IP
+	for _, addr := range addrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok || ipNet.IP.IsLinkLocalUnicast() {
+			continue
+		}
+		if ip4 := ipNet.IP.To4(); ip4 != nil {
+			return ip4, nil
+		}
+		if ipv6 == nil {
+			ipv6 = ipNet.IP
+		}
+	}
+	if ipv6 != nil {
+		return ipv6, nil
+	}
+	return nil, fmt.Errorf("interface %s has no usable address", name)
+}
+
+// seconds 把以秒为单位的配置转为 time.Duration, 未配置时使用默认值
+func seconds(n, def int) time.Duration {
+	if n <= 0 {
+		n = def
+	}
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,81 @@
+package portal
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"shunet/config"
+	"testing"
+)
+
+// loopback 返回回环网卡的名称
+func loopback(t *testing.T) string {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagLoopback != 0 && iface.Flags&net.FlagUp != 0 {
+			return iface.Name
+		}
+	}
+	t.Skip("no loopback interface")
+	return ""
+}
+
+func TestNewDialer(t *testing.T) {
+	lo := loopback(t)
+	tests := []struct {
+		c       config.Config
+		want    string // LocalAddr 的 IP, 空表示不绑定
+		wantErr bool
+	}{
+		{config.Config{}, "", false},
+		{config.Config{SourceAddress: "127.0.0.1"}, "127.0.0.1", false},
+		{config.Config{SourceAddress: "::1"}, "::1", false},
+		{config.Config{SourceAddress: "10.0.0"}, "", true},
+		{config.Config{Interface: lo}, "127.0.0.1", false},
+		// 同时配置时以 sourceAddress 为准
+		{config.Config{Interface: lo, SourceAddress: "127.0.0.2"}, "127.0.0.2", false},
+		{config.Config{Interface: "no-such-interface"}, "", true},
+	}
+	for _, tt := range tests {
+		d, err := newDialer(&tt.c)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("newDialer(%+v) err = %v", tt.c, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		got := ""
+		if d.LocalAddr != nil {
+			got = d.LocalAddr.(*net.TCPAddr).IP.String()
+		}
+		if got != tt.want {
+			t.Errorf("newDialer(%+v) LocalAddr = %q, want %q", tt.c, got, tt.want)
+		}
+	}
+}
+
+func TestSourceAddress(t *testing.T) {
+	remote := make(chan string, 1)
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, _ := net.SplitHostPort(r.RemoteAddr)
+		remote <- host
+	}))
+	defer s.Close()
+
+	hc, err := NewHTTPClient(&config.Config{SourceAddress: "127.0.0.2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := hc.Get(s.URL)
+	if err != nil {
+		t.Skipf("127.0.0.2 is not usable: %v", err)
+	}
+	resp.Body.Close()
+	if got := <-remote; got != "127.0.0.2" {
+		t.Errorf("portal saw %s, want 127.0.0.2", got)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"shunet/config"
+	"shunet/shuclient"
+	"strings"
+)
+
+// runServices 列出门户可选的服务, 当前配置的服务以 * 标出
+func runServices(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("services", flag.ExitOnError)
+	fs.BoolVar(jsonOutput, "json", *jsonOutput, "print services as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(cfg.Portal) > 0 && cfg.Portal != "ruijie" {
+		return fmt.Errorf("portal %q does not provide a service list", cfg.Portal)
+	}
+
+	client, err := shuclient.NewClient(cfg)
+	if err != nil {
+		return err
+	}
+	if _, err := client.EnterLoginPageContext(ctx); err != nil {
+		return fmt.Errorf("EnterLoginPage: %w", err)
+	}
+	services, err := client.GetServicesContext(ctx)
+	if err != nil {
+		return fmt.Errorf("GetServices: %w", err)
+	}
+
+	lines := make([]string, 0, len(services))
+	for _, s := range services {
+		mark := " "
+		if s == cfg.Service {
+			mark = "*"
+		}
+		lines = append(lines, mark+" "+s)
+	}
+	return printResult(services, strings.Join(lines, "\n"))
+}
@@ -0,0 +1,16 @@
+package main
+
+import (
+	"shunet/config"
+	"strings"
+	"testing"
+)
+
+func TestServicesOtherPortal(t *testing.T) {
+	for _, p := range []string{"srun", "drcom", "form"} {
+		err := runServices(&config.Config{Portal: p}, nil)
+		if err == nil || !strings.Contains(err.Error(), "does not provide a service list") {
+			t.Errorf("portal %s: err = %v", p, err)
+		}
+	}
+}
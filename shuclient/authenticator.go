@@ -0,0 +1,280 @@
+package shuclient
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"shunet/portal"
+	"time"
+)
+
+// ePortal 把 Client 适配为 portal.Authenticator
+type ePortal struct {
+	c *Client
+}
+
+// Authenticator 返回锐捷 ePortal 的 portal.Authenticator 实现
+func (c *Client) Authenticator() portal.Authenticator {
+	return &ePortal{c: c}
+}
+
+// State 返回驱动该 Client 的 Daemon 的当前状态与最近一次转换的原因, 未交给 Daemon 运行时返回零值
+func (c *Client) State() portal.StateInfo {
+	if d := c.daemon.Load(); d != nil {
+		return d.State()
+	}
+	return portal.StateInfo{}
+}
+
+func (e *ePortal) Detect(ctx context.Context) (bool, error) {
+	c := e.c
+	c.IsLogin = false
+	if c.cfg.Discover.Enabled {
+		c.discover(ctx)
+	}
+	if _, err := c.EnterLoginPageContext(ctx); err != nil {
+		return false, err
+	}
+	log.Info("EnterLoginPage")
+	if c.IsLogin {
+		// 没有经过 Login, 从在线信息中获取心跳间隔
+		if info, err := c.GetOnlineUserInfoContext(ctx, c.userIndex); err != nil {
+			log.Warningf("GetOnlineUserInfo err: %+v", err)
+		} else if info.Result == "success" {
+			c.keepAliveInterval = time.Duration(info.KeepAliveInterval) * time.Second
+		}
+	}
+	return c.IsLogin, nil
+}
+
+func (e *ePortal) PortalHost() string {
+	return e.c.hostUrl
+}
+
+func (e *ePortal) AttachDaemon(d *portal.Daemon) {
+	e.c.daemon.Store(d)
+}
+
+func (e *ePortal) SessionID() string {
+	return e.c.userIndex
+}
+
+func (e *ePortal) Prepare(ctx context.Context) error {
+	if _, err := e.c.GetPageInfoContext(ctx); err != nil {
+		return err
+	}
+	log.Info("GetPageInfo")
+	return nil
+}
+
+func (e *ePortal) Login(ctx context.Context) error {
+	c := e.c
+	// 等待人工输入验证码期间不提交登录, 以免门户作废已下发的验证码
+	if err := c.answerCaptcha(); err != nil {
+		return err
+	}
+	resp, err := c.LoginContext(ctx)
+	if err != nil {
+		return err
+	}
+	if NeedCaptcha(resp) {
+		log.Warningf("Login need captcha: %s", resp.Message)
+		if err = c.SolveCaptcha(ctx, resp); err != nil {
+			return err
+		}
+		if resp, err = c.LoginContext(ctx); err != nil {
+			return err
+		}
+	}
+	return resp.Err()
+}
+
+func (e *ePortal) KeepAlive(ctx context.Context) error {
+	resp, err := e.c.KeepAliveContext(ctx)
+	if err != nil {
+		return err
+	}
+	if err = resp.Err(); err != nil {
+		e.c.IsLogin = false
+		return err
+	}
+	return nil
+}
+
+func (e *ePortal) Logout(ctx context.Context) error {
+	resp, err := e.c.LogOutContext(ctx)
+	if err != nil {
+		return err
+	}
+	return resp.Err()
+}
+
+func (e *ePortal) Status(ctx context.Context) (*portal.Status, error) {
+	c := e.c
+	if len(c.userIndex) == 0 {
+		if _, err := c.EnterLoginPageContext(ctx); err != nil {
+			return nil, err
+		}
+	}
+	if len(c.userIndex) == 0 {
+		return &portal.Status{Online: false}, nil
+	}
+	info, err := c.GetOnlineUserInfoContext(ctx, c.userIndex)
+	if err != nil {
+		return nil, err
+	}
+	if info.Result != "success" {
+		return &portal.Status{Online: false}, nil
+	}
+
+	status := &portal.Status{
+		Online:   true,
+		UserId:   info.UserId,
+		UserName: info.UserName,
+		IP:       info.UserIp,
+		MAC:      info.UserMac,
+		Service:  info.Service,
+		Balance:  info.AccountFee,
+		Session:  info.UserIndex,
+	}
+	balls, err := info.Balls()
+	if err != nil {
+		log.Warningf("parse ballInfo err: %+v", err)
+	}
+	for _, b := range balls {
+		status.Items = append(status.Items, portal.Item{Name: b.DisplayName, Value: b.Value})
+	}
+	return status, nil
+}
+
+func (e *ePortal) Devices(ctx context.Context) ([]portal.Device, error) {
+	list, err := e.c.GetOnlineDevices(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = list.Err(); err != nil {
+		return nil, err
+	}
+	devices := make([]portal.Device, 0, len(list.UserList))
+	for _, u := range list.UserList {
+		d := portal.Device{Session: u.UserIndex, IP: u.UserIp, MAC: u.UserMac}
+		if t, err := time.ParseInLocation(LoginTimeLayout, u.LoginTime, time.Local); err == nil {
+			d.LoginTime = t
+		}
+		devices = append(devices, d)
+	}
+	return devices, nil
+}
+
+func (e *ePortal) Kick(ctx context.Context, d portal.Device) error {
+	resp, err := e.c.LogoutDevice(ctx, d.Session)
+	if err != nil {
+		return err
+	}
+	return resp.Err()
+}
+
+// ensureOnline 绑定 MAC 需要一个在线会话, 离线时先登录
+func (e *ePortal) ensureOnline(ctx context.Context) error {
+	online, err := e.Detect(ctx)
+	if err != nil || online {
+		return err
+	}
+	if err = e.Prepare(ctx); err != nil {
+		return err
+	}
+	if err = e.Login(ctx); err != nil {
+		return err
+	}
+	log.Info("Login success")
+	return nil
+}
+
+// currentMac 未指定 MAC 时使用本机会话的 MAC
+func (e *ePortal) currentMac(ctx context.Context, mac string) (string, error) {
+	if len(mac) > 0 {
+		return mac, nil
+	}
+	c := e.c
+	if info, err := c.GetOnlineUserInfoContext(ctx, c.userIndex); err == nil && len(info.UserMac) > 0 {
+		return info.UserMac, nil
+	}
+	if len(c.cfg.Mac) > 0 {
+		return c.cfg.Mac, nil
+	}
+	return "", fmt.Errorf("mac of this device is unknown, specify it explicitly")
+}
+
+func (e *ePortal) BindMac(ctx context.Context, mac string) error {
+	if err := e.ensureOnline(ctx); err != nil {
+		return err
+	}
+	mac, err := e.currentMac(ctx, mac)
+	if err != nil {
+		return err
+	}
+	resp, err := e.c.RegisterMac(ctx, mac)
+	if err != nil {
+		return err
+	}
+	return resp.Err()
+}
+
+func (e *ePortal) UnbindMac(ctx context.Context, mac string) error {
+	if err := e.ensureOnline(ctx); err != nil {
+		return err
+	}
+	mac, err := e.currentMac(ctx, mac)
+	if err != nil {
+		return err
+	}
+	resp, err := e.c.CancelMac(ctx, mac)
+	if err != nil {
+		return err
+	}
+	return resp.Err()
+}
+
+func (e *ePortal) BoundMacs(ctx context.Context) ([]string, error) {
+	if err := e.ensureOnline(ctx); err != nil {
+		return nil, err
+	}
+	bindings, err := e.c.GetMacBindings(ctx)
+	if err != nil {
+		return nil, err
+	}
+	macs := make([]string, 0, len(bindings))
+	for _, b := range bindings {
+		macs = append(macs, b.Mac)
+	}
+	return macs, nil
+}
+
+func (e *ePortal) KeepAliveInterval() time.Duration {
+	return e.c.keepAliveInterval
+}
+
+func (e *ePortal) RestoreSession(ctx context.Context) (bool, error) {
+	c := e.c
+	ok, err := c.LoadSession()
+	if err != nil || !ok {
+		return false, err
+	}
+	resp, err := c.KeepAliveContext(ctx)
+	if err == nil {
+		err = resp.Err()
+	}
+	if err != nil {
+		c.userIndex = ""
+		return false, err
+	}
+	c.IsLogin = true
+	return true, nil
+}
+
+func (e *ePortal) SaveSession() error {
+	return e.c.SaveSession()
+}
+
+func (e *ePortal) ClearSession() error {
+	return e.c.ClearSession()
+}
@@ -0,0 +1,149 @@
+package shuclient
+
+import (
+	"errors"
+	"golang.org/x/net/context"
+	"net"
+	"shunet/portal"
+	"shunet/shuclient/portaltest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestAuthenticator(t *testing.T, s *portaltest.Server, password string) portal.Authenticator {
+	t.Helper()
+	c, err := NewClient(s.Config(t, password, ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Authenticator()
+}
+
+func TestLoginLogout(t *testing.T) {
+	styles := []string{portaltest.RedirectScript, portaltest.RedirectMeta, portaltest.RedirectReplace, portaltest.RedirectStatus}
+	for _, style := range styles {
+		name := style
+		if len(name) == 0 {
+			name = "script"
+		}
+		t.Run(name, func(t *testing.T) {
+			s := portaltest.NewServer("20120001", "secret")
+			defer s.Close()
+			s.RedirectStyle = style
+			auth := newTestAuthenticator(t, s, "secret")
+			ctx := context.Background()
+
+			online, err := auth.Detect(ctx)
+			if err != nil || online {
+				t.Fatalf("Detect = %v, %v, want offline", online, err)
+			}
+			if err = auth.(portal.Preparer).Prepare(ctx); err != nil {
+				t.Fatalf("Prepare: %v", err)
+			}
+			if err = auth.Login(ctx); err != nil {
+				t.Fatalf("Login: %v", err)
+			}
+			sessions := s.Sessions()
+			if len(sessions) != 1 || sessions[0].Mac != s.Mac {
+				t.Fatalf("sessions = %+v, want one session with mac %s", sessions, s.Mac)
+			}
+			if id := auth.(portal.SessionIdentifier).SessionID(); id != sessions[0].UserIndex {
+				t.Errorf("SessionID = %q, want %q", id, sessions[0].UserIndex)
+			}
+			if err = auth.KeepAlive(ctx); err != nil {
+				t.Fatalf("KeepAlive: %v", err)
+			}
+			status, err := auth.Status(ctx)
+			if err != nil {
+				t.Fatalf("Status: %v", err)
+			}
+			if !status.Online || status.UserId != s.UserId || status.Balance != s.AccountFee {
+				t.Errorf("Status = %+v", status)
+			}
+			if online, err = auth.Detect(ctx); err != nil || !online {
+				t.Errorf("Detect after login = %v, %v, want online", online, err)
+			}
+
+			if err = auth.Logout(ctx); err != nil {
+				t.Fatalf("Logout: %v", err)
+			}
+			if n := len(s.Sessions()); n != 0 {
+				t.Errorf("%d sessions after logout", n)
+			}
+		})
+	}
+}
+
+func TestWrongPassword(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	auth := newTestAuthenticator(t, s, "wrong")
+	ctx := context.Background()
+
+	if _, err := auth.Detect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := auth.(portal.Preparer).Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	err := auth.Login(ctx)
+	if !errors.Is(err, portal.ErrWrongPassword) {
+		t.Fatalf("Login err = %v, want ErrWrongPassword", err)
+	}
+	if n := len(s.Sessions()); n != 0 {
+		t.Errorf("%d sessions after a failed login", n)
+	}
+}
+
+func TestClientState(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	cfg := s.Config(t, "wrong", "")
+	c, err := NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info := c.State(); !info.Since.IsZero() {
+		t.Errorf("State before running = %+v, want zero", info)
+	}
+	d := portaltest.StartDaemon(t, c.Authenticator(), cfg)
+	d.WaitFor(t, portal.StateNeedsAttention)
+	info := c.State()
+	if info.State != portal.StateNeedsAttention || !strings.Contains(info.Reason, portal.ErrWrongPassword.Error()) {
+		t.Errorf("State = %+v, want NeedsAttention with the wrong password reason", info)
+	}
+}
+
+func TestTimeouts(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	// 门户接受连接后不响应
+	s.Inject(portaltest.EndpointRoot, portaltest.Failure{Delay: 10 * time.Second})
+	c, err := NewClient(s.Config(t, "secret", "timeout: { request: 1 }\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := c.Authenticator()
+
+	start := time.Now()
+	_, err = auth.Detect(context.Background())
+	var ne net.Error
+	if !errors.As(err, &ne) || !ne.Timeout() {
+		t.Errorf("Detect err = %v, want a timeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Detect took %v with a 1s request timeout", elapsed)
+	}
+
+	// 取消 ctx 立即中断请求
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start = time.Now()
+	if _, err = auth.Detect(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Detect err = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Detect took %v after the ctx deadline", elapsed)
+	}
+}
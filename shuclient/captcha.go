@@ -0,0 +1,348 @@
+package shuclient
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"shunet/config"
+	"shunet/portal"
+	"shunet/utils"
+	"strings"
+	"sync"
+)
+
+const defaultValidCodePath = "/eportal/validcode"
+
+// ErrCaptchaPending 表示验证码需要人工处理, 暂不登录
+var ErrCaptchaPending = portal.ErrCaptchaPending
+
+// CaptchaSolver 识别验证码图片, 返回验证码文本
+type CaptchaSolver interface {
+	Solve(ctx context.Context, image []byte) (string, error)
+}
+
+// NewCaptchaSolver 根据配置创建验证码识别器, 未配置时返回 nil
+func NewCaptchaSolver(c config.CaptchaConfig) (CaptchaSolver, error) {
+	switch c.Solver {
+	case "":
+		return nil, nil
+	case "prompt":
+		return &PromptSolver{In: os.Stdin, Out: os.Stdout}, nil
+	case "command":
+		if len(c.Command) == 0 {
+			return nil, fmt.Errorf("captcha command is empty")
+		}
+		return &CommandSolver{Command: c.Command}, nil
+	case "pause":
+		return &PauseSolver{Dir: c.Dir, Notify: c.Notify}, nil
+	default:
+		return nil, fmt.Errorf("unknown captcha solver %q", c.Solver)
+	}
+}
+
+// PromptSolver 将图片保存为临时文件, 由用户在终端输入验证码
+type PromptSolver struct {
+	In  io.Reader
+	Out io.Writer
+}
+
+func (p *PromptSolver) Solve(ctx context.Context, image []byte) (string, error) {
+	file, err := os.CreateTemp("", "shunet-captcha-*.jpg")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(file.Name())
+	if _, err = file.Write(image); err != nil {
+		file.Close()
+		return "", err
+	}
+	file.Close()
+
+	in := lineReaderOf(p.In)
+	in.discard()
+	fmt.Fprintf(p.Out, "Captcha required, open %s and enter the code: ", file.Name())
+	select {
+	case <-ctx.Done():
+		return "", ctx.Err()
+	case s := <-in.lines:
+		code := strings.TrimSpace(s)
+		if len(code) == 0 {
+			return "", fmt.Errorf("empty captcha code")
+		}
+		return code, nil
+	case <-in.done:
+		return "", fmt.Errorf("read captcha code: %w", in.err)
+	}
+}
+
+// lineReader 在唯一的 goroutine 中按行读取输入, 同一个输入的所有 PromptSolver 共用,
+// 取消的 Solve 不会留下阻塞在读取上的 goroutine, 也不会因各自缓冲而丢失输入
+type lineReader struct {
+	lines chan string
+	done  chan struct{} // 读取出错(如 EOF)后关闭, err 为出错原因
+	err   error
+}
+
+var (
+	lineReadersMu sync.Mutex
+	lineReaders   = make(map[io.Reader]*lineReader)
+)
+
+func lineReaderOf(in io.Reader) *lineReader {
+	lineReadersMu.Lock()
+	defer lineReadersMu.Unlock()
+	if r, ok := lineReaders[in]; ok {
+		return r
+	}
+	r := &lineReader{lines: make(chan string), done: make(chan struct{})}
+	lineReaders[in] = r
+	go r.run(bufio.NewReader(in))
+	return r
+}
+
+func (r *lineReader) run(in *bufio.Reader) {
+	for {
+		s, err := in.ReadString('\n')
+		if len(s) > 0 {
+			r.lines <- s
+		}
+		if err != nil {
+			r.err = err
+			close(r.done)
+			return
+		}
+	}
+}
+
+// discard 丢弃提示之前输入的行, 如上一次 Solve 取消后才输入的验证码
+func (r *lineReader) discard() {
+	for {
+		select {
+		case <-r.lines:
+		default:
+			return
+		}
+	}
+}
+
+// CommandSolver 执行外部命令, 图片从 stdin 传入, 命令在 stdout 打印验证码
+type CommandSolver struct {
+	Command string
+}
+
+func (c *CommandSolver) Solve(ctx context.Context, image []byte) (string, error) {
+	cmd := shellCommand(ctx, c.Command)
+	cmd.Stdin = bytes.NewReader(image)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("captcha command: %w", err)
+	}
+	code := strings.TrimSpace(string(out))
+	if len(code) == 0 {
+		return "", fmt.Errorf("captcha command printed nothing")
+	}
+	return code, nil
+}
+
+// PendingSolver 是由人工异步应答的 CaptchaSolver: Solve 保存图片后返回 ErrCaptchaPending,
+// 之后的登录先通过 Answer 取得应答
+type PendingSolver interface {
+	CaptchaSolver
+	// Answer 返回已写入的验证码; 没有等待中的验证码时返回空, 仍在等待应答时返回 ErrCaptchaPending
+	Answer() (string, error)
+}
+
+// PauseSolver 用于无人值守的主机: 在私有目录中保存图片并执行通知命令, 然后暂停登录,
+// 直到有人把验证码写入应答文件(图片路径加 .txt)
+type PauseSolver struct {
+	Dir    string // 在该目录下创建保存图片的私有目录, 默认系统临时目录
+	Notify string // 通知命令, 可通过环境变量 SHUNET_CAPTCHA_IMAGE, SHUNET_CAPTCHA_ANSWER 获取路径
+
+	mu      sync.Mutex
+	pending string // 等待应答的私有目录, 为空表示没有等待中的验证码
+}
+
+const (
+	captchaImage  = "captcha.jpg"
+	captchaAnswer = captchaImage + ".txt"
+)
+
+// Solve 不等待应答, 保存图片并通知后立即返回 ErrCaptchaPending, 由 Daemon 稍后重试登录时取得应答
+func (p *PauseSolver) Solve(ctx context.Context, image []byte) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.clear()
+
+	// MkdirTemp 创建的目录权限为 0700, 其他用户无法预先放置链接或写入应答
+	dir, err := os.MkdirTemp(p.Dir, "shunet-captcha-")
+	if err != nil {
+		return "", err
+	}
+	imagePath := filepath.Join(dir, captchaImage)
+	answerPath := filepath.Join(dir, captchaAnswer)
+	file, err := os.OpenFile(imagePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o600)
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	_, err = file.Write(image)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.RemoveAll(dir)
+		return "", err
+	}
+	p.pending = dir
+
+	log.Errorf("Captcha required, write the code of %s into %s", imagePath, answerPath)
+	if len(p.Notify) > 0 {
+		cmd := shellCommand(ctx, p.Notify)
+		cmd.Env = append(os.Environ(), "SHUNET_CAPTCHA_IMAGE="+imagePath, "SHUNET_CAPTCHA_ANSWER="+answerPath)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			log.Errorf("captcha notify err: %+v, output: %s", err, out)
+		}
+	}
+	return "", ErrCaptchaPending
+}
+
+func (p *PauseSolver) Answer() (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.pending) == 0 {
+		return "", nil
+	}
+	answerPath := filepath.Join(p.pending, captchaAnswer)
+	fi, err := os.Lstat(answerPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", ErrCaptchaPending
+	}
+	if err != nil {
+		return "", err
+	}
+	if !fi.Mode().IsRegular() || !ownedBySelf(fi) {
+		_ = os.Remove(answerPath)
+		return "", fmt.Errorf("captcha answer %s is not a regular file owned by the current user", answerPath)
+	}
+	b, err := os.ReadFile(answerPath)
+	if err != nil {
+		return "", err
+	}
+	code := strings.TrimSpace(string(b))
+	if len(code) == 0 {
+		// 可能还没写完, 下次再读
+		return "", ErrCaptchaPending
+	}
+	p.clear()
+	return code, nil
+}
+
+// clear 删除等待应答的私有目录
+func (p *PauseSolver) clear() {
+	if len(p.pending) > 0 {
+		_ = os.RemoveAll(p.pending)
+		p.pending = ""
+	}
+}
+
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", command)
+}
+
+// NeedCaptcha 判断登录失败是否因为需要验证码
+func NeedCaptcha(resp *LoginResponse) bool {
+	if resp == nil || resp.Result == "success" {
+		return false
+	}
+	return len(resp.ValidCodeURL) > 0 || errors.Is(resp.Err(), ErrCaptchaRequired)
+}
+
+// SetCaptchaSolver 设置验证码识别器, 为 nil 时遇到验证码直接视为登录失败
+func (c *Client) SetCaptchaSolver(s CaptchaSolver) {
+	c.captcha = s
+}
+
+// FetchCaptcha 使用同一个 cookie jar 下载验证码图片
+func (c *Client) FetchCaptcha(validCodeURL string) ([]byte, error) {
+	return c.FetchCaptchaContext(context.Background(), validCodeURL)
+}
+
+func (c *Client) FetchCaptchaContext(ctx context.Context, validCodeURL string) ([]byte, error) {
+	if len(validCodeURL) == 0 {
+		validCodeURL = defaultValidCodePath
+	}
+	base, err := url.Parse(c.hostUrl + "/")
+	if err != nil {
+		return nil, err
+	}
+	ref, err := url.Parse(validCodeURL)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", base.ResolveReference(ref).String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req = setReqHeader(c.header, req)
+	req.Header.Set("Referer", c.referer)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetch captcha: %s", resp.Status)
+	}
+	image, err := utils.DecodeContent(resp)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(image), nil
+}
+
+// answerCaptcha 把 PendingSolver 的应答作为下一次登录提交的验证码, 仍在等待应答时返回 ErrCaptchaPending
+func (c *Client) answerCaptcha() error {
+	s, ok := c.captcha.(PendingSolver)
+	if !ok {
+		return nil
+	}
+	code, err := s.Answer()
+	if err != nil {
+		return err
+	}
+	if len(code) > 0 {
+		c.validCode = code
+	}
+	return nil
+}
+
+// SolveCaptcha 下载并识别验证码, 结果在下一次 Login 时提交
+func (c *Client) SolveCaptcha(ctx context.Context, resp *LoginResponse) error {
+	if c.captcha == nil {
+		return fmt.Errorf("captcha required but no solver configured")
+	}
+	image, err := c.FetchCaptchaContext(ctx, resp.ValidCodeURL)
+	if err != nil {
+		return err
+	}
+	code, err := c.captcha.Solve(ctx, image)
+	if err != nil {
+		return err
+	}
+	c.validCode = code
+	return nil
+}
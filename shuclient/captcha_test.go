@@ -0,0 +1,142 @@
+package shuclient
+
+import (
+	"bytes"
+	"errors"
+	"golang.org/x/net/context"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"shunet/portal"
+	"shunet/shuclient/portaltest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPromptSolver(t *testing.T) {
+	in, w := io.Pipe()
+	defer w.Close()
+	var out bytes.Buffer
+	p := &PromptSolver{In: in, Out: &out}
+
+	// 取消的 Solve 不应吞掉之后输入的验证码
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := p.Solve(ctx, []byte("image")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Solve err = %v, want deadline exceeded", err)
+	}
+	go w.Write([]byte(" 1234 \n"))
+	code, err := p.Solve(context.Background(), []byte("image"))
+	if err != nil || code != "1234" {
+		t.Errorf("Solve = %q, %v, want 1234", code, err)
+	}
+	if !strings.Contains(out.String(), "enter the code") {
+		t.Errorf("prompt = %q", out.String())
+	}
+
+	w.Close()
+	if _, err = p.Solve(context.Background(), []byte("image")); err == nil {
+		t.Error("Solve succeeded after the input is closed")
+	}
+}
+
+func TestCommandSolver(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("commands use sh")
+	}
+	tests := []struct {
+		command string
+		want    string
+		wantErr bool
+	}{
+		{"tr a-z A-Z", "ABCD", false},
+		{"cat >/dev/null; echo", "", true},
+		{"exit 1", "", true},
+	}
+	for _, tt := range tests {
+		code, err := (&CommandSolver{Command: tt.command}).Solve(context.Background(), []byte("abcd\n"))
+		if code != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("%q: Solve = %q, %v, want %q", tt.command, code, err, tt.want)
+		}
+	}
+}
+
+func TestPauseSolver(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	s.CaptchaAfter = 1
+	// 一次密码错误后门户要求验证码
+	wrong := newTestAuthenticator(t, s, "wrong")
+	ctx := context.Background()
+	if _, err := wrong.Detect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := wrong.(portal.Preparer).Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := wrong.Login(ctx); !errors.Is(err, portal.ErrWrongPassword) {
+		t.Fatalf("Login err = %v, want ErrWrongPassword", err)
+	}
+
+	dir := t.TempDir()
+	c, err := NewClient(s.Config(t, "secret", "captcha: { solver: pause, dir: "+dir+" }\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := c.Authenticator()
+	if _, err = auth.Detect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = auth.(portal.Preparer).Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err = auth.Login(ctx); !errors.Is(err, portal.ErrCaptchaPending) {
+		t.Fatalf("Login err = %v, want ErrCaptchaPending", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("Login blocked for %v waiting for the answer", d)
+	}
+
+	images, _ := filepath.Glob(filepath.Join(dir, "shunet-captcha-*", captchaImage))
+	if len(images) != 1 {
+		t.Fatalf("images = %v, want one", images)
+	}
+	if fi, err := os.Stat(filepath.Dir(images[0])); err != nil || runtime.GOOS != "windows" && fi.Mode().Perm() != 0o700 {
+		t.Errorf("captcha dir mode = %v, %v, want 0700", fi.Mode(), err)
+	}
+	// 应答之前不提交登录
+	logins := s.Calls(portaltest.EndpointLogin)
+	if err = auth.Login(ctx); !errors.Is(err, portal.ErrCaptchaPending) {
+		t.Fatalf("Login err = %v, want ErrCaptchaPending", err)
+	}
+	if n := s.Calls(portaltest.EndpointLogin); n != logins {
+		t.Errorf("%d logins while waiting for the answer", n-logins)
+	}
+
+	answer := images[0] + ".txt"
+	if runtime.GOOS != "windows" {
+		// 链接不是有效的应答
+		target := filepath.Join(t.TempDir(), "answer")
+		if err = os.WriteFile(target, []byte(s.CaptchaCode()), 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if err = os.Symlink(target, answer); err != nil {
+			t.Fatal(err)
+		}
+		if err = auth.Login(ctx); err == nil || errors.Is(err, portal.ErrCaptchaPending) {
+			t.Errorf("Login err = %v, want the symlink rejected", err)
+		}
+	}
+	if err = os.WriteFile(answer, []byte(s.CaptchaCode()+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = auth.Login(ctx); err != nil {
+		t.Fatalf("Login with the answer: %v", err)
+	}
+	if _, err = os.Stat(filepath.Dir(images[0])); !os.IsNotExist(err) {
+		t.Errorf("captcha dir not removed after the answer: %v", err)
+	}
+}
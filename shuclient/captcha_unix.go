@@ -0,0 +1,15 @@
+//go:build !windows
+// +build !windows
+
+package shuclient
+
+import (
+	"os"
+	"syscall"
+)
+
+// ownedBySelf 判断文件是否属于运行守护进程的用户
+func ownedBySelf(fi os.FileInfo) bool {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	return ok && int(st.Uid) == os.Geteuid()
+}
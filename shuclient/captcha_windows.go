@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package shuclient
+
+import "os"
+
+// ownedBySelf 在 Windows 上总是成立, 私有目录继承用户目录的 ACL
+func ownedBySelf(fi os.FileInfo) bool {
+	return true
+}
@@ -1,5 +1,7 @@
 package shuclient
 
+import "encoding/json"
+
 type PageInfo struct {
 	PasswordEncrypt   string `json:"passwordEncrypt"`
 	PublicKeyExponent string `json:"publicKeyExponent"`
@@ -19,3 +21,74 @@ type GeneralResponse struct {
 	Result  string `json:"result"`
 	Message string `json:"message"`
 }
+
+type OnlineUserInfo struct {
+	UserIndex string `json:"userIndex"`
+	GeneralResponse
+	UserName          string          `json:"userName"`
+	UserId            string          `json:"userId"`
+	UserIp            string          `json:"userIp"`
+	UserMac           string          `json:"userMac"`
+	Service           string          `json:"service"`
+	UserGroup         string          `json:"userGroup"`
+	AccountFee        string          `json:"accountFee"` // 账户余额
+	MaxLeavingTime    string          `json:"maxLeavingTime"`
+	KeepAliveInterval int             `json:"keepaliveInterval"`
+	BallInfo          string          `json:"ballInfo"` // JSON 字符串, 包含流量、在线时长等, 见 Balls
+	MabInfo           json.RawMessage `json:"mabInfo"`  // 无感知认证绑定的 MAC, 可能是数组或 JSON 字符串, 见 MacBindings
+}
+
+// OnlineDevice 是 getOnlineUserList 返回的一个在线会话
+type OnlineDevice struct {
+	UserIndex string `json:"userIndex"`
+	UserIp    string `json:"userIp"`
+	UserMac   string `json:"userMac"`
+	LoginTime string `json:"loginTime"` // 如 2024-09-01 08:00:00
+}
+
+type OnlineDeviceList struct {
+	GeneralResponse
+	UserList []OnlineDevice `json:"userList"`
+}
+
+// MacBinding 是一个无感知认证绑定的 MAC
+type MacBinding struct {
+	Mac          string `json:"mac"`
+	RegisterTime string `json:"registerTime"`
+}
+
+// BallInfo 是页面上悬浮球展示的一项统计, 如已用流量、在线时长
+type BallInfo struct {
+	DisplayName string `json:"displayName"`
+	Type        string `json:"type"`
+	Value       string `json:"value"`
+}
+
+// Balls 解析 BallInfo 字段
+func (o *OnlineUserInfo) Balls() ([]BallInfo, error) {
+	if len(o.BallInfo) == 0 {
+		return nil, nil
+	}
+	var balls []BallInfo
+	if err := json.Unmarshal([]byte(o.BallInfo), &balls); err != nil {
+		return nil, err
+	}
+	return balls, nil
+}
+
+// MacBindings 解析 MabInfo 字段
+func (o *OnlineUserInfo) MacBindings() ([]MacBinding, error) {
+	raw := o.MabInfo
+	var str string
+	if err := json.Unmarshal(raw, &str); err == nil {
+		raw = json.RawMessage(str)
+	}
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil
+	}
+	var bindings []MacBinding
+	if err := json.Unmarshal(raw, &bindings); err != nil {
+		return nil, err
+	}
+	return bindings, nil
+}
@@ -0,0 +1,91 @@
+package shuclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"shunet/rsa"
+)
+
+// LoginTimeLayout 是门户返回的登录时间格式
+const LoginTimeLayout = "2006-01-02 15:04:05"
+
+// accountParams 是以学号和密码确认身份的接口参数. 密码只以加密形式发送,
+// 公钥由 EnsurePublicKey 取得, 在线时来自配置或保存的会话
+func (c *Client) accountParams(ctx context.Context) (map[string]string, error) {
+	if err := c.EnsurePublicKey(ctx); err != nil {
+		return nil, err
+	}
+	key, err := c.publicKey()
+	if err != nil {
+		return nil, err
+	}
+	return map[string]string{
+		"userId":          c.cfg.UserId,
+		"pass":            key.EncryptedPassword(c.cfg.Password, c.cfg.Mac),
+		"passwordEncrypt": "true",
+	}, nil
+}
+
+func (c *Client) publicKey() (*rsa.RSAPair, error) {
+	if c.rsa == nil {
+		if len(c.cfg.PublicKeyExponent) == 0 || len(c.cfg.PublicKeyModulus) == 0 {
+			return nil, fmt.Errorf("public key is unknown, call EnsurePublicKey first")
+		}
+		c.rsa = rsa.NewRSAPair(c.cfg.PublicKeyExponent, "", c.cfg.PublicKeyModulus)
+	}
+	return c.rsa, nil
+}
+
+// HasPublicKey 判断是否已取得加密密码的公钥
+func (c *Client) HasPublicKey() bool {
+	return c.rsa != nil || (len(c.cfg.PublicKeyExponent) > 0 && len(c.cfg.PublicKeyModulus) > 0)
+}
+
+// GetOnlineDevices 使用学号和密码查询账号的所有在线会话
+func (c *Client) GetOnlineDevices(ctx context.Context) (*OnlineDeviceList, error) {
+	param, err := c.accountParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.interfaceDo(ctx, "getOnlineUserList", param)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	list := &OnlineDeviceList{}
+	if err = json.Unmarshal(body, list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// LogoutDevice 使用学号和密码让账号的另一个会话下线, 不需要该会话的 cookie
+func (c *Client) LogoutDevice(ctx context.Context, userIndex string) (*GeneralResponse, error) {
+	if len(userIndex) == 0 {
+		return nil, fmt.Errorf("userIndex is empty")
+	}
+	param, err := c.accountParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	param["userIndex"] = userIndex
+	resp, err := c.interfaceDo(ctx, "logoutByUserIdAndPass", param)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	logoutResponse := &GeneralResponse{}
+	if err = json.Unmarshal(body, logoutResponse); err != nil {
+		return nil, err
+	}
+	return logoutResponse, nil
+}
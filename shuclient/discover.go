@@ -0,0 +1,141 @@
+package shuclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"net/http"
+	"os"
+	"path/filepath"
+	"shunet/utils"
+	"strings"
+	"time"
+)
+
+const (
+	discoveryFile      = "discovery.json"
+	defaultDiscoverURL = "http://connect.rom.miui.com/generate_204"
+	maxDiscoverHops    = 5
+)
+
+// discovery 是缓存到状态目录的门户地址, 下次启动时没有被拦截(已在线)也能使用
+type discovery struct {
+	BaseURL      string    `json:"baseUrl"`
+	DiscoveredAt time.Time `json:"discoveredAt"`
+}
+
+// setHostUrl 切换门户地址, base 形如 http://10.10.9.9:8080
+func (c *Client) setHostUrl(base string) {
+	c.hostUrl = base
+	c.interfaceDoPath = base + "/eportal/InterFace.do?method="
+}
+
+// HostUrl 返回当前使用的门户地址
+func (c *Client) HostUrl() string {
+	return c.hostUrl
+}
+
+// DiscoverHost 请求明文 HTTP 探测地址, 跟随门户的 302 跳转或 top.self.location.href 脚本,
+// 直到出现 /eportal/ 页面, 返回其协议、主机和端口. 没有被拦截(已在线)时返回空字符串
+func (c *Client) DiscoverHost(ctx context.Context) (string, error) {
+	next := c.cfg.Discover.URL
+	if len(next) == 0 {
+		next = defaultDiscoverURL
+	}
+	// 沿用 httpClient 的代理、cookie 与请求超时, 只改为不自动跟随跳转
+	client := *c.httpClient
+	client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	for i := 0; i < maxDiscoverHops; i++ {
+		req, err := http.NewRequestWithContext(ctx, "GET", next, nil)
+		if err != nil {
+			return "", err
+		}
+		req = setReqHeader(c.header, req)
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		page, err := utils.DecodeContent(resp)
+		resp.Body.Close()
+		if err != nil {
+			return "", err
+		}
+
+		target := ParsePage(resp, page).Redirect
+		if target == nil {
+			return "", nil
+		}
+
+		if strings.Contains(target.Path, "/eportal/") {
+			return target.Scheme + "://" + target.Host, nil
+		}
+		next = target.String()
+	}
+	return "", fmt.Errorf("no ePortal page after %d redirects", maxDiscoverHops)
+}
+
+// discover 在 Detect 前确定门户地址: 优先使用本次发现的地址, 其次是缓存的地址, 最后是配置的 host
+func (c *Client) discover(ctx context.Context) {
+	base, err := c.DiscoverHost(ctx)
+	if err != nil {
+		log.Warningf("DiscoverHost err: %+v, use %s", err, c.hostUrl)
+		return
+	}
+	if len(base) == 0 {
+		log.Debugf("DiscoverHost: not intercepted, use %s", c.hostUrl)
+		return
+	}
+	if base != c.hostUrl {
+		log.Infof("Discovered portal %s", base)
+		c.setHostUrl(base)
+	}
+	if err = c.saveDiscovery(base); err != nil {
+		log.Warningf("save discovered portal err: %+v", err)
+	}
+}
+
+// discoveryPath 以配置区分, 不同网络的配置不会互相覆盖发现的地址
+func (c *Client) discoveryPath() (string, error) {
+	return c.cfg.StatePath(discoveryFile)
+}
+
+func (c *Client) saveDiscovery(base string) error {
+	path, err := c.discoveryPath()
+	if err != nil {
+		return err
+	}
+	bytes, err := json.MarshalIndent(&discovery{BaseURL: base, DiscoveredAt: time.Now()}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, bytes, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// loadDiscovery 读取上次发现的门户地址
+func (c *Client) loadDiscovery() (string, error) {
+	path, err := c.discoveryPath()
+	if err != nil {
+		return "", err
+	}
+	bytes, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	var d discovery
+	if err = json.Unmarshal(bytes, &d); err != nil {
+		return "", err
+	}
+	return d.BaseURL, nil
+}
@@ -0,0 +1,87 @@
+package shuclient
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"net/http"
+	"net/http/httptest"
+	"shunet/config"
+	"shunet/portal"
+	"shunet/shuclient/portaltest"
+	"strings"
+	"testing"
+)
+
+// discoverConfig 返回 host 不可用、只能通过 captive 发现门户的配置
+func discoverConfig(t *testing.T, s *portaltest.Server, captive string) *config.Config {
+	t.Helper()
+	return portaltest.LoadConfig(t, fmt.Sprintf("userId: %s\npassword: secret\nhost: 127.0.0.1:9\ndiscover: { enabled: true, url: %s }\n", s.UserId, captive))
+}
+
+func TestDiscoverHost(t *testing.T) {
+	for _, redirect := range []bool{true, false} {
+		t.Run(fmt.Sprint("redirect=", redirect), func(t *testing.T) {
+			s := portaltest.NewServer("20120001", "secret")
+			defer s.Close()
+			captive := s.NewCaptiveServer(redirect)
+			defer captive.Close()
+			cfg := discoverConfig(t, s, captive.URL)
+			ctx := context.Background()
+
+			c, err := NewClient(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			auth := c.Authenticator()
+			if online, err := auth.Detect(ctx); err != nil || online {
+				t.Fatalf("Detect = %v, %v, want offline", online, err)
+			}
+			if c.HostUrl() != s.URL {
+				t.Fatalf("HostUrl = %s, want %s", c.HostUrl(), s.URL)
+			}
+			if err = auth.(portal.Preparer).Prepare(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err = auth.Login(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			// 已在线时不再被拦截, 重启后使用上次发现的地址
+			if base, err := c.DiscoverHost(ctx); err != nil || len(base) > 0 {
+				t.Errorf("DiscoverHost while online = %q, %v, want empty", base, err)
+			}
+			c, err = NewClient(cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.HostUrl() != s.URL {
+				t.Errorf("HostUrl after restart = %s, want the discovered %s", c.HostUrl(), s.URL)
+			}
+			if online, err := c.Authenticator().Detect(ctx); err != nil || !online {
+				t.Errorf("Detect after restart = %v, %v, want online", online, err)
+			}
+		})
+	}
+}
+
+func TestDiscoverHostLoop(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	loop := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/again", http.StatusFound)
+	}))
+	defer loop.Close()
+
+	c, err := NewClient(discoverConfig(t, s, loop.URL))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.DiscoverHost(context.Background()); err == nil || !strings.Contains(err.Error(), "redirects") {
+		t.Errorf("DiscoverHost err = %v, want too many redirects", err)
+	}
+	// 发现失败时仍使用配置的 host
+	c.discover(context.Background())
+	if c.HostUrl() != "http://127.0.0.1:9" {
+		t.Errorf("HostUrl = %s, want the configured host", c.HostUrl())
+	}
+}
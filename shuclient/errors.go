@@ -0,0 +1,28 @@
+package shuclient
+
+import "shunet/portal"
+
+// 门户返回的失败类型, 与 portal 包中的定义相同
+var (
+	ErrWrongPassword      = portal.ErrWrongPassword
+	ErrAccountArrears     = portal.ErrAccountArrears
+	ErrDeviceLimit        = portal.ErrDeviceLimit
+	ErrAccountLocked      = portal.ErrAccountLocked
+	ErrCaptchaRequired    = portal.ErrCaptchaRequired
+	ErrMacMismatch        = portal.ErrMacMismatch
+	ErrServiceUnavailable = portal.ErrServiceUnavailable
+	ErrUnknown            = portal.ErrUnknown
+)
+
+// Err 在 result 不为 success 时返回 *portal.PortalError
+func (r *GeneralResponse) Err() error {
+	if r.Result == "success" {
+		return nil
+	}
+	return portal.NewError(r.Message)
+}
+
+// ClassifyMessage 根据门户返回的 message 判断失败类型
+func ClassifyMessage(message string) error {
+	return portal.ClassifyMessage(message)
+}
@@ -0,0 +1,70 @@
+package shuclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"regexp"
+	"strings"
+)
+
+var macPattern = regexp.MustCompile(`^[0-9a-f]{12}$`)
+
+// NormalizeMac 把 00:E0:4C:68:00:01、00-e0-4c-68-00-01 等写法转换为门户使用的 00e04c680001
+func NormalizeMac(mac string) (string, error) {
+	res := strings.ToLower(strings.NewReplacer(":", "", "-", "", ".", "").Replace(strings.TrimSpace(mac)))
+	if !macPattern.MatchString(res) {
+		return "", fmt.Errorf("invalid mac %q", mac)
+	}
+	return res, nil
+}
+
+// RegisterMac 为当前会话的账号绑定无感知认证的 MAC, 需要已登录
+func (c *Client) RegisterMac(ctx context.Context, mac string) (*GeneralResponse, error) {
+	return c.macDo(ctx, "registerMac", mac)
+}
+
+// CancelMac 取消 MAC 的无感知认证绑定, 需要已登录
+func (c *Client) CancelMac(ctx context.Context, mac string) (*GeneralResponse, error) {
+	return c.macDo(ctx, "cancelMac", mac)
+}
+
+// GetMacBindings 查询账号绑定的 MAC, 需要已登录
+func (c *Client) GetMacBindings(ctx context.Context) ([]MacBinding, error) {
+	info, err := c.GetOnlineUserInfoContext(ctx, c.userIndex)
+	if err != nil {
+		return nil, err
+	}
+	if err = info.Err(); err != nil {
+		return nil, err
+	}
+	return info.MacBindings()
+}
+
+func (c *Client) macDo(ctx context.Context, method, mac string) (*GeneralResponse, error) {
+	if len(c.userIndex) == 0 {
+		return nil, fmt.Errorf("userIndex is empty")
+	}
+	mac, err := NormalizeMac(mac)
+	if err != nil {
+		return nil, err
+	}
+	param := map[string]string{
+		"userIndex": c.userIndex,
+		"mac":       mac,
+	}
+	resp, err := c.interfaceDo(ctx, method, param)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	res := &GeneralResponse{}
+	if err = json.Unmarshal(body, res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
@@ -0,0 +1,125 @@
+package shuclient
+
+import (
+	"golang.org/x/net/html"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+var (
+	// top.self.location.href='...'、window.location = "..." 等赋值
+	locationAssignPattern = regexp.MustCompile(`\blocation(?:\.href)?\s*=\s*(?:'([^']*)'|"([^"]*)")`)
+	// location.replace('...')、location.assign("...")
+	locationCallPattern = regexp.MustCompile(`\blocation\.(?:replace|assign)\(\s*(?:'([^']*)'|"([^"]*)")\s*\)`)
+	// <meta http-equiv="refresh" content="0; url=...">
+	metaRefreshPattern = regexp.MustCompile(`(?i)^\s*\d*(?:\.\d+)?\s*[;,]?\s*url\s*=\s*['"]?([^'"]*)`)
+	// successPaths 是登录成功后停留的页面
+	successPaths = []string{"/eportal/success.jsp"}
+)
+
+// loginPagePath 是认证页面, 跳转参数在其查询串中
+const loginPagePath = "/eportal/index.jsp"
+
+// Page 是对门户响应的分析结果
+type Page struct {
+	URL      *url.URL // 响应对应的地址, 相对跳转以此解析
+	Title    string
+	Redirect *url.URL // 3xx、meta refresh 或脚本给出的跳转地址, 没有时为 nil
+	Success  bool     // 登录成功页面, 由地址或标题判断
+}
+
+// ParsePage 分析门户返回的页面. 脚本只在 <script> 与 on* 事件属性中查找, 注释中的内容被忽略
+func ParsePage(resp *http.Response, body string) *Page {
+	p := &Page{URL: resp.Request.URL}
+	if resp.StatusCode >= 300 && resp.StatusCode < 400 {
+		if location, err := resp.Location(); err == nil {
+			p.Redirect = location
+		}
+	}
+
+	var href string
+	z := html.NewTokenizer(strings.NewReader(body))
+	inTitle, inScript := false, false
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			break
+		}
+		tok := z.Token()
+		switch tt {
+		case html.StartTagToken, html.SelfClosingTagToken:
+			switch tok.Data {
+			case "title":
+				inTitle = true
+			case "script":
+				inScript = true
+			case "meta":
+				if strings.EqualFold(attr(tok, "http-equiv"), "refresh") && len(href) == 0 {
+					if m := metaRefreshPattern.FindStringSubmatch(attr(tok, "content")); m != nil {
+						href = strings.TrimSpace(m[1])
+					}
+				}
+			}
+			for _, a := range tok.Attr {
+				if strings.HasPrefix(a.Key, "on") && len(href) == 0 {
+					href = scriptLocation(a.Val)
+				}
+			}
+		case html.EndTagToken:
+			switch tok.Data {
+			case "title":
+				inTitle = false
+			case "script":
+				inScript = false
+			}
+		case html.TextToken:
+			if inTitle {
+				p.Title += tok.Data
+			} else if inScript && len(href) == 0 {
+				href = scriptLocation(tok.Data)
+			}
+		}
+	}
+	p.Title = strings.TrimSpace(p.Title)
+
+	if p.Redirect == nil && len(href) > 0 {
+		if u, err := p.URL.Parse(href); err == nil {
+			p.Redirect = u
+		}
+	}
+	p.Success = p.Title == "登录成功" || strings.Contains(body, LoginSuccessPattern)
+	for _, path := range successPaths {
+		if strings.HasSuffix(p.URL.Path, path) {
+			p.Success = true
+		}
+	}
+	return p
+}
+
+// scriptLocation 返回脚本中第一处跳转地址
+func scriptLocation(script string) string {
+	first, href := -1, ""
+	for _, re := range []*regexp.Regexp{locationAssignPattern, locationCallPattern} {
+		if m := re.FindStringSubmatchIndex(script); m != nil && (first < 0 || m[0] < first) {
+			first = m[0]
+			if m[2] >= 0 {
+				href = script[m[2]:m[3]]
+			} else {
+				href = script[m[4]:m[5]]
+			}
+		}
+	}
+	// JSON 风格转义的斜杠
+	return strings.ReplaceAll(href, `\/`, "/")
+}
+
+func attr(tok html.Token, key string) string {
+	for _, a := range tok.Attr {
+		if a.Key == key {
+			return a.Val
+		}
+	}
+	return ""
+}
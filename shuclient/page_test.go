@@ -0,0 +1,30 @@
+package shuclient
+
+import (
+	"shunet/shuclient/portaltest"
+	"testing"
+)
+
+func TestParsePage(t *testing.T) {
+	for _, tt := range portaltest.Pages {
+		t.Run(tt.Name(), func(t *testing.T) {
+			p := ParsePage(tt.Response(), tt.Body())
+			if p.URL.String() != tt.URL {
+				t.Errorf("URL = %s, want %s", p.URL, tt.URL)
+			}
+			var redirect string
+			if p.Redirect != nil {
+				redirect = p.Redirect.String()
+			}
+			if redirect != tt.Redirect {
+				t.Errorf("Redirect = %q, want %q", redirect, tt.Redirect)
+			}
+			if p.Title != tt.Title {
+				t.Errorf("Title = %q, want %q", p.Title, tt.Title)
+			}
+			if p.Success != tt.Success {
+				t.Errorf("Success = %v, want %v", p.Success, tt.Success)
+			}
+		})
+	}
+}
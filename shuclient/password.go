@@ -0,0 +1,50 @@
+package shuclient
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"shunet/rsa"
+)
+
+// EnsurePublicKey 确保已取得加密密码的公钥. 离线时从认证页面获取;
+// 在线时没有跳转参数, 只能使用配置或保存的会话中的公钥
+func (c *Client) EnsurePublicKey(ctx context.Context) error {
+	if c.HasPublicKey() {
+		return nil
+	}
+	if _, err := c.EnterLoginPageContext(ctx); err != nil {
+		return err
+	}
+	if !c.IsLogin {
+		_, err := c.GetPageInfoContext(ctx)
+		return err
+	}
+	s, err := c.readSession()
+	if err != nil {
+		log.Warningf("read saved session err: %+v", err)
+	}
+	if s == nil || len(s.PublicKeyExponent) == 0 || len(s.PublicKeyModulus) == 0 {
+		return fmt.Errorf("public key is unknown while online, set publicKeyExponent and publicKeyModulus in config")
+	}
+	c.cfg.PublicKeyExponent, c.cfg.PublicKeyModulus = s.PublicKeyExponent, s.PublicKeyModulus
+	c.rsa = rsa.NewRSAPair(s.PublicKeyExponent, "", s.PublicKeyModulus)
+	if len(s.PasswordEncrypt) > 0 {
+		c.cfg.PasswordEncrypt = s.PasswordEncrypt
+	}
+	return nil
+}
+
+// EncryptPassword 返回登录时发送的加密密码, mac 为空时使用认证页面跳转参数中的 mac
+func (c *Client) EncryptPassword(ctx context.Context, password, mac string) (string, error) {
+	if err := c.EnsurePublicKey(ctx); err != nil {
+		return "", err
+	}
+	key, err := c.publicKey()
+	if err != nil {
+		return "", err
+	}
+	if len(mac) == 0 {
+		mac = c.cfg.Mac
+	}
+	return key.EncryptedPassword(password, mac), nil
+}
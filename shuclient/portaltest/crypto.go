@@ -0,0 +1,68 @@
+package portaltest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// keyPair 是模拟服务器下发给客户端的 RSA 密钥
+type keyPair struct {
+	key       *rsa.PrivateKey
+	chunkSize int
+}
+
+func newKeyPair() (*keyPair, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		return nil, err
+	}
+	// 与 rsa.NewRSAPair 一致: 每块 2*BiHighIndex 字节, 1024 位模数即 126 字节
+	return &keyPair{key: key, chunkSize: 2 * ((key.N.BitLen()+15)/16 - 1)}, nil
+}
+
+func (k *keyPair) exponent() string {
+	return big.NewInt(int64(k.key.E)).Text(16)
+}
+
+func (k *keyPair) modulus() string {
+	return k.key.N.Text(16)
+}
+
+// decrypt 解开 rsa.RSAPair.EncryptedPassword 的结果, 返回 password 与 mac
+func (k *keyPair) decrypt(encrypted string) (password, mac string, err error) {
+	blocks := strings.Fields(encrypted)
+	if len(blocks) == 0 {
+		return "", "", fmt.Errorf("empty encrypted password")
+	}
+	plain := make([]byte, 0, len(blocks)*k.chunkSize)
+	for _, block := range blocks {
+		c, ok := new(big.Int).SetString(block, 16)
+		if !ok {
+			return "", "", fmt.Errorf("invalid block %q", block)
+		}
+		// 块内按小端存放, big.Int 输出为大端
+		be := new(big.Int).Exp(c, k.key.D, k.key.N).Bytes()
+		if len(be) > k.chunkSize {
+			return "", "", fmt.Errorf("block larger than chunk size")
+		}
+		le := make([]byte, k.chunkSize)
+		for i, b := range be {
+			le[len(be)-1-i] = b
+		}
+		plain = append(plain, le...)
+	}
+
+	// 去掉块填充后还原被反转的 "password>mac"
+	s := []byte(strings.TrimRight(string(plain), "\x00"))
+	for i, j := 0, len(s)-1; i < j; i, j = i+1, j-1 {
+		s[i], s[j] = s[j], s[i]
+	}
+	idx := strings.LastIndex(string(s), ">")
+	if idx < 0 {
+		return "", "", fmt.Errorf("separator not found")
+	}
+	return string(s[:idx]), string(s[idx+1:]), nil
+}
@@ -0,0 +1,165 @@
+package portaltest
+
+import (
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Failure 描述一次注入的故障
+type Failure struct {
+	Status  int           // 非0时直接以该 HTTP 状态码响应
+	Body    string        // 非空时直接返回该原始响应体
+	Code    string        // 非空时由模拟服务器以各自的格式返回该错误代码, 如 Dr.COM 的 Msg
+	Message string        // 非空时由模拟服务器以各自的格式返回失败及该信息
+	Delay   time.Duration // 响应前等待的时间
+	Times   int           // 生效次数, 0 表示一直生效
+}
+
+// SessionRecord 是模拟服务器保存的在线会话, Sessions 按登录时间排序
+type SessionRecord interface {
+	LoginAt() time.Time
+}
+
+// Fixture 是各门户模拟服务器共用的部分: 按接口注入故障、记录调用次数以及保存在线会话.
+// 模拟服务器嵌入 *Fixture, 在处理请求前调用 Intercept
+type Fixture[S SessionRecord] struct {
+	mu           sync.Mutex
+	failures     map[string]*Failure
+	calls        map[string]int
+	sessions     map[string]*S
+	writeFailure func(w http.ResponseWriter, r *http.Request, f Failure)
+}
+
+// NewFixture 创建 Fixture, writeFailure 以门户的格式返回注入的 Message 或 Code,
+// 为 nil 时由调用方根据 Intercept 返回的 Failure 自行处理
+func NewFixture[S SessionRecord](writeFailure func(w http.ResponseWriter, r *http.Request, f Failure)) *Fixture[S] {
+	return &Fixture[S]{
+		failures:     make(map[string]*Failure),
+		calls:        make(map[string]int),
+		sessions:     make(map[string]*S),
+		writeFailure: writeFailure,
+	}
+}
+
+// Inject 为指定接口注入故障, 覆盖之前的设置
+func (x *Fixture[S]) Inject(endpoint string, f Failure) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.failures[endpoint] = &f
+}
+
+// ClearFailures 清除所有注入的故障
+func (x *Fixture[S]) ClearFailures() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.failures = make(map[string]*Failure)
+}
+
+// Calls 返回接口被调用的次数
+func (x *Fixture[S]) Calls(endpoint string) int {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	return x.calls[endpoint]
+}
+
+// Intercept 记录调用次数并执行注入的故障, 返回 true 表示请求已被处理.
+// 注入了 Message 或 Code 但没有 writeFailure 时返回 false, 由调用方按返回的 Failure 响应
+func (x *Fixture[S]) Intercept(endpoint string, w http.ResponseWriter, r *http.Request) (Failure, bool) {
+	x.mu.Lock()
+	x.calls[endpoint]++
+	var f Failure
+	if p, ok := x.failures[endpoint]; ok {
+		f = *p
+		if p.Times > 0 {
+			if p.Times--; p.Times == 0 {
+				delete(x.failures, endpoint)
+			}
+		}
+	}
+	x.mu.Unlock()
+
+	if f.Delay > 0 {
+		select {
+		case <-time.After(f.Delay):
+		case <-r.Context().Done():
+			return f, true
+		}
+	}
+	switch {
+	case f.Status != 0:
+		w.WriteHeader(f.Status)
+		_, _ = w.Write([]byte(f.Body))
+	case len(f.Body) > 0:
+		_, _ = w.Write([]byte(f.Body))
+	case (len(f.Message) > 0 || len(f.Code) > 0) && x.writeFailure != nil:
+		x.writeFailure(w, r, f)
+	default:
+		return f, false
+	}
+	return f, true
+}
+
+// Sessions 返回当前的在线会话, 按登录时间排序
+func (x *Fixture[S]) Sessions() []S {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	res := make([]S, 0, len(x.sessions))
+	for _, sess := range x.sessions {
+		res = append(res, *sess)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].LoginAt().Before(res[j].LoginAt()) })
+	return res
+}
+
+// Session 返回 key 对应会话的副本
+func (x *Fixture[S]) Session(key string) (S, bool) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	if sess, ok := x.sessions[key]; ok {
+		return *sess, true
+	}
+	var zero S
+	return zero, false
+}
+
+// PutSession 添加或替换 key 对应的会话
+func (x *Fixture[S]) PutSession(key string, sess S) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.sessions[key] = &sess
+}
+
+// DeleteSession 删除 key 对应的会话, 返回会话是否存在
+func (x *Fixture[S]) DeleteSession(key string) bool {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	_, ok := x.sessions[key]
+	delete(x.sessions, key)
+	return ok
+}
+
+// WithSessions 在持有锁时调用 f, 用于需要同时检查并修改多个会话的操作
+func (x *Fixture[S]) WithSessions(f func(sessions map[string]*S)) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	f(x.sessions)
+}
+
+// ExpireSessions 立即让所有会话下线, 模拟门户侧认证失效
+func (x *Fixture[S]) ExpireSessions() {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.sessions = make(map[string]*S)
+}
+
+// RemoteIP 返回请求的来源 IP, 模拟门户按来源 IP 判断是否在线
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
@@ -0,0 +1,146 @@
+package portaltest
+
+import (
+	"embed"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+//go:embed pages
+var pages embed.FS
+
+// Page 是一个保存下来的门户响应及期望的识别结果, 用于检查 shuclient.ParsePage
+type Page struct {
+	File     string // pages 目录下的文件名, 为空表示没有响应体
+	URL      string // 响应对应的地址, 相对跳转以此解析
+	Status   int    // 为 0 时是 200
+	Location string // 3xx 响应的 Location
+	Redirect string // 期望识别出的跳转地址, 为空表示没有跳转
+	Title    string // 期望识别出的标题
+	Success  bool   // 期望识别为登录成功页面
+}
+
+// Pages 是收集的各类门户页面
+var Pages = []Page{
+	{
+		File:     "top_self.html",
+		URL:      "http://10.10.9.9/",
+		Redirect: "http://10.10.9.9/eportal/index.jsp?wlanuserip=10.1.2.3&wlanacname=shu&ssid=&nasip=10.10.0.1&snmpagentip=&mac=00e04c680001&t=wireless-v2&url=http://www.msftconnecttest.com/redirect&apmac=&nasid=shu&vid=0&port=0&nasportid=AggregatePort%201.00000000:0-0",
+	},
+	{
+		File:     "top_self_double_quote.html",
+		URL:      "http://10.10.9.9:8080/",
+		Redirect: "http://10.10.9.9:8080/eportal/index.jsp?wlanuserip=10.1.2.3&nasip=10.10.0.1&mac=00e04c680001",
+	},
+	{
+		File:     "meta_refresh.html",
+		URL:      "http://10.10.9.9/",
+		Title:    "Redirecting",
+		Redirect: "http://10.10.9.9/eportal/index.jsp?wlanuserip=10.1.2.3&nasip=10.10.0.1&mac=00e04c680001",
+	},
+	{
+		File:     "location_replace.html",
+		URL:      "http://10.10.9.9/portal/",
+		Redirect: "http://10.10.9.9/portal/eportal/index.jsp?wlanuserip=10.1.2.3&nasip=10.10.0.1",
+	},
+	{
+		File:     "window_location_relative.html",
+		URL:      "http://10.10.9.9/gw/redirect.html",
+		Redirect: "http://10.10.9.9/eportal/index.jsp?wlanuserip=10.1.2.3&nasip=10.10.0.1",
+	},
+	{
+		// 注释中的旧跳转不应生效
+		File:     "commented_redirect.html",
+		URL:      "http://10.10.9.9/",
+		Redirect: "http://10.10.9.9/eportal/index.jsp?wlanuserip=10.1.2.3",
+	},
+	{
+		File:     "onload.html",
+		URL:      "https://portal.example.edu/",
+		Redirect: "https://portal.example.edu/eportal/index.jsp?wlanuserip=10.1.2.3&nasip=10.10.0.1",
+	},
+	{
+		File:     "escaped_slashes.html",
+		URL:      "http://10.10.9.9/",
+		Redirect: "http://10.10.9.9/eportal/index.jsp?wlanuserip=10.1.2.3",
+	},
+	{
+		URL:      "http://connect.rom.miui.com/generate_204",
+		Status:   http.StatusFound,
+		Location: "http://10.10.9.9/eportal/index.jsp?wlanuserip=10.1.2.3",
+		Redirect: "http://10.10.9.9/eportal/index.jsp?wlanuserip=10.1.2.3",
+	},
+	{
+		URL:      "http://10.10.9.9/",
+		Status:   http.StatusMovedPermanently,
+		Location: "eportal/index.jsp?wlanuserip=10.1.2.3",
+		Redirect: "http://10.10.9.9/eportal/index.jsp?wlanuserip=10.1.2.3",
+	},
+	{
+		// 成功页面中函数里的跳转同样会被识别, 调用方应先判断 Success
+		File:     "success_title.html",
+		URL:      "http://10.10.9.9/eportal/success.jsp?userIndex=abc123",
+		Redirect: "http://10.10.9.9/eportal/logout.jsp",
+		Title:    "登录成功",
+		Success:  true,
+	},
+	{
+		File:     "success_path.html",
+		URL:      "http://10.10.9.9/eportal/success.jsp?userIndex=abc123",
+		Redirect: "http://www.shu.edu.cn/",
+		Title:    "Authentication Result",
+		Success:  true,
+	},
+	{
+		// 比较 location.href 的脚本不是跳转
+		File:  "login_form.html",
+		URL:   "http://10.10.9.9/eportal/index.jsp?wlanuserip=10.1.2.3",
+		Title: "上海大学校园网认证",
+	},
+}
+
+// Body 返回页面内容
+func (p Page) Body() string {
+	if len(p.File) == 0 {
+		return ""
+	}
+	b, err := pages.ReadFile("pages/" + p.File)
+	if err != nil {
+		panic("portaltest: " + err.Error())
+	}
+	return string(b)
+}
+
+// Name 返回用于日志的名字
+func (p Page) Name() string {
+	if len(p.File) > 0 {
+		return p.File
+	}
+	return http.StatusText(p.Status) + " " + p.Location
+}
+
+// Response 构造对应的 *http.Response, 供 shuclient.ParsePage 使用
+func (p Page) Response() *http.Response {
+	u, err := url.Parse(p.URL)
+	if err != nil {
+		panic("portaltest: " + err.Error())
+	}
+	status := p.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	resp := &http.Response{
+		StatusCode: status,
+		Status:     http.StatusText(status),
+		Header:     make(http.Header),
+		Body:       io.NopCloser(strings.NewReader(p.Body())),
+		Request:    &http.Request{Method: "GET", URL: u},
+	}
+	resp.Header.Set("Content-Type", "text/html")
+	if len(p.Location) > 0 {
+		resp.Header.Set("Location", p.Location)
+	}
+	return resp
+}
@@ -0,0 +1,722 @@
+// Package portaltest 提供一个进程内的锐捷 ePortal 模拟服务器, 用于离线测试 shuclient.
+//
+// 模拟的流程与 shuclient.Client 访问的一致: 根页面返回 top.self.location.href 跳转脚本
+// (可用 RedirectStyle 换成 meta refresh 等), 在线时跳转到 GBK 编码的"登录成功"页面并携带 userIndex, 以及
+// /eportal/InterFace.do?method= 下的 pageInfo, login, logout, keepalive, getOnlineUserInfo 接口.
+// 绑定了无感知认证的 MAC(即跳转参数中的 Mac)后, 未在线的 IP 访问门户时直接获得新会话.
+// Pages 是收集的各类门户页面及期望的识别结果.
+// Fixture 是各门户模拟服务器(sruntest, drcomtest, formportaltest)共用的故障注入、调用计数与会话记录.
+package portaltest
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"html"
+	"image"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// 可注入故障的接口名
+const (
+	EndpointRoot      = "root"
+	EndpointPageInfo  = "pageInfo"
+	EndpointLogin     = "login"
+	EndpointLogout    = "logout"
+	EndpointKeepAlive = "keepalive"
+	EndpointUserInfo  = "getOnlineUserInfo"
+	EndpointServices  = "getServices"
+	EndpointDevices   = "getOnlineUserList"
+	EndpointKick      = "logoutByUserIdAndPass"
+	EndpointBindMac   = "registerMac"
+	EndpointUnbindMac = "cancelMac"
+)
+
+// 门户返回的提示信息
+const (
+	MessageWrongPassword = "密码不匹配,请输入正确的密码!"
+	MessageUserNotExist  = "用户不存在,请输入正确的用户名!"
+	MessageMacMismatch   = "MAC地址校验失败!"
+	MessageNotOnline     = "用户已不在线"
+	MessageLogoutSuccess = "下线成功！"
+	MessageNeedCaptcha   = "请输入正确的验证码"
+	MessageWrongService  = "请选择正确的服务"
+	MessageDeviceLimit   = "您的账号在线终端数已达上限"
+	MessageBindLimit     = "无感知认证绑定的MAC数量已满"
+	MessageMacNotBound   = "该MAC地址未绑定无感知认证"
+)
+
+const sessionCookie = "JSESSIONID"
+
+// 首页跳转到认证页面的方式
+const (
+	RedirectScript  = ""        // <script>top.self.location.href='...'</script>
+	RedirectMeta    = "meta"    // <meta http-equiv="refresh">, 使用相对地址
+	RedirectReplace = "replace" // window.location.replace("..."), 使用相对地址
+	RedirectStatus  = "302"     // HTTP 302
+)
+
+// Session 是服务器上的一个在线会话
+type Session struct {
+	UserIndex string
+	UserId    string
+	Service   string
+	Mac       string
+	IP        string
+	LoginTime time.Time
+	ExpireAt  time.Time // 零值表示不过期
+}
+
+func (s Session) LoginAt() time.Time {
+	return s.LoginTime
+}
+
+// Server 是模拟的 ePortal 服务器, 导出字段需在发起请求前设置
+type Server struct {
+	*httptest.Server
+	*Fixture[Session] // 会话以 userIndex 为键, 注入的 Message 以 result=fail 返回
+
+	UserId            string
+	Password          string
+	Mac               string        // 跳转参数中下发的 mac, 登录时校验
+	SessionTTL        time.Duration // 会话在最后一次登录或保活后多久过期, 0 表示不过期
+	KeepAliveInterval int           // login 返回的 keepaliveInterval
+	AccountFee        string        // getOnlineUserInfo 返回的账户余额
+	CaptchaAfter      int           // 连续登录失败多少次后要求验证码, 0 表示不要求
+	Services          []string      // getServices 返回的服务, 登录时校验
+	RedirectStyle     string        // 未登录时首页跳转到认证页面的方式, 见 Redirect* 常量
+	MaxDevices        int           // 账号同时在线的会话数上限, 0 表示不限制
+	MaxBindings       int           // 无感知认证可绑定的 MAC 数, 0 表示不限制
+
+	key          *keyPair
+	mu           sync.Mutex
+	bindings     map[string]time.Time // 无感知认证绑定的 MAC -> 绑定时间
+	failedLogins int
+	captchas     map[string]string // JSESSIONID -> 验证码
+	lastCaptcha  string
+}
+
+// NewServer 启动一个模拟服务器, 使用完毕后需调用 Close
+func NewServer(userId, password string) *Server {
+	s := newServer(userId, password)
+	s.Start()
+	return s
+}
+
+// NewTLSServer 启动一个使用自签名证书的 https 模拟服务器, 证书可从 Certificate 获取
+func NewTLSServer(userId, password string) *Server {
+	s := newServer(userId, password)
+	s.StartTLS()
+	return s
+}
+
+func newServer(userId, password string) *Server {
+	key, err := newKeyPair()
+	if err != nil {
+		panic(fmt.Sprintf("portaltest: generate key: %v", err))
+	}
+	s := &Server{
+		UserId:            userId,
+		Password:          password,
+		Mac:               "00e04c680001",
+		KeepAliveInterval: 600,
+		AccountFee:        "30.00",
+		Services:          []string{"shu"},
+		key:               key,
+		bindings:          make(map[string]time.Time),
+		captchas:          make(map[string]string),
+	}
+	s.Fixture = NewFixture[Session](func(w http.ResponseWriter, r *http.Request, f Failure) {
+		writeJSON(w, map[string]any{"result": "fail", "message": f.Message})
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleRoot)
+	mux.HandleFunc("/eportal/index.jsp", s.handleIndex)
+	mux.HandleFunc("/eportal/success.jsp", s.handleSuccess)
+	mux.HandleFunc("/eportal/validcode", s.handleValidCode)
+	mux.HandleFunc("/eportal/InterFace.do", s.handleInterfaceDo)
+	s.Server = httptest.NewUnstartedServer(mux)
+	return s
+}
+
+// Host 返回可直接填入 config.Config.Host 的地址, https 服务器带协议
+func (s *Server) Host() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// PublicKey 返回 pageInfo 下发的公钥
+func (s *Server) PublicKey() (exponent, modulus string) {
+	return s.key.exponent(), s.key.modulus()
+}
+
+// Decrypt 解密 rsa.RSAPair.EncryptedPassword 生成的密码
+func (s *Server) Decrypt(encrypted string) (password, mac string, err error) {
+	return s.key.decrypt(encrypted)
+}
+
+// Sessions 返回当前未过期的会话
+func (s *Server) Sessions() []Session {
+	s.WithSessions(func(sessions map[string]*Session) { expire(sessions, time.Now()) })
+	return s.Fixture.Sessions()
+}
+
+// AddSession 直接添加一个在线会话, 模拟账号在其他设备上登录
+func (s *Server) AddSession(ip, mac string, loginTime time.Time) Session {
+	sess := &Session{
+		UserIndex: newUserIndex(),
+		UserId:    s.UserId,
+		Service:   "shu",
+		Mac:       mac,
+		IP:        ip,
+		LoginTime: loginTime,
+	}
+	s.touch(sess, time.Now())
+	s.PutSession(sess.UserIndex, *sess)
+	return *sess
+}
+
+// BoundMacs 返回无感知认证绑定的 MAC
+func (s *Server) BoundMacs() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	res := make([]string, 0, len(s.bindings))
+	for mac := range s.bindings {
+		res = append(res, mac)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// CaptchaCode 返回最近一次下发的验证码
+func (s *Server) CaptchaCode() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastCaptcha
+}
+
+func expire(sessions map[string]*Session, now time.Time) {
+	for k, sess := range sessions {
+		if !sess.ExpireAt.IsZero() && now.After(sess.ExpireAt) {
+			delete(sessions, k)
+		}
+	}
+}
+
+func (s *Server) touch(sess *Session, now time.Time) {
+	if s.SessionTTL > 0 {
+		sess.ExpireAt = now.Add(s.SessionTTL)
+	}
+}
+
+// sessionByIPLocked 与真实门户一样按来源 IP 判断是否在线, 需持有 s.mu 并在 WithSessions 中调用
+func (s *Server) sessionByIPLocked(sessions map[string]*Session, ip string) *Session {
+	now := time.Now()
+	expire(sessions, now)
+	for _, sess := range sessions {
+		if sess.IP == ip {
+			return sess
+		}
+	}
+	if _, ok := s.bindings[s.Mac]; ok {
+		// 无感知认证: 绑定的 MAC 不经登录直接上线
+		sess := &Session{
+			UserIndex: newUserIndex(),
+			UserId:    s.UserId,
+			Service:   "shu",
+			Mac:       s.Mac,
+			IP:        ip,
+			LoginTime: now,
+		}
+		s.touch(sess, now)
+		sessions[sess.UserIndex] = sess
+		return sess
+	}
+	return nil
+}
+
+// online 返回 ip 在线会话的 userIndex, 不在线时返回空字符串
+func (s *Server) online(ip string) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var userIndex string
+	s.WithSessions(func(sessions map[string]*Session) {
+		if sess := s.sessionByIPLocked(sessions, ip); sess != nil {
+			userIndex = sess.UserIndex
+		}
+	})
+	return userIndex
+}
+
+// liveSession 返回 userIndex 对应的未过期会话, 可选地刷新其过期时间
+func (s *Server) liveSession(userIndex string, touch bool) (Session, bool) {
+	var (
+		sess Session
+		ok   bool
+	)
+	now := time.Now()
+	s.WithSessions(func(sessions map[string]*Session) {
+		expire(sessions, now)
+		var p *Session
+		if p, ok = sessions[userIndex]; ok {
+			if touch {
+				s.touch(p, now)
+			}
+			sess = *p
+		}
+	})
+	return sess, ok
+}
+
+// intercept 执行注入的故障, 返回 true 表示请求已被处理
+func (s *Server) intercept(endpoint string, w http.ResponseWriter, r *http.Request) bool {
+	_, done := s.Intercept(endpoint, w, r)
+	return done
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json;charset=UTF-8")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if s.intercept(EndpointRoot, w, r) {
+		return
+	}
+	ip := RemoteIP(r)
+	if userIndex := s.online(ip); len(userIndex) > 0 {
+		http.Redirect(w, r, "/eportal/success.jsp?userIndex="+url.QueryEscape(userIndex), http.StatusFound)
+		return
+	}
+
+	href := s.loginHref(ip)
+	relative := strings.TrimPrefix(href, s.URL)
+	w.Header().Set("Content-Type", "text/html")
+	switch s.RedirectStyle {
+	case RedirectMeta:
+		fmt.Fprintf(w, `<html><head><meta http-equiv="refresh" content="0; url=%s"></head></html>`, html.EscapeString(relative))
+	case RedirectReplace:
+		fmt.Fprintf(w, `<html><body><script>window.location.replace("%s");</script></body></html>`, "."+relative)
+	case RedirectStatus:
+		http.Redirect(w, r, href, http.StatusFound)
+	default:
+		fmt.Fprintf(w, "<script>top.self.location.href='%s'</script>\n", href)
+	}
+}
+
+// loginHref 是未登录时跳转的认证页面地址
+func (s *Server) loginHref(ip string) string {
+	params := url.Values{}
+	params.Set("wlanuserip", ip)
+	params.Set("wlanacname", "portaltest")
+	params.Set("ssid", "")
+	params.Set("nasip", "127.0.0.1")
+	params.Set("mac", s.Mac)
+	params.Set("t", "wireless-v2")
+	params.Set("url", "http://www.msftconnecttest.com/redirect")
+	return s.URL + "/eportal/index.jsp?" + params.Encode()
+}
+
+// NewCaptiveServer 启动一个模拟被门户拦截的外网地址, 使用完毕后需调用 Close.
+// 已登录的 IP 得到 204; 未登录时 redirect 为 true 则以 302 跳转到门户首页,
+// 否则像网关劫持一样直接返回 top.self.location.href 脚本
+func (s *Server) NewCaptiveServer(redirect bool) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ip := RemoteIP(r)
+		switch {
+		case len(s.online(ip)) > 0:
+			w.WriteHeader(http.StatusNoContent)
+		case redirect:
+			http.Redirect(w, r, s.URL+"/", http.StatusFound)
+		default:
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, "<script>top.self.location.href='%s'</script>\n", s.loginHref(ip))
+		}
+	}))
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if _, err := r.Cookie(sessionCookie); err != nil {
+		http.SetCookie(w, &http.Cookie{Name: sessionCookie, Value: newUserIndex(), Path: "/eportal"})
+	}
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, "<html><head><title>上海大学校园网认证</title></head><body></body></html>")
+}
+
+func (s *Server) handleSuccess(w http.ResponseWriter, r *http.Request) {
+	page := "<html><head><title>登录成功</title></head><body>userIndex=" + r.URL.Query().Get("userIndex") + "</body></html>"
+	gbk, err := simplifiedchinese.GBK.NewEncoder().Bytes([]byte(page))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html;charset=GBK")
+	_, _ = w.Write(gbk)
+}
+
+// handleValidCode 生成验证码图片, 验证码与 JSESSIONID 绑定
+func (s *Server) handleValidCode(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		http.Error(w, "no session", http.StatusForbidden)
+		return
+	}
+	code := newUserIndex()[:4]
+	s.mu.Lock()
+	s.captchas[cookie.Value] = code
+	s.lastCaptcha = code
+	s.mu.Unlock()
+
+	img := image.NewGray(image.Rect(0, 0, 60, 20))
+	w.Header().Set("Content-Type", "image/png")
+	_ = png.Encode(w, img)
+}
+
+func (s *Server) handleInterfaceDo(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	method := r.URL.Query().Get("method")
+	switch method {
+	case EndpointPageInfo:
+		if s.intercept(method, w, r) {
+			return
+		}
+		s.pageInfo(w, r)
+	case EndpointLogin:
+		if s.intercept(method, w, r) {
+			return
+		}
+		s.login(w, r)
+	case EndpointLogout:
+		if s.intercept(method, w, r) {
+			return
+		}
+		s.logout(w, r)
+	case EndpointKeepAlive:
+		if s.intercept(method, w, r) {
+			return
+		}
+		s.keepAlive(w, r)
+	case EndpointUserInfo:
+		if s.intercept(method, w, r) {
+			return
+		}
+		s.onlineUserInfo(w, r)
+	case EndpointServices:
+		if s.intercept(method, w, r) {
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain;charset=UTF-8")
+		fmt.Fprint(w, strings.Join(s.Services, "@"))
+	case EndpointDevices:
+		if s.intercept(method, w, r) {
+			return
+		}
+		s.devices(w, r)
+	case EndpointKick:
+		if s.intercept(method, w, r) {
+			return
+		}
+		s.kick(w, r)
+	case EndpointBindMac:
+		if s.intercept(method, w, r) {
+			return
+		}
+		s.bindMac(w, r)
+	case EndpointUnbindMac:
+		if s.intercept(method, w, r) {
+			return
+		}
+		s.unbindMac(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (s *Server) pageInfo(w http.ResponseWriter, r *http.Request) {
+	if len(r.PostForm.Get("queryString")) == 0 {
+		writeJSON(w, map[string]any{"result": "fail", "message": "queryString is empty"})
+		return
+	}
+	writeJSON(w, map[string]any{
+		"passwordEncrypt":   "true",
+		"publicKeyExponent": s.key.exponent(),
+		"publicKeyModulus":  s.key.modulus(),
+	})
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	form := r.PostForm
+	query, err := url.ParseQuery(form.Get("queryString"))
+	if err != nil || len(query.Get("mac")) == 0 {
+		writeJSON(w, map[string]any{"result": "fail", "message": "queryString is invalid"})
+		return
+	}
+
+	password, mac := form.Get("password"), query.Get("mac")
+	if form.Get("passwordEncrypt") == "true" {
+		if password, mac, err = s.key.decrypt(password); err != nil {
+			writeJSON(w, map[string]any{"result": "fail", "message": MessageWrongPassword})
+			return
+		}
+	}
+
+	if !s.checkCaptcha(r) {
+		writeJSON(w, map[string]any{
+			"result":       "fail",
+			"message":      MessageNeedCaptcha,
+			"validCodeUrl": "/eportal/validcode?rnd=" + newUserIndex()[:8],
+		})
+		return
+	}
+
+	var message string
+	switch {
+	case form.Get("userId") != s.UserId:
+		message = MessageUserNotExist
+	case password != s.Password:
+		message = MessageWrongPassword
+	case mac != s.Mac || query.Get("mac") != s.Mac:
+		message = MessageMacMismatch
+	case !s.hasService(form.Get("service")):
+		message = MessageWrongService
+	}
+	if len(message) > 0 {
+		s.mu.Lock()
+		s.failedLogins++
+		s.mu.Unlock()
+		writeJSON(w, map[string]any{"result": "fail", "message": message})
+		return
+	}
+
+	now := time.Now()
+	sess := &Session{
+		UserIndex: newUserIndex(),
+		UserId:    s.UserId,
+		Service:   form.Get("service"),
+		Mac:       mac,
+		IP:        RemoteIP(r),
+		LoginTime: now,
+	}
+	s.mu.Lock()
+	limited := false
+	s.WithSessions(func(sessions map[string]*Session) {
+		// 同一 IP 重复登录时替换旧会话
+		if old := s.sessionByIPLocked(sessions, sess.IP); old != nil {
+			delete(sessions, old.UserIndex)
+		}
+		if limited = s.MaxDevices > 0 && len(sessions) >= s.MaxDevices; !limited {
+			s.touch(sess, now)
+			sessions[sess.UserIndex] = sess
+		}
+	})
+	if !limited {
+		s.failedLogins = 0
+	}
+	interval := s.KeepAliveInterval
+	s.mu.Unlock()
+	if limited {
+		writeJSON(w, map[string]any{"result": "fail", "message": MessageDeviceLimit})
+		return
+	}
+
+	writeJSON(w, map[string]any{
+		"userIndex":         sess.UserIndex,
+		"result":            "success",
+		"message":           "",
+		"forwordurl":        nil,
+		"keepaliveInterval": interval,
+		"validCodeUrl":      "",
+	})
+}
+
+// checkCaptcha 在连续失败次数达到 CaptchaAfter 后校验验证码, 验证码只能使用一次
+func (s *Server) checkCaptcha(r *http.Request) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.CaptchaAfter <= 0 || s.failedLogins < s.CaptchaAfter {
+		return true
+	}
+	cookie, err := r.Cookie(sessionCookie)
+	if err != nil {
+		return false
+	}
+	code, ok := s.captchas[cookie.Value]
+	delete(s.captchas, cookie.Value)
+	return ok && len(code) > 0 && code == r.PostForm.Get("validcode")
+}
+
+func (s *Server) hasService(service string) bool {
+	for _, v := range s.Services {
+		if v == service {
+			return true
+		}
+	}
+	return false
+}
+
+// checkAccount 校验 userId/pass 形式的身份参数, pass 可以是加密后的密码
+func (s *Server) checkAccount(form url.Values) bool {
+	password := form.Get("pass")
+	if form.Get("passwordEncrypt") == "true" {
+		var err error
+		if password, _, err = s.key.decrypt(password); err != nil {
+			return false
+		}
+	}
+	return form.Get("userId") == s.UserId && password == s.Password
+}
+
+func (s *Server) devices(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAccount(r.PostForm) {
+		writeJSON(w, map[string]any{"result": "fail", "message": MessageWrongPassword})
+		return
+	}
+	sessions := s.Sessions()
+	list := make([]map[string]string, 0, len(sessions))
+	for _, sess := range sessions {
+		list = append(list, map[string]string{
+			"userIndex": sess.UserIndex,
+			"userIp":    sess.IP,
+			"userMac":   sess.Mac,
+			"loginTime": sess.LoginTime.Format("2006-01-02 15:04:05"),
+		})
+	}
+	writeJSON(w, map[string]any{"result": "success", "message": "", "userList": list})
+}
+
+func (s *Server) kick(w http.ResponseWriter, r *http.Request) {
+	if !s.checkAccount(r.PostForm) {
+		writeJSON(w, map[string]any{"result": "fail", "message": MessageWrongPassword})
+		return
+	}
+	s.logout(w, r)
+}
+
+// bindMac 与 unbindMac 需要在线会话的 userIndex
+func (s *Server) bindMac(w http.ResponseWriter, r *http.Request) {
+	mac := r.PostForm.Get("mac")
+	_, online := s.liveSession(r.PostForm.Get("userIndex"), false)
+	s.mu.Lock()
+	_, bound := s.bindings[mac]
+	var message string
+	switch {
+	case !online:
+		message = MessageNotOnline
+	case len(mac) == 0:
+		message = "mac is empty"
+	case !bound && s.MaxBindings > 0 && len(s.bindings) >= s.MaxBindings:
+		message = MessageBindLimit
+	default:
+		if !bound {
+			s.bindings[mac] = time.Now()
+		}
+	}
+	s.mu.Unlock()
+	if len(message) > 0 {
+		writeJSON(w, map[string]any{"result": "fail", "message": message})
+		return
+	}
+	writeJSON(w, map[string]any{"result": "success", "message": ""})
+}
+
+func (s *Server) unbindMac(w http.ResponseWriter, r *http.Request) {
+	mac := r.PostForm.Get("mac")
+	_, online := s.liveSession(r.PostForm.Get("userIndex"), false)
+	s.mu.Lock()
+	_, bound := s.bindings[mac]
+	if online && bound {
+		delete(s.bindings, mac)
+	}
+	s.mu.Unlock()
+	switch {
+	case !online:
+		writeJSON(w, map[string]any{"result": "fail", "message": MessageNotOnline})
+	case !bound:
+		writeJSON(w, map[string]any{"result": "fail", "message": MessageMacNotBound})
+	default:
+		writeJSON(w, map[string]any{"result": "success", "message": ""})
+	}
+}
+
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	userIndex := r.PostForm.Get("userIndex")
+	if _, ok := s.liveSession(userIndex, false); !ok || !s.DeleteSession(userIndex) {
+		writeJSON(w, map[string]any{"result": "fail", "message": MessageNotOnline})
+		return
+	}
+	writeJSON(w, map[string]any{"result": "success", "message": MessageLogoutSuccess})
+}
+
+func (s *Server) keepAlive(w http.ResponseWriter, r *http.Request) {
+	if _, ok := s.liveSession(r.PostForm.Get("userIndex"), true); !ok {
+		writeJSON(w, map[string]any{"result": "fail", "message": MessageNotOnline})
+		return
+	}
+	writeJSON(w, map[string]any{"result": "success", "message": ""})
+}
+
+func (s *Server) onlineUserInfo(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	sess, ok := s.liveSession(r.PostForm.Get("userIndex"), false)
+	s.mu.Lock()
+	fee, interval := s.AccountFee, s.KeepAliveInterval
+	bindings := make([]map[string]string, 0, len(s.bindings))
+	for mac, t := range s.bindings {
+		bindings = append(bindings, map[string]string{"mac": mac, "registerTime": t.Format("2006-01-02 15:04:05")})
+	}
+	s.mu.Unlock()
+	sort.Slice(bindings, func(i, j int) bool { return bindings[i]["mac"] < bindings[j]["mac"] })
+	if !ok {
+		writeJSON(w, map[string]any{"result": "fail", "message": MessageNotOnline})
+		return
+	}
+
+	balls, _ := json.Marshal([]map[string]string{
+		{"displayName": "在线时长", "type": "time", "value": now.Sub(sess.LoginTime).Truncate(time.Second).String()},
+		{"displayName": "已用流量", "type": "flow", "value": "0MB"},
+	})
+	writeJSON(w, map[string]any{
+		"userIndex":         sess.UserIndex,
+		"result":            "success",
+		"message":           "",
+		"userName":          sess.UserId,
+		"userId":            sess.UserId,
+		"userIp":            sess.IP,
+		"userMac":           sess.Mac,
+		"service":           sess.Service,
+		"userGroup":         "学生",
+		"accountFee":        fee,
+		"maxLeavingTime":    "",
+		"keepaliveInterval": interval,
+		"ballInfo":          string(balls),
+		"mabInfo":           bindings,
+	})
+}
+
+func newUserIndex() string {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(b)
+}
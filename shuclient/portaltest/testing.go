@@ -0,0 +1,93 @@
+package portaltest
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"shunet/config"
+	"shunet/portal"
+	"testing"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// LoadConfig 把 yaml 写入临时目录并用 config.LoadConfig 加载, 与实际使用一样从文件读取; 状态文件也写入该目录
+func LoadConfig(t testing.TB, yaml string) *config.Config {
+	t.Helper()
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	yaml = fmt.Sprintf("%s\nstateDir: %s\n", yaml, dir)
+	if err := os.WriteFile(path, []byte(yaml), 0600); err != nil {
+		t.Fatal(err)
+	}
+	c, err := config.LoadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// Config 返回以 password 登录 s 的配置, extra 追加在 yaml 末尾
+func (s *Server) Config(t testing.TB, password, extra string) *config.Config {
+	t.Helper()
+	return LoadConfig(t, fmt.Sprintf("userId: %s\npassword: %s\nhost: %s\n%s", s.UserId, password, s.Host(), extra))
+}
+
+// Daemon 是在后台运行的 portal.Daemon, 测试结束时自动停止
+type Daemon struct {
+	*portal.Daemon
+	states <-chan portal.StateInfo
+	stop   func()
+}
+
+// StartDaemon 在后台运行 auth 的 Daemon
+func StartDaemon(t testing.TB, auth portal.Authenticator, c *config.Config) *Daemon {
+	t.Helper()
+	d := portal.NewDaemon(auth, c)
+	states, cancelSubscribe := d.Subscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		d.Run(ctx)
+	}()
+	td := &Daemon{Daemon: d, states: states}
+	stopped := false
+	td.stop = func() {
+		if stopped {
+			return
+		}
+		stopped = true
+		cancel()
+		select {
+		case <-done:
+		case <-time.After(10 * time.Second):
+			t.Error("Run did not return after cancel")
+		}
+		cancelSubscribe()
+	}
+	t.Cleanup(td.stop)
+	return td
+}
+
+// Stop 取消 Run 并等待其退出
+func (d *Daemon) Stop() {
+	d.stop()
+}
+
+// WaitFor 等待进入 state, 返回对应的 StateInfo
+func (d *Daemon) WaitFor(t testing.TB, state portal.State) portal.StateInfo {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for {
+		select {
+		case info := <-d.states:
+			if info.State == state {
+				return info
+			}
+		case <-timeout:
+			t.Fatalf("timed out waiting for %v, current state %+v", state, d.State())
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package shuclient
+
+import (
+	"golang.org/x/net/context"
+	"reflect"
+	"shunet/portal"
+	"shunet/shuclient/portaltest"
+	"testing"
+)
+
+func TestGetServices(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	s.Services = []string{"shu", "cmcc", "unicom"}
+	cfg := s.Config(t, "secret", "")
+	ctx := context.Background()
+
+	c, err := NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c.EnterLoginPageContext(ctx); err != nil {
+		t.Fatal(err)
+	}
+	services, err := c.GetServicesContext(ctx)
+	if err != nil || !reflect.DeepEqual(services, s.Services) {
+		t.Fatalf("GetServices = %v, %v, want %v", services, err, s.Services)
+	}
+
+	// 在线后门户不再跳转, 新的 Client 没有跳转参数
+	if err = c.Authenticator().(portal.Preparer).Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.Authenticator().Login(ctx); err != nil {
+		t.Fatal(err)
+	}
+	online, err := NewClient(s.Config(t, "secret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = online.GetServicesContext(ctx); err == nil {
+		t.Error("GetServices succeeded while online without a saved session")
+	}
+
+	// 使用保存的会话中的跳转参数
+	if err = c.SaveSession(); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	services, err = restored.GetServicesContext(ctx)
+	if err != nil || !reflect.DeepEqual(services, s.Services) {
+		t.Errorf("GetServices with the saved session = %v, %v, want %v", services, err, s.Services)
+	}
+}
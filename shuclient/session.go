@@ -0,0 +1,157 @@
+package shuclient
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"shunet/rsa"
+	"time"
+)
+
+// sessionFile 以配置区分, 不同配置的会话与 cookie 互不覆盖
+const sessionFile = "session.json"
+
+// session 是持久化到状态目录的登录会话, 重启后先用它保活, 避免重新登录
+type session struct {
+	Host                      string                    `json:"host"`
+	UserId                    string                    `json:"userId"`
+	UserIndex                 string                    `json:"userIndex"`
+	TopSelfLocationHref       string                    `json:"topSelfLocationHref,omitempty"`
+	TopSelfLocationHrefParams map[string]string         `json:"topSelfLocationHrefParams,omitempty"`
+	PublicKeyExponent         string                    `json:"publicKeyExponent,omitempty"`
+	PublicKeyModulus          string                    `json:"publicKeyModulus,omitempty"`
+	PasswordEncrypt           string                    `json:"passwordEncrypt,omitempty"`
+	Mac                       string                    `json:"mac,omitempty"`
+	KeepAliveInterval         int                       `json:"keepaliveInterval,omitempty"`
+	Cookies                   map[string][]*http.Cookie `json:"cookies,omitempty"` // 请求地址 -> cookie
+	SavedAt                   time.Time                 `json:"savedAt"`
+}
+
+func (c *Client) sessionPath() (string, error) {
+	return c.cfg.StatePath(sessionFile)
+}
+
+// cookieURLs 是门户设置 cookie 的路径
+func (c *Client) cookieURLs() []string {
+	return []string{c.hostUrl + "/", c.hostUrl + "/eportal/"}
+}
+
+// SaveSession 把当前会话写入状态目录
+func (c *Client) SaveSession() error {
+	if len(c.userIndex) == 0 {
+		return errors.New("userIndex is empty")
+	}
+	path, err := c.sessionPath()
+	if err != nil {
+		return err
+	}
+
+	s := session{
+		Host:                      c.hostUrl,
+		UserId:                    c.cfg.UserId,
+		UserIndex:                 c.userIndex,
+		TopSelfLocationHref:       c.topSelfLocationHref,
+		TopSelfLocationHrefParams: c.topSelfLocationHrefParams,
+		PublicKeyExponent:         c.cfg.PublicKeyExponent,
+		PublicKeyModulus:          c.cfg.PublicKeyModulus,
+		PasswordEncrypt:           c.cfg.PasswordEncrypt,
+		Mac:                       c.cfg.Mac,
+		KeepAliveInterval:         int(c.keepAliveInterval / time.Second),
+		Cookies:                   make(map[string][]*http.Cookie),
+		SavedAt:                   time.Now(),
+	}
+	if c.httpClient.Jar != nil {
+		for _, raw := range c.cookieURLs() {
+			u, err := url.Parse(raw)
+			if err != nil {
+				return err
+			}
+			if cookies := c.httpClient.Jar.Cookies(u); len(cookies) > 0 {
+				s.Cookies[raw] = cookies
+			}
+		}
+	}
+
+	bytes, err := json.MarshalIndent(&s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	// 先写临时文件再重命名, 避免写一半时退出
+	tmp := path + ".tmp"
+	if err = os.WriteFile(tmp, bytes, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// readSession 读取之前保存的会话, 会话不存在或不属于当前账号和门户时返回 nil
+func (c *Client) readSession() (*session, error) {
+	path, err := c.sessionPath()
+	if err != nil {
+		return nil, err
+	}
+	bytes, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var s session
+	if err = json.Unmarshal(bytes, &s); err != nil {
+		return nil, err
+	}
+	if s.Host != c.hostUrl || s.UserId != c.cfg.UserId || len(s.UserIndex) == 0 {
+		return nil, nil
+	}
+	return &s, nil
+}
+
+// LoadSession 恢复之前保存的会话, 会话不存在或不属于当前账号和门户时返回 false
+func (c *Client) LoadSession() (bool, error) {
+	s, err := c.readSession()
+	if err != nil || s == nil {
+		return false, err
+	}
+
+	c.userIndex = s.UserIndex
+	c.topSelfLocationHref = s.TopSelfLocationHref
+	c.topSelfLocationHrefParams = s.TopSelfLocationHrefParams
+	c.keepAliveInterval = time.Duration(s.KeepAliveInterval) * time.Second
+	if len(s.PublicKeyExponent) > 0 && len(s.PublicKeyModulus) > 0 {
+		c.cfg.PublicKeyExponent, c.cfg.PublicKeyModulus = s.PublicKeyExponent, s.PublicKeyModulus
+		c.rsa = rsa.NewRSAPair(s.PublicKeyExponent, "", s.PublicKeyModulus)
+	}
+	if len(s.PasswordEncrypt) > 0 {
+		c.cfg.PasswordEncrypt = s.PasswordEncrypt
+	}
+	if len(s.Mac) > 0 {
+		c.cfg.Mac = s.Mac
+	}
+	if c.httpClient.Jar != nil {
+		for raw, cookies := range s.Cookies {
+			if u, err := url.Parse(raw); err == nil {
+				c.httpClient.Jar.SetCookies(u, cookies)
+			}
+		}
+	}
+	return true, nil
+}
+
+// ClearSession 删除保存的会话
+func (c *Client) ClearSession() error {
+	path, err := c.sessionPath()
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+package shuclient
+
+import (
+	"golang.org/x/net/context"
+	"os"
+	"runtime"
+	"shunet/portal"
+	"shunet/shuclient/portaltest"
+	"testing"
+)
+
+func TestSession(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	cfg := s.Config(t, "secret", "")
+	ctx := context.Background()
+
+	c, err := NewClient(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SaveSession(); err == nil {
+		t.Error("SaveSession before login succeeded")
+	}
+	auth := c.Authenticator()
+	if _, err = auth.Detect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = auth.(portal.Preparer).Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = auth.Login(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = c.SaveSession(); err != nil {
+		t.Fatal(err)
+	}
+	path, _ := cfg.StatePath(sessionFile)
+	if fi, err := os.Stat(path); err != nil {
+		t.Fatal(err)
+	} else if runtime.GOOS != "windows" && fi.Mode().Perm() != 0600 {
+		t.Errorf("session file mode = %v, want 0600", fi.Mode().Perm())
+	}
+
+	// 重启后的新 Client 使用保存的会话保活, 不重新登录
+	restore := func() (bool, error) {
+		c, err := NewClient(cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return c.Authenticator().(portal.SessionStore).RestoreSession(ctx)
+	}
+	if ok, err := restore(); !ok || err != nil {
+		t.Errorf("RestoreSession = %v, %v, want restored", ok, err)
+	}
+	if n := s.Calls(portaltest.EndpointLogin); n != 1 {
+		t.Errorf("%d logins, want 1", n)
+	}
+
+	// 门户侧会话已失效
+	s.ExpireSessions()
+	if ok, err := restore(); ok || err == nil {
+		t.Errorf("RestoreSession of an expired session = %v, %v", ok, err)
+	}
+
+	// 其他配置文件不使用该会话
+	other := s.Config(t, "secret", "")
+	other.StateDir = cfg.StateDir
+	oc, err := NewClient(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := oc.LoadSession(); ok || err != nil {
+		t.Errorf("LoadSession of another config = %v, %v", ok, err)
+	}
+
+	if err = c.ClearSession(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("session file left after ClearSession: %v", err)
+	}
+	if err = c.ClearSession(); err != nil {
+		t.Errorf("second ClearSession: %v", err)
+	}
+}
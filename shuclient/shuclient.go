@@ -4,22 +4,21 @@ import (
 	"encoding/json"
 	"fmt"
 	"golang.org/x/net/context"
-	"io"
 	"net/http"
-	"net/http/cookiejar"
 	"net/url"
-	"os"
 	"shunet/config"
+	"shunet/portal"
 	"shunet/rsa"
 	"shunet/utils"
 	"strings"
+	"sync/atomic"
 	"time"
+	"unicode/utf8"
 )
 
 var (
-	topSelfLocationHrefPattern = `<script>top\.self\.location\.href='([^']*)'</script>`
-	LoginSuccessPattern        = `<title>登录成功</title>`
-	defaultHeader              = map[string]string{
+	LoginSuccessPattern = `<title>登录成功</title>`
+	defaultHeader       = map[string]string{
 		"User-Agent":      "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36",
 		"Accept":          "*/*",
 		"Accept-Language": "en,zh;q=0.9,zh-CN;q=0.8",
@@ -44,40 +43,41 @@ type Client struct {
 	topSelfLocationHrefParams map[string]string
 	referer                   string
 	interfaceDoPath           string
-	userIndex                 string        // 服务器返回的用户索引
-	delayTime                 time.Duration // 重试&心跳时间间隔，单位秒
+	userIndex                 string                        // 服务器返回的用户索引
+	captcha                   CaptchaSolver                 // 验证码识别器
+	validCode                 string                        // 下一次登录提交的验证码
+	keepAliveInterval         time.Duration                 // 门户下发的心跳间隔
+	daemon                    atomic.Pointer[portal.Daemon] // 驱动该 Client 的 Daemon
 }
 
-func NewClient(c *config.Config) *Client {
-	hc := &http.Client{}
-	if jar, err := cookiejar.New(nil); err != nil {
-		hc.Jar = jar
-	}
-	if len(c.Proxy) > 0 {
-		// 创建一个代理地址
-		proxyURL, err := url.Parse(c.Proxy)
-		if err != nil {
-			log.Fatal(err)
-		}
-		// 创建一个 Transport 实例，并配置代理
-		hc.Transport = &http.Transport{
-			Proxy: http.ProxyURL(proxyURL),
-		}
+func NewClient(c *config.Config) (*Client, error) {
+	hc, err := portal.NewHTTPClient(c)
+	if err != nil {
+		return nil, err
 	}
 
-	delayTime := 60 * time.Second
-	if c.DelayTime > 0 {
-		delayTime = time.Duration(c.DelayTime) * time.Second
+	solver, err := NewCaptchaSolver(c.Captcha)
+	if err != nil {
+		return nil, err
 	}
-	return &Client{
-		cfg:             c,
-		header:          defaultHeader,
-		hostUrl:         "http://" + c.Host,
-		interfaceDoPath: "http://" + c.Host + "/eportal/InterFace.do?method=",
-		httpClient:      hc,
-		IsLogin:         false,
-		delayTime:       delayTime,
+
+	client := &Client{
+		cfg:        c,
+		header:     defaultHeader,
+		httpClient: hc,
+		IsLogin:    false,
+		captcha:    solver,
+	}
+	client.setHostUrl(portal.HostURL(c.Host))
+	if c.Discover.Enabled {
+		// 先使用上次发现的地址, 保证保存的会话能够恢复
+		if base, err := client.loadDiscovery(); err != nil {
+			log.Warningf("load discovered portal err: %+v", err)
+		} else if len(base) > 0 {
+			client.setHostUrl(base)
+		}
 	}
+	return client, nil
 }
 
 func setReqHeader(header map[string]string, r *http.Request) *http.Request {
@@ -88,7 +88,12 @@ func setReqHeader(header map[string]string, r *http.Request) *http.Request {
 }
 
 func (c *Client) EnterLoginPage() (page string, err error) {
-	req, err := http.NewRequest("GET", c.hostUrl, nil)
+	return c.EnterLoginPageContext(context.Background())
+}
+
+// EnterLoginPageContext 访问门户首页, 未登录时跟随跳转进入认证页面
+func (c *Client) EnterLoginPageContext(ctx context.Context) (page string, err error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.hostUrl, nil)
 	if err != nil {
 		return "", err
 	}
@@ -105,48 +110,49 @@ func (c *Client) EnterLoginPage() (page string, err error) {
 		return "", err
 	}
 
-	if redirectURL, err := utils.Match(page, topSelfLocationHrefPattern); err != nil {
+	p := ParsePage(resp, page)
+	switch {
+	case p.Success:
 		// 登录成功获取userIndex
-		if strings.Contains(page, LoginSuccessPattern) {
-			c.successPageUrl = resp.Request.URL.String()
-			if params, err := utils.DencodeParams(c.successPageUrl); err != nil {
-				return page, err
-			} else {
-				if v, ok := params["userIndex"]; ok {
-					c.userIndex = v
-					c.IsLogin = true
-					return page, nil
-				} else {
-					return page, fmt.Errorf("userIndex not found")
-				}
-			}
-		} else {
-			return page, err
-		}
-	} else {
-		c.topSelfLocationHref = redirectURL
-		if params, err := utils.DencodeParams(redirectURL); err != nil {
-			return "", err
-		} else {
-			c.topSelfLocationHrefParams = params
+		c.successPageUrl = p.URL.String()
+		if v := p.URL.Query().Get("userIndex"); len(v) > 0 {
+			c.userIndex = v
+			c.IsLogin = true
+			return page, nil
 		}
+		return page, fmt.Errorf("userIndex not found")
+	case p.Redirect != nil:
+		c.topSelfLocationHref = p.Redirect.String()
+	case strings.HasSuffix(p.URL.Path, loginPagePath):
+		// 首页以 3xx 跳转时已经停在认证页面
+		c.topSelfLocationHref = p.URL.String()
+	default:
+		return page, fmt.Errorf("neither redirect nor success page found at %s", p.URL)
 	}
 
+	if params, err := utils.DencodeParams(c.topSelfLocationHref); err != nil {
+		return "", err
+	} else {
+		c.topSelfLocationHrefParams = params
+	}
 	if v, ok := c.topSelfLocationHrefParams["mac"]; ok {
 		c.cfg.Mac = v
 	}
 
-	if page, err = c.enterTopSelfLocation(); err != nil {
+	if p.Redirect == nil {
+		return page, nil
+	}
+	if page, err = c.enterTopSelfLocation(ctx); err != nil {
 		return "", err
 	}
 	return page, nil
 }
 
-func (c *Client) enterTopSelfLocation() (page string, err error) {
+func (c *Client) enterTopSelfLocation(ctx context.Context) (page string, err error) {
 	if c.topSelfLocationHref == "" {
 		return "", fmt.Errorf("topSelfLocationHref is empty")
 	}
-	req, err := http.NewRequest("GET", c.topSelfLocationHref, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", c.topSelfLocationHref, nil)
 	if err != nil {
 		return "", err
 	}
@@ -167,13 +173,13 @@ func (c *Client) enterTopSelfLocation() (page string, err error) {
 	return page, nil
 }
 
-func (c *Client) interfaceDo(method string, formData map[string]string) (*http.Response, error) {
+func (c *Client) interfaceDo(ctx context.Context, method string, formData map[string]string) (*http.Response, error) {
 	data := url.Values{}
 	for key, value := range formData {
 		data.Set(key, value)
 	}
 
-	req, err := http.NewRequest("POST", c.interfaceDoPath+method, strings.NewReader(data.Encode()))
+	req, err := http.NewRequestWithContext(ctx, "POST", c.interfaceDoPath+method, strings.NewReader(data.Encode()))
 	if err != nil {
 		return nil, err
 	}
@@ -188,19 +194,38 @@ func (c *Client) interfaceDo(method string, formData map[string]string) (*http.R
 	return resp, nil
 }
 
+// readBody 读取接口返回的内容, 部分门户版本返回 GBK 编码的 JSON
+func readBody(resp *http.Response) ([]byte, error) {
+	s, err := utils.DecodeContent(resp)
+	if err != nil {
+		return nil, err
+	}
+	if !utf8.ValidString(s) {
+		if s, err = utils.ConvertGBKToUTF8([]byte(s)); err != nil {
+			return nil, err
+		}
+	}
+	return []byte(s), nil
+}
+
 func (c *Client) GetPageInfo() (*PageInfo, error) {
+	return c.GetPageInfoContext(context.Background())
+}
+
+// GetPageInfoContext 获取认证页面信息, 其中包含加密密码的公钥
+func (c *Client) GetPageInfoContext(ctx context.Context) (*PageInfo, error) {
 	if c.topSelfLocationHrefParams == nil {
 		return nil, fmt.Errorf("topSelfLocationHrefParams is nil")
 	}
 
 	param := make(map[string]string, 1)
 	param["queryString"] = utils.EncodeParams(c.topSelfLocationHrefParams)
-	resp, err := c.interfaceDo("pageInfo", param)
+	resp, err := c.interfaceDo(ctx, "pageInfo", param)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err := readBody(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -225,6 +250,11 @@ func (c *Client) GetPageInfo() (*PageInfo, error) {
 }
 
 func (c *Client) Login() (*LoginResponse, error) {
+	return c.LoginContext(context.Background())
+}
+
+// LoginContext 使用加密后的密码登录
+func (c *Client) LoginContext(ctx context.Context) (*LoginResponse, error) {
 	if c.rsa == nil {
 		return nil, fmt.Errorf("rsa is nil")
 	}
@@ -232,18 +262,21 @@ func (c *Client) Login() (*LoginResponse, error) {
 	param["queryString"] = utils.EncodeParams(c.topSelfLocationHrefParams)
 	param["userId"] = c.cfg.UserId
 	param["password"] = c.rsa.EncryptedPassword(c.cfg.Password, c.cfg.Mac)
-	param["service"] = "shu"
-	param["operatorPwd"] = ""
-	param["operatorUserId"] = ""
-	param["validcode"] = ""
+	param["service"] = c.cfg.Service
+	param["operatorPwd"] = c.cfg.OperatorPwd
+	param["operatorUserId"] = c.cfg.OperatorUserId
+	param["validcode"] = c.validCode
 	param["passwordEncrypt"] = c.cfg.PasswordEncrypt
 
-	resp, err := c.interfaceDo("login", param)
+	// 验证码只能使用一次
+	c.validCode = ""
+
+	resp, err := c.interfaceDo(ctx, "login", param)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err := readBody(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -253,24 +286,30 @@ func (c *Client) Login() (*LoginResponse, error) {
 	}
 	if loginResponse.Result == "success" {
 		c.IsLogin = true
+		c.keepAliveInterval = time.Duration(loginResponse.KeepAliveInterval) * time.Second
 	}
 	c.userIndex = loginResponse.UserIndex
 	return loginResponse, nil
 }
 
 func (c *Client) LogOut() (*GeneralResponse, error) {
+	return c.LogOutContext(context.Background())
+}
+
+// LogOutContext 使 userIndex 对应的会话下线
+func (c *Client) LogOutContext(ctx context.Context) (*GeneralResponse, error) {
 	if len(c.userIndex) == 0 {
 		return nil, fmt.Errorf("userIndex is empty")
 	}
 	param := make(map[string]string, 1)
 	param["userIndex"] = c.userIndex
 
-	resp, err := c.interfaceDo("logout", param)
+	resp, err := c.interfaceDo(ctx, "logout", param)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err := readBody(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -285,18 +324,23 @@ func (c *Client) LogOut() (*GeneralResponse, error) {
 }
 
 func (c *Client) KeepAlive() (*GeneralResponse, error) {
+	return c.KeepAliveContext(context.Background())
+}
+
+// KeepAliveContext 发送一次心跳
+func (c *Client) KeepAliveContext(ctx context.Context) (*GeneralResponse, error) {
 	if len(c.userIndex) == 0 {
 		return nil, fmt.Errorf("userIndex is empty")
 	}
 	param := make(map[string]string, 1)
 	param["userIndex"] = c.userIndex
 
-	resp, err := c.interfaceDo("keepalive", param)
+	resp, err := c.interfaceDo(ctx, "keepalive", param)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
+	body, err := readBody(resp)
 	if err != nil {
 		return nil, err
 	}
@@ -307,82 +351,76 @@ func (c *Client) KeepAlive() (*GeneralResponse, error) {
 	return keepAliveResponse, nil
 }
 
-func (c *Client) Run(ctx context.Context) {
-	// 启动时记录Pid
-	c.cfg.Pid = os.Getpid()
-	if err := c.cfg.Save(); err != nil {
-		log.Fatalf("Failed to save config: %v", err)
-	}
-	ticker := time.Tick(c.delayTime)
-	for {
-		switch c.IsLogin {
-		case true:
-			resp, err := c.KeepAlive()
-			if err != nil {
-				c.IsLogin = false
-				log.Errorf("err: %+v", err)
-				break
-			}
-			if resp.Result != "success" {
-				c.IsLogin = false
-			}
-			log.Info("KeepAlive")
-		case false:
-			if _, err := c.EnterLoginPage(); err != nil {
-				c.IsLogin = false
-				log.Errorf("EnterLoginPage err: %+v", err)
-				break
-			}
-			log.Info("EnterLoginPage")
-
-			if c.IsLogin {
-				log.Warning("already login, skip login")
-				break
-			}
-
-			if _, err := c.GetPageInfo(); err != nil {
-				c.IsLogin = false
-				log.Errorf("GetPageInfo err: %v", err)
-				break
-			}
-			log.Info("GetPageInfo")
-
-			resp, err := c.Login()
-			if err != nil {
-				c.IsLogin = false
-				log.Errorf("Login err: %+v", err)
-				break
-			}
-			if resp.Result != "success" {
-				c.IsLogin = false
-				log.Warning("Login fail, but no err")
-			} else {
-				log.Info("Login success")
-			}
+// GetServices 获取门户可选的服务(运营商套餐), 门户返回以 @ 分隔的服务名
+func (c *Client) GetServices() ([]string, error) {
+	return c.GetServicesContext(context.Background())
+}
 
+// GetServicesContext 需要认证页面的跳转参数, 在线时使用保存的会话中的参数
+func (c *Client) GetServicesContext(ctx context.Context) ([]string, error) {
+	if c.topSelfLocationHrefParams == nil {
+		s, err := c.readSession()
+		if err != nil {
+			log.Warningf("read saved session err: %+v", err)
 		}
-		log.Infof("Sleep %v", c.delayTime.String())
-		select {
-		case <-ctx.Done():
-			log.Info("Client.Run Receive stop signal, Client Run exit")
-			if !c.IsLogin {
-				log.Info("Already logout!")
-			} else {
-				if _, err := c.LogOut(); err != nil {
-					log.Errorf("Client.Run Logout err: %+v", err)
-				}
-			}
-			// 退出时清空Pid
-			c.cfg.Pid = 0
-			if err := c.cfg.Save(); err != nil {
-				log.Errorf("Client.Run Save err: %+v", err)
-			}
-			return
-		case <-ticker:
+		if s == nil || len(s.TopSelfLocationHrefParams) == 0 {
+			return nil, fmt.Errorf("services are not available while online without a saved session, logout first")
 		}
+		c.topSelfLocationHrefParams = s.TopSelfLocationHrefParams
+	}
+	param := make(map[string]string, 1)
+	param["queryString"] = utils.EncodeParams(c.topSelfLocationHrefParams)
+	resp, err := c.interfaceDo(ctx, "getServices", param)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("getServices: %s", resp.Status)
+	}
+
+	services := make([]string, 0)
+	for _, s := range strings.Split(strings.Trim(strings.TrimSpace(string(body)), `"`), "@") {
+		if s = strings.TrimSpace(s); len(s) > 0 {
+			services = append(services, s)
+		}
+	}
+	return services, nil
+}
+
+// GetOnlineUserInfo 查询 userIndex 对应的在线用户信息
+func (c *Client) GetOnlineUserInfo(userIndex string) (*OnlineUserInfo, error) {
+	return c.GetOnlineUserInfoContext(context.Background(), userIndex)
+}
+
+func (c *Client) GetOnlineUserInfoContext(ctx context.Context, userIndex string) (*OnlineUserInfo, error) {
+	if len(userIndex) == 0 {
+		return nil, fmt.Errorf("userIndex is empty")
+	}
+	param := make(map[string]string, 1)
+	param["userIndex"] = userIndex
+
+	resp, err := c.interfaceDo(ctx, "getOnlineUserInfo", param)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := readBody(resp)
+	if err != nil {
+		return nil, err
+	}
+	info := &OnlineUserInfo{}
+	if err = json.Unmarshal(body, info); err != nil {
+		return nil, err
 	}
+	return info, nil
 }
 
-func (c *Client) save() error {
-	return c.cfg.Save()
+// UserIndex 返回登录或访问成功页面时得到的 userIndex
+func (c *Client) UserIndex() string {
+	return c.userIndex
 }
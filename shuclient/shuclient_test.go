@@ -0,0 +1,55 @@
+package shuclient
+
+import (
+	"golang.org/x/net/context"
+	"shunet/portal"
+	"shunet/shuclient/portaltest"
+	"testing"
+)
+
+func TestGetOnlineUserInfo(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	c, err := NewClient(s.Config(t, "secret", ""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	auth := c.Authenticator()
+	if _, err = auth.Detect(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = auth.(portal.Preparer).Prepare(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err = auth.Login(ctx); err != nil {
+		t.Fatal(err)
+	}
+	sess := s.Sessions()[0]
+
+	tests := []struct {
+		userIndex string
+		result    string
+	}{
+		{sess.UserIndex, "success"},
+		{"0123456789abcdef", "fail"},
+	}
+	for _, tt := range tests {
+		info, err := c.GetOnlineUserInfoContext(ctx, tt.userIndex)
+		if err != nil {
+			t.Fatalf("GetOnlineUserInfo(%s): %v", tt.userIndex, err)
+		}
+		if info.Result != tt.result {
+			t.Errorf("GetOnlineUserInfo(%s).Result = %q, want %q", tt.userIndex, info.Result, tt.result)
+		}
+	}
+
+	info, _ := c.GetOnlineUserInfoContext(ctx, sess.UserIndex)
+	if info.UserId != s.UserId || info.UserIp != sess.IP || info.UserMac != s.Mac || info.Service != sess.Service || info.AccountFee != s.AccountFee {
+		t.Errorf("GetOnlineUserInfo = %+v, want session %+v", info, sess)
+	}
+	balls, err := info.Balls()
+	if err != nil || len(balls) != 2 || balls[0].DisplayName != "在线时长" {
+		t.Errorf("Balls = %+v, %v", balls, err)
+	}
+}
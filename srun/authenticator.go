@@ -0,0 +1,130 @@
+package srun
+
+import (
+	"fmt"
+	"golang.org/x/net/context"
+	"shunet/portal"
+	"strconv"
+	"time"
+)
+
+// srun 把 Client 适配为 portal.Authenticator
+type srun struct {
+	c *Client
+}
+
+// Authenticator 返回深澜门户的 portal.Authenticator 实现
+func (c *Client) Authenticator() portal.Authenticator {
+	return &srun{c: c}
+}
+
+func (s *srun) Detect(ctx context.Context) (bool, error) {
+	c := s.c
+	if len(c.acId) == 0 {
+		if acId, err := c.DetectAcId(ctx); err != nil {
+			log.Warningf("DetectAcId err: %+v, use ac_id=1", err)
+		} else {
+			c.acId = acId
+			log.Infof("DetectAcId: %s", acId)
+		}
+	}
+	info, err := c.UserInfo(ctx)
+	if err != nil {
+		return false, err
+	}
+	log.Info("rad_user_info")
+	if len(c.cfg.Srun.Ip) == 0 && len(info.ClientIp) > 0 {
+		c.ip = info.ClientIp
+	}
+	return info.Online(), nil
+}
+
+func (s *srun) PortalHost() string {
+	return s.c.hostUrl
+}
+
+func (s *srun) Prepare(ctx context.Context) error {
+	if _, err := s.c.GetChallenge(ctx); err != nil {
+		return err
+	}
+	log.Info("get_challenge")
+	return nil
+}
+
+func (s *srun) Login(ctx context.Context) error {
+	resp, err := s.c.Login(ctx)
+	if err != nil {
+		return err
+	}
+	if resp.AlreadyOnline() {
+		// 会话可能刚由其他设备或上一次请求建立, 确认在线后按登录成功处理
+		if info, err := s.c.UserInfo(ctx); err == nil && info.Online() {
+			log.Info("Already online")
+			return nil
+		}
+	}
+	return resp.Err()
+}
+
+// KeepAlive 深澜没有心跳接口, 通过 rad_user_info 确认仍然在线
+func (s *srun) KeepAlive(ctx context.Context) error {
+	info, err := s.c.UserInfo(ctx)
+	if err != nil {
+		return err
+	}
+	if !info.Online() {
+		return fmt.Errorf("not online: %s", info.Error)
+	}
+	return nil
+}
+
+func (s *srun) Logout(ctx context.Context) error {
+	resp, err := s.c.Logout(ctx)
+	if err != nil {
+		return err
+	}
+	return resp.Err()
+}
+
+func (s *srun) Status(ctx context.Context) (*portal.Status, error) {
+	info, err := s.c.UserInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !info.Online() {
+		return &portal.Status{Online: false}, nil
+	}
+
+	status := &portal.Status{
+		Online:  true,
+		UserId:  info.UserName,
+		IP:      info.OnlineIp,
+		MAC:     info.UserMac,
+		Service: info.ProductName,
+		Balance: strconv.FormatFloat(info.UserBalance, 'f', 2, 64),
+		Items: []portal.Item{
+			{Name: "已用流量", Value: formatBytes(info.SumBytes)},
+			{Name: "已用时长", Value: (time.Duration(info.SumSeconds) * time.Second).String()},
+		},
+	}
+	if info.AddTime > 0 {
+		status.Items = append(status.Items, portal.Item{
+			Name:  "登录时间",
+			Value: time.Unix(info.AddTime, 0).Format("2006-01-02 15:04:05"),
+		})
+	}
+	return status, nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%dB", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.2f%cB", float64(n)/float64(div), "KMGTPE"[exp])
+}
@@ -0,0 +1,116 @@
+package srun
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+)
+
+// encoding 是深澜使用的自定义字母表 Base64
+var encoding = base64.NewEncoding("LVoJPiCN2R8G90yg+hmFHuacZ1OWMnrsSTXkYpUq/3dlbfKwv6xztjI7DeBE45QA")
+
+// delta 是 xEncode 每轮累加的常量
+const delta uint32 = 0x9E3779B9
+
+// encodeWords 按小端把字符串转为 uint32 数组, withLen 时在末尾附加字符串长度
+func encodeWords(s string, withLen bool) []uint32 {
+	v := make([]uint32, (len(s)+3)/4)
+	for i := 0; i < len(s); i++ {
+		v[i>>2] |= uint32(s[i]) << ((i & 3) * 8)
+	}
+	if withLen {
+		v = append(v, uint32(len(s)))
+	}
+	return v
+}
+
+// decodeWords 按小端把 uint32 数组转回字符串
+func decodeWords(v []uint32) string {
+	b := make([]byte, len(v)<<2)
+	for i, w := range v {
+		b[i<<2] = byte(w)
+		b[i<<2+1] = byte(w >> 8)
+		b[i<<2+2] = byte(w >> 16)
+		b[i<<2+3] = byte(w >> 24)
+	}
+	return string(b)
+}
+
+// key 把 token 转为 xEncode 的密钥, 不足 4 个字时补 0
+func key(token string) []uint32 {
+	k := encodeWords(token, false)
+	for len(k) < 4 {
+		k = append(k, 0)
+	}
+	return k
+}
+
+// mx 是 xEncode 每一步的混淆函数, 与深澜 portal 的 js 实现一致
+func mx(z, y, d uint32, p int, e uint32, k []uint32) uint32 {
+	m := z>>5 ^ y<<2
+	m += (y>>3 ^ z<<4) ^ (d ^ y)
+	m += k[(uint32(p)&3)^e] ^ z
+	return m
+}
+
+// xEncode 深澜 portal 中对 info 的加密, 是 XXTEA 的变种
+func xEncode(msg, token string) string {
+	if len(msg) == 0 {
+		return ""
+	}
+	v := encodeWords(msg, true)
+	k := key(token)
+	n := len(v) - 1
+	z := v[n]
+	var d uint32
+	for q := 6 + 52/(n+1); q > 0; q-- {
+		d += delta
+		e := d >> 2 & 3
+		p := 0
+		for ; p < n; p++ {
+			v[p] += mx(z, v[p+1], d, p, e, k)
+			z = v[p]
+		}
+		v[n] += mx(z, v[0], d, p, e, k)
+		z = v[n]
+	}
+	return decodeWords(v)
+}
+
+// info 是登录时提交的用户信息
+type info struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Ip       string `json:"ip"`
+	AcId     string `json:"acid"`
+	EncVer   string `json:"enc_ver"`
+}
+
+// encodeInfo 生成登录参数 info: "{SRBX1}" + Base64(xEncode(json, token))
+func encodeInfo(username, password, ip, acId, token string) (string, error) {
+	b, err := json.Marshal(info{Username: username, Password: password, Ip: ip, AcId: acId, EncVer: "srun_bx1"})
+	if err != nil {
+		return "", err
+	}
+	return "{SRBX1}" + encoding.EncodeToString([]byte(xEncode(string(b), token))), nil
+}
+
+// hmacMD5 以 token 为密钥计算密码的 HMAC-MD5
+func hmacMD5(password, token string) string {
+	mac := hmac.New(md5.New, []byte(token))
+	mac.Write([]byte(password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// checksum 计算登录参数 chksum
+func checksum(token, username, hmd5, acId, ip, n, typ, info string) string {
+	var s string
+	for _, v := range []string{username, hmd5, acId, ip, n, typ, info} {
+		s += token + v
+	}
+	sum := sha1.Sum([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
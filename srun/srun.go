@@ -0,0 +1,267 @@
+// Package srun 实现深澜(Srun)门户的认证: /cgi-bin/get_challenge 获取 token,
+// /cgi-bin/srun_portal 登录与下线, /cgi-bin/rad_user_info 查询在线信息.
+package srun
+
+import (
+	"encoding/json"
+	"fmt"
+	"golang.org/x/net/context"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime"
+	"shunet/config"
+	"shunet/portal"
+	"shunet/utils"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	callback  = "jQuery112406951885120277062_1700000000000"
+	loginN    = "200"
+	loginType = "1"
+)
+
+var (
+	userAgent = "Mozilla/5.0 (Macintosh; Intel Mac OS X 10_15_7) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/126.0.0.0 Safari/537.36"
+	log       = utils.Log
+)
+
+type ChallengeResponse struct {
+	Challenge string `json:"challenge"`
+	ClientIp  string `json:"client_ip"`
+	Error     string `json:"error"`
+	ErrorMsg  string `json:"error_msg"`
+	Res       string `json:"res"`
+}
+
+type PortalResponse struct {
+	Error    string `json:"error"`
+	ErrorMsg string `json:"error_msg"`
+	Res      string `json:"res"`
+	SucMsg   string `json:"suc_msg"`
+	PloyMsg  string `json:"ploy_msg"`
+	ClientIp string `json:"client_ip"`
+	OnlineIp string `json:"online_ip"`
+}
+
+// Err 在登录或下线失败时返回 *portal.PortalError
+func (r *PortalResponse) Err() error {
+	if r.Error == "ok" || r.Res == "ok" {
+		return nil
+	}
+	msg := r.ErrorMsg
+	if len(msg) == 0 {
+		msg = r.Error
+	}
+	if len(r.PloyMsg) > 0 {
+		msg += " " + r.PloyMsg
+	}
+	return &portal.PortalError{Kind: classify(r.Error, msg), Message: msg}
+}
+
+// AlreadyOnline 判断登录是否因已经在线而失败(E2620)
+func (r *PortalResponse) AlreadyOnline() bool {
+	return strings.HasPrefix(r.Error, "E2620") || strings.HasPrefix(r.ErrorMsg, "E2620")
+}
+
+type UserInfo struct {
+	Error       string  `json:"error"`
+	UserName    string  `json:"user_name"`
+	OnlineIp    string  `json:"online_ip"`
+	ClientIp    string  `json:"client_ip"`
+	UserMac     string  `json:"user_mac"`
+	SumBytes    int64   `json:"sum_bytes"`
+	SumSeconds  int64   `json:"sum_seconds"`
+	AddTime     int64   `json:"add_time"` // 登录时间, unix 秒
+	UserBalance float64 `json:"user_balance"`
+	ProductName string  `json:"products_name"`
+}
+
+// Online 判断 rad_user_info 是否显示在线
+func (u *UserInfo) Online() bool {
+	return u.Error == "ok"
+}
+
+// errorKinds 深澜的错误码, 未列出的按 message 文本分类
+var errorKinds = map[string]error{
+	"E2531":              portal.ErrWrongPassword, // User not found
+	"E2553":              portal.ErrWrongPassword, // Password is error
+	"E2606":              portal.ErrAccountLocked, // User is disabled
+	"E2616":              portal.ErrAccountArrears,
+	"E2620":              portal.ErrUnknown, // You are already online, Login 先确认是否确实在线
+	"E2833":              portal.ErrServiceUnavailable,
+	"online_num_error":   portal.ErrDeviceLimit,
+	"auth_resault_error": portal.ErrWrongPassword,
+}
+
+func classify(code, message string) error {
+	for prefix, kind := range errorKinds {
+		if strings.HasPrefix(code, prefix) || strings.HasPrefix(message, prefix) {
+			return kind
+		}
+	}
+	return portal.ClassifyMessage(message)
+}
+
+type Client struct {
+	cfg        *config.Config
+	httpClient *http.Client
+	hostUrl    string
+	acId       string
+	ip         string
+	token      string
+}
+
+func NewClient(c *config.Config) (*Client, error) {
+	hc, err := portal.NewHTTPClient(c)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		cfg:        c,
+		httpClient: hc,
+		hostUrl:    portal.HostURL(c.Host),
+		acId:       c.Srun.AcId,
+		ip:         c.Srun.Ip,
+	}, nil
+}
+
+func (c *Client) username() string {
+	return c.cfg.UserId + c.cfg.Srun.Domain
+}
+
+// get 请求 JSONP 接口, 去掉回调函数后解析到 v
+func (c *Client) get(ctx context.Context, path string, params url.Values, v any) error {
+	params.Set("callback", callback)
+	params.Set("_", strconv.FormatInt(time.Now().UnixMilli(), 10))
+	req, err := http.NewRequestWithContext(ctx, "GET", c.hostUrl+path+"?"+params.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s: %s", path, resp.Status)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(trimJSONP(body), v)
+}
+
+func trimJSONP(body []byte) []byte {
+	s := strings.TrimSpace(string(body))
+	if i := strings.Index(s, "("); i >= 0 && strings.HasSuffix(s, ")") && !strings.HasPrefix(s, "{") {
+		s = s[i+1 : len(s)-1]
+	}
+	return []byte(s)
+}
+
+// DetectAcId 从首页跳转地址中获取 ac_id
+func (c *Client) DetectAcId(ctx context.Context) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", c.hostUrl+"/", nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if v := resp.Request.URL.Query().Get("ac_id"); len(v) > 0 {
+		return v, nil
+	}
+	page, err := utils.DecodeContent(resp)
+	if err != nil {
+		return "", err
+	}
+	return utils.Match(page, `ac_id=(\d+)`)
+}
+
+func (c *Client) UserInfo(ctx context.Context) (*UserInfo, error) {
+	info := &UserInfo{}
+	if err := c.get(ctx, "/cgi-bin/rad_user_info", url.Values{}, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+func (c *Client) GetChallenge(ctx context.Context) (*ChallengeResponse, error) {
+	params := url.Values{}
+	params.Set("username", c.username())
+	params.Set("ip", c.ip)
+	resp := &ChallengeResponse{}
+	if err := c.get(ctx, "/cgi-bin/get_challenge", params, resp); err != nil {
+		return nil, err
+	}
+	if len(resp.Challenge) == 0 {
+		return resp, fmt.Errorf("get_challenge: %s %s", resp.Error, resp.ErrorMsg)
+	}
+	c.token = resp.Challenge
+	if len(c.cfg.Srun.Ip) == 0 && len(resp.ClientIp) > 0 {
+		c.ip = resp.ClientIp
+	}
+	return resp, nil
+}
+
+func (c *Client) Login(ctx context.Context) (*PortalResponse, error) {
+	if len(c.token) == 0 {
+		return nil, fmt.Errorf("token is empty")
+	}
+	if len(c.acId) == 0 {
+		c.acId = "1"
+	}
+	username := c.username()
+	hmd5 := hmacMD5(c.cfg.Password, c.token)
+	info, err := encodeInfo(username, c.cfg.Password, c.ip, c.acId, c.token)
+	if err != nil {
+		return nil, err
+	}
+
+	params := url.Values{}
+	params.Set("action", "login")
+	params.Set("username", username)
+	params.Set("password", "{MD5}"+hmd5)
+	params.Set("os", runtime.GOOS)
+	params.Set("name", runtime.GOOS)
+	params.Set("double_stack", "0")
+	params.Set("chksum", checksum(c.token, username, hmd5, c.acId, c.ip, loginN, loginType, info))
+	params.Set("info", info)
+	params.Set("ac_id", c.acId)
+	params.Set("ip", c.ip)
+	params.Set("n", loginN)
+	params.Set("type", loginType)
+
+	// token 只能使用一次
+	c.token = ""
+	resp := &PortalResponse{}
+	if err = c.get(ctx, "/cgi-bin/srun_portal", params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) Logout(ctx context.Context) (*PortalResponse, error) {
+	if len(c.acId) == 0 {
+		c.acId = "1"
+	}
+	params := url.Values{}
+	params.Set("action", "logout")
+	params.Set("username", c.username())
+	params.Set("ip", c.ip)
+	params.Set("ac_id", c.acId)
+	resp := &PortalResponse{}
+	if err := c.get(ctx, "/cgi-bin/srun_portal", params, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
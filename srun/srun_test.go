@@ -0,0 +1,175 @@
+package srun
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"golang.org/x/net/context"
+	"shunet/portal"
+	"shunet/shuclient/portaltest"
+	"shunet/srun/sruntest"
+	"testing"
+	"time"
+)
+
+func newTestClient(t *testing.T, s *sruntest.Server, password string) portal.Authenticator {
+	t.Helper()
+	c, err := NewClient(portaltest.LoadConfig(t, fmt.Sprintf("portal: srun\nuserId: %s\npassword: %s\nhost: %s", s.Username, password, s.Host())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.Authenticator()
+}
+
+func TestLoginLogout(t *testing.T) {
+	s := sruntest.NewServer("20120001", "secret")
+	defer s.Close()
+	s.AcId = "3"
+	auth := newTestClient(t, s, "secret")
+	ctx := context.Background()
+
+	online, err := auth.Detect(ctx)
+	if err != nil || online {
+		t.Fatalf("Detect = %v, %v, want offline", online, err)
+	}
+	if err = auth.(portal.Preparer).Prepare(ctx); err != nil {
+		t.Fatalf("Prepare: %v", err)
+	}
+	if err = auth.Login(ctx); err != nil {
+		t.Fatalf("Login: %v", err)
+	}
+	sessions := s.Sessions()
+	if len(sessions) != 1 || sessions[0].AcId != "3" {
+		t.Fatalf("sessions = %+v, want one session with ac_id 3", sessions)
+	}
+	if err = auth.KeepAlive(ctx); err != nil {
+		t.Fatalf("KeepAlive: %v", err)
+	}
+	status, err := auth.Status(ctx)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	if !status.Online || status.UserId != s.Username || status.MAC != s.Mac {
+		t.Errorf("Status = %+v", status)
+	}
+
+	if err = auth.Logout(ctx); err != nil {
+		t.Fatalf("Logout: %v", err)
+	}
+	if n := len(s.Sessions()); n != 0 {
+		t.Errorf("%d sessions after logout", n)
+	}
+	if err = auth.KeepAlive(ctx); err == nil {
+		t.Error("KeepAlive succeeded after logout")
+	}
+}
+
+func TestLoginError(t *testing.T) {
+	const alreadyOnline = "E2620: You are already online."
+	tests := []struct {
+		name     string
+		password string
+		fail     string // 非空时登录接口以该 error_msg 失败
+		online   bool   // 登录前已有会话
+		want     error
+		message  string
+	}{
+		{name: "wrong password", password: "wrong", want: portal.ErrWrongPassword, message: sruntest.MessageWrongPassword},
+		{name: "already online", password: "secret", fail: alreadyOnline, online: true},
+		{name: "already online but not", password: "secret", fail: alreadyOnline, want: portal.ErrUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := sruntest.NewServer("20120001", "secret")
+			defer s.Close()
+			auth := newTestClient(t, s, tt.password)
+			ctx := context.Background()
+			if _, err := auth.Detect(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if err := auth.(portal.Preparer).Prepare(ctx); err != nil {
+				t.Fatal(err)
+			}
+			if len(tt.fail) > 0 {
+				s.Inject(sruntest.EndpointLogin, sruntest.Failure{Message: tt.fail})
+			}
+			if tt.online {
+				s.PutSession("127.0.0.1", sruntest.Session{Username: s.Username, IP: "127.0.0.1", AcId: s.AcId, LoginTime: time.Now()})
+			}
+			err := auth.Login(ctx)
+			if !errors.Is(err, tt.want) || (err == nil) != (tt.want == nil) {
+				t.Fatalf("Login err = %v, want %v", err, tt.want)
+			}
+			var pe *portal.PortalError
+			if len(tt.message) > 0 && (!errors.As(err, &pe) || pe.Message != tt.message) {
+				t.Errorf("Login err = %#v, want message %q", err, tt.message)
+			}
+		})
+	}
+}
+
+// 以下向量由独立于本实现的、逐行移植自深澜 portal js 的脚本生成
+
+func TestXEncode(t *testing.T) {
+	tests := []struct {
+		msg, token, want string
+	}{
+		{"", "token", ""},
+		{"hello", "token", "bb00d00c966e78bfb80ef138"},
+		{"a", "", "8e261d97add8f456"},
+	}
+	for _, tt := range tests {
+		if got := hex.EncodeToString([]byte(xEncode(tt.msg, tt.token))); got != tt.want {
+			t.Errorf("xEncode(%q, %q) = %s, want %s", tt.msg, tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestEncoding(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"", ""},
+		{"f", "1S=="},
+		{"fo", "1U4="},
+		{"foo", "1U5w"},
+		{"foobar", "1U5wZUix"},
+	}
+	for _, tt := range tests {
+		if got := encoding.EncodeToString([]byte(tt.in)); got != tt.want {
+			t.Errorf("encode(%q) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestEncodeInfo(t *testing.T) {
+	got, err := encodeInfo("20120001", "secret", "10.0.0.2", "1", "8b3ec1b0c2f0a1e94f1f3d2e6a7b9c0d")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{SRBX1}9GeGJR7rOPQ7rW420jrhPedrWQ0Ra+J2eePy/oPA1L3GjybhkDsvod7qJeXACB3Vu5PpI3mgJ3dzpTpm/45PVOnWQY8hUF/el7skYnHYPDgW/1yGeLvStSAMKNST5Efa"
+	if got != want {
+		t.Errorf("encodeInfo = %s, want %s", got, want)
+	}
+}
+
+func TestHmacMD5(t *testing.T) {
+	tests := []struct {
+		password, token, want string
+	}{
+		{"secret", "token", "1c618aedf7ce9649a7f3c059475fb320"},
+		{"", "", "74e6f7298a9c2d168935f58c001bad88"},
+	}
+	for _, tt := range tests {
+		if got := hmacMD5(tt.password, tt.token); got != tt.want {
+			t.Errorf("hmacMD5(%q, %q) = %s, want %s", tt.password, tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	got := checksum("token", "user", "hmd5", "1", "10.0.0.2", "200", "1", "{SRBX1}info")
+	if want := "a4f39bb764e52e21967ef267512f466ffd829013"; got != want {
+		t.Errorf("checksum = %s, want %s", got, want)
+	}
+}
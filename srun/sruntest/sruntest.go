@@ -0,0 +1,354 @@
+// Package sruntest 提供一个进程内的深澜(Srun)门户模拟服务器, 用于离线测试 srun.
+//
+// 模拟的接口与 srun.Client 访问的一致: 根页面跳转到带 ac_id 的登录页,
+// /cgi-bin/get_challenge 下发一次性 token, /cgi-bin/srun_portal 登录与下线,
+// /cgi-bin/rad_user_info 查询在线信息. 所有接口均以 JSONP 返回.
+// 服务器自行解码 info 并校验 chksum 与 HMAC-MD5 密码, 不依赖 srun 包的实现.
+package sruntest
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"shunet/shuclient/portaltest"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 可注入故障的接口名
+const (
+	EndpointRoot      = "root"
+	EndpointChallenge = "get_challenge"
+	EndpointLogin     = "login"
+	EndpointLogout    = "logout"
+	EndpointUserInfo  = "rad_user_info"
+)
+
+// 门户返回的错误信息
+const (
+	MessageWrongPassword = "E2553: Password is error."
+	MessageUserNotFound  = "E2531: User not found."
+	MessageArrears       = "E2616: Arrearage users."
+	MessageDisabled      = "E2606: User is disabled."
+	MessageSignError     = "sign_error"
+	MessageChallenge     = "challenge_expire_error"
+)
+
+var alphabet = base64.NewEncoding("LVoJPiCN2R8G90yg+hmFHuacZ1OWMnrsSTXkYpUq/3dlbfKwv6xztjI7DeBE45QA")
+
+// Failure 描述一次注入的故障, Message 以 error=login_error 及该 error_msg 返回
+type Failure = portaltest.Failure
+
+// Session 是服务器上的一个在线会话
+type Session struct {
+	Username  string
+	IP        string
+	AcId      string
+	LoginTime time.Time
+}
+
+func (s Session) LoginAt() time.Time {
+	return s.LoginTime
+}
+
+// Server 是模拟的深澜服务器, 导出字段需在发起请求前设置
+type Server struct {
+	*httptest.Server
+	*portaltest.Fixture[Session] // 会话以 IP 为键
+
+	Username string // 完整的用户名, 包含 domain
+	Password string
+	AcId     string  // 根页面跳转中下发的 ac_id, 登录时校验
+	Mac      string  // rad_user_info 返回的 user_mac
+	Balance  float64 // rad_user_info 返回的 user_balance
+	Product  string  // rad_user_info 返回的 products_name
+
+	mu     sync.Mutex
+	tokens map[string]string // ip -> 未使用的 token
+}
+
+// NewServer 启动一个模拟服务器, 使用完毕后需调用 Close
+func NewServer(username, password string) *Server {
+	s := &Server{
+		Username: username,
+		Password: password,
+		AcId:     "1",
+		Mac:      "00:e0:4c:68:00:01",
+		Balance:  30,
+		Product:  "校园网",
+		tokens:   make(map[string]string),
+	}
+	s.Fixture = portaltest.NewFixture[Session](func(w http.ResponseWriter, r *http.Request, f Failure) {
+		writeJSONP(w, r, map[string]any{"error": "login_error", "res": "login_error", "error_msg": f.Message})
+	})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.handleRoot)
+	mux.HandleFunc("/srun_portal_pc", s.handlePortalPage)
+	mux.HandleFunc("/cgi-bin/get_challenge", s.handleChallenge)
+	mux.HandleFunc("/cgi-bin/srun_portal", s.handlePortal)
+	mux.HandleFunc("/cgi-bin/rad_user_info", s.handleUserInfo)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+// Host 返回可直接填入 config.Config.Host 的地址
+func (s *Server) Host() string {
+	return strings.TrimPrefix(s.URL, "http://")
+}
+
+// intercept 执行注入的故障, 返回 true 表示请求已被处理
+func (s *Server) intercept(endpoint string, w http.ResponseWriter, r *http.Request) bool {
+	_, done := s.Intercept(endpoint, w, r)
+	return done
+}
+
+func writeJSONP(w http.ResponseWriter, r *http.Request, v any) {
+	b, _ := json.Marshal(v)
+	w.Header().Set("Content-Type", "text/javascript;charset=UTF-8")
+	if cb := r.URL.Query().Get("callback"); len(cb) > 0 {
+		fmt.Fprintf(w, "%s(%s)", cb, b)
+		return
+	}
+	_, _ = w.Write(b)
+}
+
+func (s *Server) handleRoot(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	if s.intercept(EndpointRoot, w, r) {
+		return
+	}
+	http.Redirect(w, r, "/srun_portal_pc?ac_id="+s.AcId+"&theme=pro", http.StatusFound)
+}
+
+func (s *Server) handlePortalPage(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	fmt.Fprint(w, "<html><head><title>深澜认证</title></head><body></body></html>")
+}
+
+func (s *Server) handleChallenge(w http.ResponseWriter, r *http.Request) {
+	if s.intercept(EndpointChallenge, w, r) {
+		return
+	}
+	ip := r.URL.Query().Get("ip")
+	if len(ip) == 0 {
+		ip = portaltest.RemoteIP(r)
+	}
+	token := newToken()
+	s.mu.Lock()
+	s.tokens[ip] = token
+	s.mu.Unlock()
+	writeJSONP(w, r, map[string]any{
+		"challenge": token,
+		"client_ip": ip,
+		"error":     "ok",
+		"res":       "ok",
+		"online_ip": ip,
+		"st":        time.Now().Unix(),
+	})
+}
+
+func (s *Server) handlePortal(w http.ResponseWriter, r *http.Request) {
+	switch r.URL.Query().Get("action") {
+	case "login":
+		if s.intercept(EndpointLogin, w, r) {
+			return
+		}
+		s.login(w, r)
+	case "logout":
+		if s.intercept(EndpointLogout, w, r) {
+			return
+		}
+		s.logout(w, r)
+	default:
+		writeJSONP(w, r, map[string]any{"error": "action_error", "res": "action_error"})
+	}
+}
+
+func (s *Server) login(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	ip, acId, username := q.Get("ip"), q.Get("ac_id"), q.Get("username")
+	fail := func(msg string) {
+		writeJSONP(w, r, map[string]any{"error": "login_error", "res": "login_error", "error_msg": msg, "client_ip": ip})
+	}
+
+	s.mu.Lock()
+	token, ok := s.tokens[ip]
+	delete(s.tokens, ip)
+	s.mu.Unlock()
+	if !ok {
+		fail(MessageChallenge)
+		return
+	}
+
+	var sum string
+	for _, k := range []string{"username", "password", "ac_id", "ip", "n", "type", "info"} {
+		v := q.Get(k)
+		if k == "password" {
+			v = strings.TrimPrefix(v, "{MD5}")
+		}
+		sum += token + v
+	}
+	digest := sha1.Sum([]byte(sum))
+	if hex.EncodeToString(digest[:]) != q.Get("chksum") {
+		fail(MessageSignError)
+		return
+	}
+
+	info, err := decodeInfo(q.Get("info"), token)
+	if err != nil || info.Username != username || info.Ip != ip || info.AcId != acId {
+		fail(MessageSignError)
+		return
+	}
+
+	mac := hmac.New(md5.New, []byte(token))
+	mac.Write([]byte(s.Password))
+	switch {
+	case username != s.Username:
+		fail(MessageUserNotFound)
+		return
+	case q.Get("password") != "{MD5}"+hex.EncodeToString(mac.Sum(nil)) || info.Password != s.Password:
+		fail(MessageWrongPassword)
+		return
+	case acId != s.AcId:
+		fail("E2901: ac_id error")
+		return
+	}
+
+	s.PutSession(ip, Session{Username: username, IP: ip, AcId: acId, LoginTime: time.Now()})
+	writeJSONP(w, r, map[string]any{
+		"error":     "ok",
+		"res":       "ok",
+		"suc_msg":   "login_ok",
+		"client_ip": ip,
+		"online_ip": ip,
+	})
+}
+
+func (s *Server) logout(w http.ResponseWriter, r *http.Request) {
+	ip := r.URL.Query().Get("ip")
+	if len(ip) == 0 {
+		ip = portaltest.RemoteIP(r)
+	}
+	if !s.DeleteSession(ip) {
+		writeJSONP(w, r, map[string]any{"error": "not_online_error", "res": "not_online_error", "error_msg": "You are not online."})
+		return
+	}
+	writeJSONP(w, r, map[string]any{"error": "ok", "res": "ok", "suc_msg": "logout_ok"})
+}
+
+func (s *Server) handleUserInfo(w http.ResponseWriter, r *http.Request) {
+	if s.intercept(EndpointUserInfo, w, r) {
+		return
+	}
+	ip := portaltest.RemoteIP(r)
+	sess, ok := s.Session(ip)
+	if !ok {
+		writeJSONP(w, r, map[string]any{"error": "not_online_error", "client_ip": ip, "online_ip": ip})
+		return
+	}
+	writeJSONP(w, r, map[string]any{
+		"error":         "ok",
+		"user_name":     sess.Username,
+		"online_ip":     sess.IP,
+		"client_ip":     ip,
+		"user_mac":      s.Mac,
+		"add_time":      sess.LoginTime.Unix(),
+		"sum_bytes":     int64(1536) << 20,
+		"sum_seconds":   int64(time.Since(sess.LoginTime).Seconds()),
+		"user_balance":  s.Balance,
+		"products_name": s.Product,
+	})
+}
+
+type loginInfo struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+	Ip       string `json:"ip"`
+	AcId     string `json:"acid"`
+	EncVer   string `json:"enc_ver"`
+}
+
+// decodeInfo 解码登录参数 info: 去掉 {SRBX1} 前缀, 自定义 Base64 解码后 XXTEA 解密
+func decodeInfo(s, token string) (*loginInfo, error) {
+	if !strings.HasPrefix(s, "{SRBX1}") {
+		return nil, fmt.Errorf("info: missing prefix")
+	}
+	b, err := alphabet.DecodeString(strings.TrimPrefix(s, "{SRBX1}"))
+	if err != nil {
+		return nil, err
+	}
+	plain, err := xDecode(b, token)
+	if err != nil {
+		return nil, err
+	}
+	info := &loginInfo{}
+	if err = json.Unmarshal(plain, info); err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// xDecode 是 xEncode 的逆运算, 密文末尾的字记录明文长度
+func xDecode(b []byte, token string) ([]byte, error) {
+	if len(b) < 8 || len(b)%4 != 0 {
+		return nil, fmt.Errorf("xDecode: invalid length %d", len(b))
+	}
+	v := make([]uint32, len(b)/4)
+	for i := range v {
+		v[i] = uint32(b[4*i]) | uint32(b[4*i+1])<<8 | uint32(b[4*i+2])<<16 | uint32(b[4*i+3])<<24
+	}
+	var k [4]uint32
+	for i := 0; i < len(token) && i < 16; i++ {
+		k[i/4] |= uint32(token[i]) << (8 * (i % 4))
+	}
+
+	const delta = 0x9E3779B9
+	n := len(v) - 1
+	mx := func(z, y, sum uint32, p int, e uint32) uint32 {
+		return (z>>5 ^ y<<2) + ((y>>3 ^ z<<4) ^ (sum ^ y)) + (k[uint32(p)&3^e] ^ z)
+	}
+	rounds := 6 + 52/(n+1)
+	sum := uint32(rounds) * delta
+	for ; rounds > 0; rounds-- {
+		e := sum >> 2 & 3
+		for p := n; p > 0; p-- {
+			y := v[0]
+			if p < n {
+				y = v[p+1]
+			}
+			v[p] -= mx(v[p-1], y, sum, p, e)
+		}
+		v[0] -= mx(v[n], v[1], sum, 0, e)
+		sum -= delta
+	}
+
+	length := int(v[n])
+	if length > 4*n || length < 4*n-3 {
+		return nil, fmt.Errorf("xDecode: invalid plain length %d", length)
+	}
+	out := make([]byte, 4*n)
+	for i := 0; i < n; i++ {
+		out[4*i] = byte(v[i])
+		out[4*i+1] = byte(v[i] >> 8)
+		out[4*i+2] = byte(v[i] >> 16)
+		out[4*i+3] = byte(v[i] >> 24)
+	}
+	return out[:length], nil
+}
+
+func newToken() string {
+	b := make([]byte, 32)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"shunet/config"
+	"shunet/instance"
+	"shunet/portal"
+	"text/tabwriter"
+	"time"
+
+	"golang.org/x/net/context"
+)
+
+// statusOutput 是 status -json 的输出, 在门户状态之外附带运行中进程的状态
+type statusOutput struct {
+	*portal.Status
+	Daemon *portal.StatusResponse `json:"daemon,omitempty"`
+}
+
+// runStatus 查询运行中的进程及当前在线会话并打印, 不在线时以 exitOffline 退出
+func runStatus(cfg *config.Config, args []string) error {
+	fs := flag.NewFlagSet("status", flag.ExitOnError)
+	asJSON := fs.Bool("json", *jsonOutput, "print status as JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var daemon *portal.StatusResponse
+	if client, err := controlClient(cfg); err == nil {
+		if daemon, err = client.Status(ctx); err != nil {
+			log.Warningf("Query running shunet err: %v", err)
+		}
+	} else if !errors.Is(err, instance.ErrNotRunning) {
+		log.Warningf("Query running shunet err: %v", err)
+	}
+
+	auth, err := newAuthenticator(cfg)
+	if err != nil {
+		return err
+	}
+	status, err := auth.Status(ctx)
+	if err != nil {
+		return err
+	}
+
+	offline := &exitError{code: exitOffline}
+	if *asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(statusOutput{Status: status, Daemon: daemon}); err != nil || status.Online {
+			return err
+		}
+		return offline
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	defer w.Flush()
+	if daemon == nil {
+		fmt.Fprintln(w, "Daemon\tnot running")
+	} else {
+		fmt.Fprintf(w, "Daemon\t%s since %s\n", daemon.State, daemon.Since.Local().Format(time.DateTime))
+		if daemon.LastLogin != nil {
+			fmt.Fprintf(w, "LastLogin\t%s\n", daemon.LastLogin.Local().Format(time.DateTime))
+		}
+		if daemon.LastError != "" {
+			fmt.Fprintf(w, "LastError\t%s (%s)\n", daemon.LastError, daemon.LastErrorAt.Local().Format(time.DateTime))
+		}
+	}
+	if !status.Online {
+		fmt.Fprintln(w, "Status\toffline")
+		return offline
+	}
+	fmt.Fprintln(w, "Status\tonline")
+	fmt.Fprintf(w, "UserId\t%s\n", status.UserId)
+	fmt.Fprintf(w, "UserName\t%s\n", status.UserName)
+	fmt.Fprintf(w, "IP\t%s\n", status.IP)
+	fmt.Fprintf(w, "MAC\t%s\n", status.MAC)
+	fmt.Fprintf(w, "Service\t%s\n", status.Service)
+	fmt.Fprintf(w, "Balance\t%s\n", status.Balance)
+	for _, item := range status.Items {
+		fmt.Fprintf(w, "%s\t%s\n", item.Name, item.Value)
+	}
+	fmt.Fprintf(w, "Session\t%s\n", status.Session)
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"shunet/shuclient/portaltest"
+	"strings"
+	"testing"
+)
+
+func TestStatus(t *testing.T) {
+	s := portaltest.NewServer("20120001", "secret")
+	defer s.Close()
+	s.AccountFee = "12.34"
+	path := testConfig(t, s, "secret")
+	if code, out := runCLI(t, path, "login"); code != exitOK {
+		t.Fatalf("login = %d, %q", code, out)
+	}
+
+	code, out := runCLI(t, path, "status")
+	if code != exitOK {
+		t.Fatalf("status = %d, %q", code, out)
+	}
+	for _, want := range []string{"Daemon    not running", "Status    online", "UserId    20120001", "Balance   12.34", "在线时长", "Session   " + s.Sessions()[0].UserIndex} {
+		if !strings.Contains(out, want) {
+			t.Errorf("status output missing %q:\n%s", want, out)
+		}
+	}
+
+	code, out = runCLI(t, path, "--json", "status")
+	var status statusOutput
+	if err := json.Unmarshal([]byte(out), &status); err != nil || code != exitOK {
+		t.Fatalf("status -json = %d, %q, %v", code, out, err)
+	}
+	if !status.Online || status.UserId != s.UserId || status.Balance != "12.34" || status.Daemon != nil {
+		t.Errorf("status -json = %s", out)
+	}
+
+	runCLI(t, path, "logout")
+	if code, out = runCLI(t, path, "--json", "status"); code != exitOffline || !strings.Contains(out, `"online": false`) {
+		t.Errorf("status -json after logout = %d, %q", code, out)
+	}
+}
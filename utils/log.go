@@ -62,6 +62,8 @@ func SetLogLevel(level string) {
 		Log.SetLevel(logrus.DebugLevel)
 	case "info":
 		Log.SetLevel(logrus.InfoLevel)
+	case "warning", "warn":
+		Log.SetLevel(logrus.WarnLevel)
 	case "error":
 		Log.SetLevel(logrus.ErrorLevel)
 	default:
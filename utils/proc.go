@@ -1,5 +0,0 @@
-package utils
-
-func Kill(pid int) {
-	kill(pid)
-}